@@ -7,19 +7,27 @@ require (
 	github.com/CyCoreSystems/agi v0.6.1
 	github.com/CyCoreSystems/ari v5.0.0-pre5+incompatible
 	github.com/CyCoreSystems/ari-proxy v0.0.0-20190708005332-45b9a6d646d5
-	github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/apps/voiceTransscriber/service v0.0.0-20191027234536-a7894a9de1d9 // indirect
+	github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg v0.0.0
 	github.com/CyCoreSystems/audiosocket v0.2.0
 	github.com/ericchiang/k8s v1.2.0
 	github.com/fatih/color v1.7.0
+	github.com/go-redis/redis v6.15.2+incompatible
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/gofrs/uuid v3.2.0+incompatible
 	github.com/gogo/protobuf v1.2.1 // indirect
+	github.com/golang/protobuf v1.3.2
 	github.com/google/btree v1.0.0 // indirect
 	github.com/inconshreveable/log15 v0.0.0-20180818164646-67afb5ed74ec // indirect
+	github.com/mattn/go-colorable v0.1.4 // indirect
+	github.com/mattn/go-isatty v0.0.10 // indirect
 	github.com/nats-io/gnatsd v1.4.1 // indirect
 	github.com/nats-io/go-nats v0.0.0-20170814154326-b4479c874d87 // indirect
 	github.com/nats-io/nats v1.5.0 // indirect
+	github.com/nats-io/nats.go v1.8.1
 	github.com/pkg/errors v0.8.1
 	google.golang.org/genproto v0.0.0-20191009194640-548a555dbc03
+	gopkg.in/yaml.v2 v2.2.4 // indirect
 	rsc.io/binaryregexp v0.2.0 // indirect
 )
+
+replace github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg => ./live-demo/pkg
@@ -2,36 +2,246 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"os"
 	"strconv"
 	"time"
 
 	"github.com/CyCoreSystems/ari"
 	"github.com/CyCoreSystems/ari/ext/play"
-	"github.com/ericchiang/k8s"
-	"github.com/ericchiang/k8s/apis/apps/v1"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/apps/dtmfScaler/deployment"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/ivr"
 	"github.com/pkg/errors"
 )
 
+// defaultMenuPath is the location of the IVR definition, which is normally
+// mounted from a ConfigMap.  It may be overridden by the MENU_DEFINITION
+// environment variable.
+const defaultMenuPath = "/etc/dtmf-scaler/menu.yaml"
+
+// defaultMenu is the IVR definition used when none is mounted.
+//
+// The caller selects a target with a single key (1 for asterisk, 2 for the
+// proxies or 3 for the media relay), hears its current size, enters a new
+// size terminated by the pound key (or cancels with star), and confirms it.
+// Callers who select a target which cannot be scaled hear its size and that
+// the feature is not available.  Once scaled, the caller is told when the target is ready, or
+// how many of its instances are ready if it takes too long.
+const defaultMenu = `
+start: menu
+nodes:
+  menu:
+    prompts:
+      - sound: sound:hello
+      - sound: sound:press-1
+      - sound: sound:press-2
+      - sound: sound:press-3
+    input:
+      var: target
+      maxDigits: 1
+    retries: 3
+    transitions:
+      - pattern: "^[0-9]$"
+        next: check
+    noMatch: menuInvalid
+    exhausted: failed
+
+  menuInvalid:
+    prompts:
+      - sound: sound:option-is-invalid
+    next: menu
+
+  check:
+    action:
+      name: scalable
+      args:
+        target: "{{.target}}"
+      outcomes:
+        unknown: menuInvalid
+        unscalable: unscalable
+    next: announce
+
+  unscalable:
+    action:
+      name: status
+      args:
+        target: "{{.target}}"
+      outcomes:
+        error: unavailable
+    prompts:
+      - sound: sound:currently
+      - sound: "number:{{.current}}"
+      - sound: sound:feature-not-avail-line
+    next: menu
+
+  announce:
+    action:
+      name: status
+      args:
+        target: "{{.target}}"
+      outcomes:
+        unknown: menuInvalid
+        error: unavailable
+    prompts:
+      - sound: sound:currently
+      - sound: "number:{{.current}}"
+    next: entry
+
+  unavailable:
+    prompts:
+      - sound: sound:an-error-has-occurred
+    visits: 3
+    exhausted: failed
+    next: menu
+
+  entry:
+    prompts:
+      - sound: sound:please-enter-your
+      - sound: sound:number
+      - sound: sound:followed-by
+      - sound: sound:pound
+    input:
+      var: count
+      terminator: "#"
+    retries: 3
+    transitions:
+      - dtmf: "*"
+        next: cancelled
+      - pattern: "^[0-9]{1,3}$"
+        next: validate
+    noMatch: entryInvalid
+    exhausted: failed
+
+  entryInvalid:
+    prompts:
+      - sound: sound:option-is-invalid
+    next: entry
+
+  validate:
+    action:
+      name: validate
+      args:
+        target: "{{.target}}"
+        count: "{{.count}}"
+      outcomes:
+        invalid: rejected
+    next: confirm
+
+  rejected:
+    prompts:
+      - sound: sound:you-entered
+      - sound: "number:{{.count}}"
+      - sound: sound:option-is-invalid
+      - sound: sound:please-enter-a-number-between
+      - sound: "number:{{.min}}"
+      - sound: sound:and
+      - sound: "number:{{.max}}"
+    visits: 3
+    exhausted: failed
+    next: entry
+
+  confirm:
+    prompts:
+      - sound: sound:you-entered
+      - sound: "number:{{.count}}"
+      - sound: sound:press-1
+      - sound: sound:to-confirm
+      - sound: sound:vm-star-cancel
+    input:
+      maxDigits: 1
+    retries: 3
+    transitions:
+      - dtmf: "1"
+        next: scale
+      - dtmf: "*"
+        next: cancelled
+    noMatch: confirmInvalid
+    exhausted: failed
+
+  confirmInvalid:
+    prompts:
+      - sound: sound:option-is-invalid
+    next: confirm
+
+  cancelled:
+    prompts:
+      - sound: sound:cancelled
+    next: menu
+
+  scale:
+    action:
+      name: scale
+      args:
+        target: "{{.target}}"
+        count: "{{.count}}"
+      outcomes:
+        unscalable: unscalable
+    prompts:
+      - sound: sound:auth-thankyou
+      - sound: sound:one-moment-please
+    next: settle
+
+  settle:
+    action:
+      name: ready
+      args:
+        target: "{{.target}}"
+      outcomes:
+        pending: pending
+        error: unavailable
+    prompts:
+      - sound: sound:activated
+    next: menu
+
+  pending:
+    prompts:
+      - sound: sound:currently
+      - sound: "number:{{.ready}}"
+      - sound: sound:activated
+    next: menu
+
+  failed:
+    prompts:
+      - sound: sound:an-error-has-occurred
+    next: hangup
+
+  hangup:
+    action:
+      name: hangup
+`
+
+// ScaleWaitTimeout is the maximum time for which a caller is kept waiting
+// for the instances of a scaled target to become ready
+var ScaleWaitTimeout = 30 * time.Second
+
+var menu *ivr.Source
+
+func init() {
+	path := os.Getenv("MENU_DEFINITION")
+	if path == "" {
+		path = defaultMenuPath
+	}
+	menu = ivr.NewSource(path, []byte(defaultMenu))
+}
+
 // State is the structure for storing application execution data
 type State struct {
 	h *ari.ChannelHandle
-
-	tries int
 }
 
-type stateFn func(context.Context) (stateFn, error)
-
 func app(ctx context.Context, h *ari.ChannelHandle) error {
 	log.Println("running channel app")
 
 	// Always quit on hangup
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	go func() {
 		sub := h.Subscribe(ari.Events.ChannelDestroyed)
 		defer sub.Cancel()
 		select {
 		case <-sub.Events():
+			log.Println("caller hung up")
+			cancel()
 		case <-ctx.Done():
 		}
 	}()
@@ -39,90 +249,205 @@ func app(ctx context.Context, h *ari.ChannelHandle) error {
 	h.Answer()
 	time.Sleep(time.Second)
 
+	def, err := menu.Definition()
+	if def == nil {
+		return errors.Wrap(err, "failed to load menu")
+	}
+	if err != nil {
+		log.Println("using previous menu:", err)
+	}
+
 	// Create the state struct
 	s := &State{
 		h: h,
 	}
 
-	// Run state machine
-	var err error
-	for next := s.menu; next != nil; {
-		if next, err = next(ctx); err != nil {
-			if iErr := invalid(ctx, h); iErr != nil {
-				log.Println("failed to play invalid message:", iErr)
-			}
+	e := ivr.NewEngine(def, s)
+	e.Register("status", s.status)
+	e.Register("scalable", s.scalable)
+	e.Register("validate", s.validate)
+	e.Register("scale", s.scale)
+	e.Register("ready", s.ready)
 
+	// Run state machine
+	if err = e.Run(ctx, &ivr.Call{ID: h.ID()}); err != nil && err != ivr.ErrHangup {
+		if ctx.Err() != nil {
 			return err
 		}
+		if iErr := invalid(ctx, h); iErr != nil {
+			log.Println("failed to play invalid message:", iErr)
+		}
+
+		return err
 	}
 	return nil
 }
 
-func (s *State) menu(ctx context.Context) (stateFn, error) {
-	if s.tries > 3 {
-		return nil, errors.New("too many retries")
+// Play implements ivr.Channel
+func (s *State) Play(ctx context.Context, prompts []ivr.Prompt) error {
+	uris := soundURIs(prompts)
+	if len(uris) == 0 {
+		return nil
 	}
-	s.tries++
+	return play.Play(ctx, s.h, play.URI(uris...)).Err()
+}
 
-	ret, err := play.Prompt(ctx, s.h, play.URI("sound:hello", "sound:please-enter-your", "sound:number")).Result()
+// Collect implements ivr.Channel
+func (s *State) Collect(ctx context.Context, prompts []ivr.Prompt, c *ivr.Collector) (*ivr.Response, error) {
+	ret, err := play.Prompt(ctx, s.h,
+		play.URI(soundURIs(prompts)...),
+		play.DigitTimeouts(c.Timeout, c.Timeout, play.DefaultOverallDigitTimeout),
+		play.MatchFunc(func(pat string) (string, play.MatchResult) {
+			digits, m := c.MatchDigits(pat)
+			switch m {
+			case ivr.DigitsComplete:
+				return digits, play.Complete
+			case ivr.DigitsInvalid:
+				return digits, play.Invalid
+			default:
+				return digits, play.Incomplete
+			}
+		}),
+	).Result()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to play prompt")
 	}
 
-	switch ret.MatchResult {
-	case play.Complete:
-	case play.Incomplete:
-		return s.menu, nil
-	default:
-		return nil, errors.New("invalid DTMF entry")
+	if ret.MatchResult == play.Incomplete {
+		return new(ivr.Response), nil
 	}
+	return &ivr.Response{DTMF: ret.DTMF}, nil
+}
 
-	size, err := strconv.Atoi(ret.DTMF)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to convert DTMF entry to an integer")
+func soundURIs(prompts []ivr.Prompt) (uris []string) {
+	for _, p := range prompts {
+		if p.Sound == "" {
+			log.Println("ignoring prompt without sound:", p.Text)
+			continue
+		}
+		uris = append(uris, p.Sound)
 	}
-	return s.reply(size), nil
+	return
 }
 
-func (s *State) reply(size int) func(context.Context) (stateFn, error) {
-	return func(ctx context.Context) (stateFn, error) {
-		log.Println("announcing new size:", size)
-		err := play.Play(ctx, s.h, play.URI("sound:you-entered", fmt.Sprintf("digits:%d", size))).Err()
-		return s.scale(size), err
+func (s *State) target(args map[string]string) (*scaleTarget, error) {
+	t := targetByKey(args["target"])
+	if t == nil {
+		return nil, errors.Errorf("unknown target %q", args["target"])
 	}
+	return t, nil
 }
 
-func (s *State) scale(size int) func(context.Context) (stateFn, error) {
-	return func(ctx context.Context) (stateFn, error) {
+// status sets the current size and permitted bounds of the target.  Its
+// outcome is "unknown" if no target has the given key, and "error" if the
+// status could not be retrieved.
+func (s *State) status(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	t := targetByKey(args["target"])
+	if t == nil {
+		return "unknown", nil
+	}
 
-		if err := scaleAsterisk(ctx, int32(size)); err != nil {
-			return nil, errors.Wrap(err, "failed to scale asterisk deployment")
-		}
-		log.Println("scaled asterisk to ", size, "replicas")
-		return nil, nil
+	status, err := scaler.Get(ctx, t.Target)
+	if err != nil {
+		log.Println("failed to get status of", t, err)
+		return "error", nil
 	}
+	log.Printf("%s has %d of %d instances ready", t, status.Ready, status.Desired)
+
+	call.Vars["current"] = strconv.Itoa(int(status.Desired))
+	call.Vars["ready"] = strconv.Itoa(int(status.Ready))
+	call.Vars["min"] = strconv.Itoa(int(t.Min))
+	call.Vars["max"] = strconv.Itoa(int(t.Max))
+	return "", nil
 }
 
-func invalid(ctx context.Context, h *ari.ChannelHandle) error {
-	return play.Play(ctx, h, play.URI("sound:an-error-has-occurred")).Err()
+// scalable has the outcome "unscalable" if the target cannot be scaled by
+// the caller, such as the kamailio DaemonSet, which runs one pod on each
+// node of its pool, and "unknown" if no target has the given key
+func (s *State) scalable(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	t := targetByKey(args["target"])
+	if t == nil {
+		return "unknown", nil
+	}
+	if t.Validate(t.Max) == deployment.ErrNotScalable {
+		return "unscalable", nil
+	}
+	return "", nil
 }
 
-func scaleAsterisk(ctx context.Context, size int32) error {
-	k, err := k8s.NewInClusterClient()
+// validate has the outcome "invalid" if the count is outside of the policy
+// of the target
+func (s *State) validate(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	t, err := s.target(args)
 	if err != nil {
-		return errors.Wrap(err, "failed to get kubernetes client")
+		return "", err
 	}
 
-	d := new(v1.Deployment)
-	if err = k.Get(ctx, "voip", "asterisk", d); err != nil {
-		return errors.Wrap(err, "failed to retrieve current asterisk deployment")
+	count, err := strconv.Atoi(args["count"])
+	if err != nil {
+		return "invalid", nil
 	}
+	if err := t.Validate(int32(count)); err != nil {
+		log.Printf("rejected entry %d for %s: %v", count, t, err)
+		return "invalid", nil
+	}
+	return "", nil
+}
 
-	current := d.GetSpec().GetReplicas()
-	if current == size {
-		return nil
+// scale scales the target.  Its outcome is "unscalable" if the target cannot
+// be scaled.
+func (s *State) scale(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	t, err := s.target(args)
+	if err != nil {
+		return "", err
 	}
 
-	d.GetSpec().Replicas = &size
-	return k.Update(ctx, d)
+	count, err := strconv.Atoi(args["count"])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to convert DTMF entry to an integer")
+	}
+	if err := t.Validate(int32(count)); err != nil {
+		return "", err
+	}
+
+	err = scaler.Scale(ctx, t.Target, int32(count))
+	if err == deployment.ErrNotScalable {
+		return "unscalable", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to scale %s", t)
+	}
+	log.Println("scaled", t, "to", count, "replicas")
+	return "", nil
+}
+
+// ready waits for the target to converge on its new size, for up to
+// ScaleWaitTimeout, setting the number of its ready instances.  Its outcome
+// is "pending" if it has not converged in time, and "error" if its status
+// could not be retrieved.
+func (s *State) ready(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	t, err := s.target(args)
+	if err != nil {
+		return "", err
+	}
+
+	wCtx, cancel := context.WithTimeout(ctx, ScaleWaitTimeout)
+	defer cancel()
+
+	status, err := scaler.Wait(wCtx, t.Target)
+	if status == nil {
+		log.Println("failed to get status of", t, err)
+		return "error", nil
+	}
+	call.Vars["ready"] = strconv.Itoa(int(status.Ready))
+	if err != nil {
+		log.Printf("%s is not yet ready: %v", t, err)
+		return "pending", nil
+	}
+	log.Printf("%s is ready with %d instances", t, status.Ready)
+	return "", nil
+}
+
+func invalid(ctx context.Context, h *ari.ChannelHandle) error {
+	return play.Play(ctx, h, play.URI("sound:an-error-has-occurred")).Err()
 }
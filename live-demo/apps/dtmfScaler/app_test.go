@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/ivr"
+)
+
+func TestDefaultMenu(t *testing.T) {
+	if _, err := ivr.Parse([]byte(defaultMenu)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScalable(t *testing.T) {
+	s := new(State)
+	tests := map[string]string{
+		"1": "",
+		"2": "unscalable",
+		"3": "unscalable",
+		"4": "unknown",
+		"":  "unknown",
+	}
+	for key, want := range tests {
+		got, err := s.scalable(context.Background(), &ivr.Call{}, map[string]string{"target": key})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", key, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: outcome %q, want %q", key, got, want)
+		}
+	}
+}
@@ -1,24 +1,237 @@
+// Package deployment provides the kubernetes workload inspection and scaling
+// primitives shared by the scaler applications.
 package deployment
 
 import (
+	"context"
+	"time"
+
 	"github.com/ericchiang/k8s"
+	v1 "github.com/ericchiang/k8s/apis/apps/v1"
 	"github.com/pkg/errors"
 )
 
-// Scale changes the number of app instances
-func Scale(namespace string, app string, n *int32) error {
-	c, err := k8s.NewInClusterClient()
+// Kind indicates the type of kubernetes workload
+type Kind string
+
+const (
+	// Deployment is an apps/v1 Deployment
+	Deployment Kind = "Deployment"
+
+	// StatefulSet is an apps/v1 StatefulSet
+	StatefulSet Kind = "StatefulSet"
+
+	// DaemonSet is an apps/v1 DaemonSet
+	DaemonSet Kind = "DaemonSet"
+)
+
+// ErrNotScalable indicates that the workload's size is not controlled by a
+// replica count and so cannot be scaled directly.
+var ErrNotScalable = errors.New("workload cannot be scaled by replica count")
+
+// PollInterval is the interval at which Wait checks the state of a workload
+var PollInterval = 2 * time.Second
+
+// Target identifies a workload
+type Target struct {
+	Kind      Kind
+	Namespace string
+	Name      string
+}
+
+// String implements fmt.Stringer
+func (t Target) String() string {
+	return string(t.Kind) + " " + t.Namespace + "/" + t.Name
+}
+
+// Status describes the current state of a workload
+type Status struct {
+	Target
+
+	// Desired is the number of instances which should exist.  For
+	// DaemonSets, this is the number of nodes on which a Pod should be
+	// scheduled.
+	Desired int32
+
+	// Current is the number of instances which do exist
+	Current int32
+
+	// Updated is the number of instances which are running the current
+	// specification
+	Updated int32
+
+	// Ready is the number of instances which are ready to serve
+	Ready int32
+
+	// Generation is the generation of the specification
+	Generation int64
+
+	// ObservedGeneration is the generation of the specification most
+	// recently acted upon by the controller
+	ObservedGeneration int64
+
+	// Autoscaler is the HorizontalPodAutoscaler which manages the
+	// workload, if any
+	Autoscaler *Autoscaler
+}
+
+// Converged indicates whether the workload has settled at its desired state
+func (s *Status) Converged() bool {
+	return s.ObservedGeneration >= s.Generation &&
+		s.Current == s.Desired &&
+		s.Updated == s.Desired &&
+		s.Ready == s.Desired
+}
+
+// Client inspects and scales workloads
+type Client struct {
+	// AutoscalerMode describes how workloads managed by a
+	// HorizontalPodAutoscaler are scaled
+	AutoscalerMode AutoscalerMode
+
+	// PinDuration is the length of time for which a change to the bounds
+	// of an autoscaler is held before it is reverted.  If zero, the change
+	// is permanent.
+	PinDuration time.Duration
+
+	k *k8s.Client
+}
+
+// New returns a Client which uses the in-cluster kubernetes configuration
+func New() (*Client, error) {
+	k, err := k8s.NewInClusterClient()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get k8s client")
+		return nil, errors.Wrap(err, "failed to get kubernetes client")
+	}
+	return NewWithClient(k), nil
+}
+
+// NewWithClient returns a Client which uses the given kubernetes client
+func NewWithClient(k *k8s.Client) *Client {
+	return &Client{k: k}
+}
+
+// Get returns the current status of the workload
+func (c *Client) Get(ctx context.Context, t Target) (*Status, error) {
+	s := &Status{Target: t}
+
+	switch t.Kind {
+	case Deployment:
+		d := new(v1.Deployment)
+		if err := c.k.Get(ctx, t.Namespace, t.Name, d); err != nil {
+			return nil, errors.Wrapf(err, "failed to retrieve %s", t)
+		}
+		s.Desired = d.GetSpec().GetReplicas()
+		s.Current = d.GetStatus().GetReplicas()
+		s.Updated = d.GetStatus().GetUpdatedReplicas()
+		s.Ready = d.GetStatus().GetReadyReplicas()
+		s.Generation = d.GetMetadata().GetGeneration()
+		s.ObservedGeneration = d.GetStatus().GetObservedGeneration()
+	case StatefulSet:
+		d := new(v1.StatefulSet)
+		if err := c.k.Get(ctx, t.Namespace, t.Name, d); err != nil {
+			return nil, errors.Wrapf(err, "failed to retrieve %s", t)
+		}
+		s.Desired = d.GetSpec().GetReplicas()
+		s.Current = d.GetStatus().GetReplicas()
+		s.Updated = d.GetStatus().GetUpdatedReplicas()
+		s.Ready = d.GetStatus().GetReadyReplicas()
+		s.Generation = d.GetMetadata().GetGeneration()
+		s.ObservedGeneration = d.GetStatus().GetObservedGeneration()
+	case DaemonSet:
+		d := new(v1.DaemonSet)
+		if err := c.k.Get(ctx, t.Namespace, t.Name, d); err != nil {
+			return nil, errors.Wrapf(err, "failed to retrieve %s", t)
+		}
+		s.Desired = d.GetStatus().GetDesiredNumberScheduled()
+		s.Current = d.GetStatus().GetCurrentNumberScheduled()
+		s.Updated = d.GetStatus().GetUpdatedNumberScheduled()
+		s.Ready = d.GetStatus().GetNumberReady()
+		s.Generation = d.GetMetadata().GetGeneration()
+		s.ObservedGeneration = d.GetStatus().GetObservedGeneration()
+	default:
+		return nil, errors.Errorf("unhandled workload kind %q", t.Kind)
+	}
+
+	if t.Kind != DaemonSet {
+		h, err := c.findAutoscaler(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		if h != nil {
+			s.Autoscaler = newAutoscaler(h)
+		}
 	}
 
-	d, err := c.ExtensionsV1Beta1().GetDeployment(ctx, app, namespace)
+	return s, nil
+}
+
+// Scale changes the number of instances of the workload.  DaemonSets are
+// sized by the nodes they are scheduled on, so scaling one returns
+// ErrNotScalable.  If the workload is managed by a HorizontalPodAutoscaler,
+// the bounds of the autoscaler are adjusted according to the AutoscalerMode
+// instead of the replica count.
+func (c *Client) Scale(ctx context.Context, t Target, n int32) error {
+	if n < 0 {
+		return errors.Errorf("invalid replica count %d", n)
+	}
+	if t.Kind == DaemonSet {
+		return ErrNotScalable
+	}
+
+	h, err := c.findAutoscaler(ctx, t)
 	if err != nil {
-		return errors.Wrap(err, "failed to get deployment")
+		return err
+	}
+	if h != nil {
+		return c.scaleAutoscaler(ctx, h, n)
 	}
 
-	d.Spec.Replicas = n
+	switch t.Kind {
+	case Deployment:
+		d := new(v1.Deployment)
+		if err := c.k.Get(ctx, t.Namespace, t.Name, d); err != nil {
+			return errors.Wrapf(err, "failed to retrieve %s", t)
+		}
+		if d.GetSpec().GetReplicas() == n {
+			return nil
+		}
+		d.GetSpec().Replicas = &n
+		return errors.Wrapf(c.k.Update(ctx, d), "failed to scale %s", t)
+	case StatefulSet:
+		d := new(v1.StatefulSet)
+		if err := c.k.Get(ctx, t.Namespace, t.Name, d); err != nil {
+			return errors.Wrapf(err, "failed to retrieve %s", t)
+		}
+		if d.GetSpec().GetReplicas() == n {
+			return nil
+		}
+		d.GetSpec().Replicas = &n
+		return errors.Wrapf(c.k.Update(ctx, d), "failed to scale %s", t)
+	default:
+		return errors.Errorf("unhandled workload kind %q", t.Kind)
+	}
+}
+
+// Wait blocks until the workload has converged on its desired state or the
+// context is cancelled.
+func (c *Client) Wait(ctx context.Context, t Target) (*Status, error) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
 
-	_, err = c.ExtensionsV1Beta1().UpdateDeployment(ctx, d)
-	return errors.Wrap(err, "failed to scale deployment")
+	for {
+		s, err := c.Get(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		if s.Converged() {
+			return s, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return s, errors.Wrapf(ctx.Err(), "timed out waiting for %s", t)
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,357 @@
+package deployment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	v1 "github.com/ericchiang/k8s/apis/apps/v1"
+	autoscaling "github.com/ericchiang/k8s/apis/autoscaling/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/golang/protobuf/proto"
+)
+
+var (
+	testDeployment  = Target{Kind: Deployment, Namespace: "voip", Name: "asterisk"}
+	testStatefulSet = Target{Kind: StatefulSet, Namespace: "voip", Name: "redis"}
+	testDaemonSet   = Target{Kind: DaemonSet, Namespace: "voip", Name: "kamailio"}
+)
+
+func workloads() []proto.Message {
+	return []proto.Message{
+		&v1.Deployment{
+			Metadata: withGeneration(meta("asterisk"), 3),
+			Spec:     &v1.DeploymentSpec{Replicas: k8s.Int32(3)},
+			Status: &v1.DeploymentStatus{
+				Replicas:           k8s.Int32(3),
+				UpdatedReplicas:    k8s.Int32(3),
+				ReadyReplicas:      k8s.Int32(2),
+				ObservedGeneration: int64p(3),
+			},
+		},
+		&v1.StatefulSet{
+			Metadata: withGeneration(meta("redis"), 1),
+			Spec:     &v1.StatefulSetSpec{Replicas: k8s.Int32(1)},
+			Status: &v1.StatefulSetStatus{
+				Replicas:           k8s.Int32(1),
+				UpdatedReplicas:    k8s.Int32(1),
+				ReadyReplicas:      k8s.Int32(1),
+				ObservedGeneration: int64p(1),
+			},
+		},
+		&v1.DaemonSet{
+			Metadata: withGeneration(meta("kamailio"), 2),
+			Status: &v1.DaemonSetStatus{
+				DesiredNumberScheduled: k8s.Int32(2),
+				CurrentNumberScheduled: k8s.Int32(2),
+				UpdatedNumberScheduled: k8s.Int32(1),
+				NumberReady:            k8s.Int32(2),
+				ObservedGeneration:     int64p(2),
+			},
+		},
+	}
+}
+
+func withGeneration(m *metav1.ObjectMeta, n int64) *metav1.ObjectMeta {
+	m.Generation = int64p(n)
+	return m
+}
+
+func TestGet(t *testing.T) {
+	c, f := newFakeClient(workloads()...)
+	defer f.Close()
+
+	tests := []struct {
+		target    Target
+		want      Status
+		converged bool
+	}{
+		{
+			target:    testDeployment,
+			want:      Status{Desired: 3, Current: 3, Updated: 3, Ready: 2, Generation: 3, ObservedGeneration: 3},
+			converged: false,
+		},
+		{
+			target:    testStatefulSet,
+			want:      Status{Desired: 1, Current: 1, Updated: 1, Ready: 1, Generation: 1, ObservedGeneration: 1},
+			converged: true,
+		},
+		{
+			target:    testDaemonSet,
+			want:      Status{Desired: 2, Current: 2, Updated: 1, Ready: 2, Generation: 2, ObservedGeneration: 2},
+			converged: false,
+		},
+	}
+	for _, tt := range tests {
+		s, err := c.Get(context.Background(), tt.target)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.target, err)
+			continue
+		}
+		tt.want.Target = tt.target
+		if *s != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.target, *s, tt.want)
+		}
+		if s.Converged() != tt.converged {
+			t.Errorf("%s: converged %v, want %v", tt.target, s.Converged(), tt.converged)
+		}
+	}
+
+	if _, err := c.Get(context.Background(), Target{Kind: "CronJob", Namespace: "voip", Name: "x"}); err == nil {
+		t.Error("expected error for unhandled kind")
+	}
+	if _, err := c.Get(context.Background(), Target{Kind: Deployment, Namespace: "voip", Name: "missing"}); err == nil {
+		t.Error("expected error for missing workload")
+	}
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  Target
+		n       int32
+		wantErr error
+		want    int32
+		updates int
+	}{
+		{name: "deployment", target: testDeployment, n: 5, want: 5, updates: 1},
+		{name: "statefulset", target: testStatefulSet, n: 2, want: 2, updates: 1},
+		{name: "unchanged", target: testDeployment, n: 3, want: 3, updates: 0},
+		{name: "to zero", target: testDeployment, n: 0, want: 0, updates: 1},
+		{name: "negative", target: testDeployment, n: -1, want: 3, updates: 0},
+		{name: "daemonset", target: testDaemonSet, n: 3, wantErr: ErrNotScalable},
+		{name: "unhandled kind", target: Target{Kind: "CronJob", Namespace: "voip", Name: "x"}, n: 1},
+	}
+	for _, tt := range tests {
+		c, f := newFakeClient(workloads()...)
+
+		err := c.Scale(context.Background(), tt.target, tt.n)
+		switch {
+		case tt.wantErr != nil:
+			if err != tt.wantErr {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+			}
+		case tt.n < 0 || tt.target.Kind == "CronJob":
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+		case err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+
+		if tt.wantErr == nil && tt.target.Kind != "CronJob" {
+			s, err := c.Get(context.Background(), tt.target)
+			if err != nil {
+				t.Fatalf("%s: failed to get status: %v", tt.name, err)
+			}
+			if s.Desired != tt.want {
+				t.Errorf("%s: desired %d, want %d", tt.name, s.Desired, tt.want)
+			}
+		}
+		if f.updates != tt.updates {
+			t.Errorf("%s: %d updates, want %d", tt.name, f.updates, tt.updates)
+		}
+		f.Close()
+	}
+}
+
+func testAutoscaler(min, max int32) *autoscaling.HorizontalPodAutoscaler {
+	return &autoscaling.HorizontalPodAutoscaler{
+		Metadata: meta("asterisk"),
+		Spec: &autoscaling.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: &autoscaling.CrossVersionObjectReference{
+				Kind: k8s.String(string(Deployment)),
+				Name: k8s.String("asterisk"),
+			},
+			MinReplicas: k8s.Int32(min),
+			MaxReplicas: k8s.Int32(max),
+		},
+	}
+}
+
+func TestScaleAutoscaled(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     AutoscalerMode
+		min, max int32
+		n        int32
+		wantErr  error
+		wantMin  int32
+		wantMax  int32
+	}{
+		{name: "pin", mode: PinAutoscaler, min: 1, max: 10, n: 4, wantMin: 4, wantMax: 4},
+		{name: "pin above max", mode: PinAutoscaler, min: 1, max: 3, n: 6, wantMin: 6, wantMax: 6},
+		{name: "floor below max", mode: FloorAutoscaler, min: 1, max: 10, n: 4, wantMin: 4, wantMax: 10},
+		{name: "floor above max", mode: FloorAutoscaler, min: 1, max: 3, n: 6, wantMin: 6, wantMax: 6},
+		{name: "to zero", mode: PinAutoscaler, min: 1, max: 10, n: 0, wantErr: ErrAutoscalerMinimum, wantMin: 1, wantMax: 10},
+	}
+	for _, tt := range tests {
+		c, f := newFakeClient(append(workloads(), testAutoscaler(tt.min, tt.max))...)
+		c.AutoscalerMode = tt.mode
+
+		if err := c.Scale(context.Background(), testDeployment, tt.n); err != tt.wantErr {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+		}
+
+		s, err := c.Get(context.Background(), testDeployment)
+		if err != nil {
+			t.Fatalf("%s: failed to get status: %v", tt.name, err)
+		}
+		if s.Autoscaler == nil {
+			t.Fatalf("%s: autoscaler not found", tt.name)
+		}
+		if s.Autoscaler.Min != tt.wantMin || s.Autoscaler.Max != tt.wantMax {
+			t.Errorf("%s: bounds %d-%d, want %d-%d", tt.name, s.Autoscaler.Min, s.Autoscaler.Max, tt.wantMin, tt.wantMax)
+		}
+		if s.Desired != 3 {
+			t.Errorf("%s: replica count written directly: %d", tt.name, s.Desired)
+		}
+		f.Close()
+	}
+}
+
+func TestRelease(t *testing.T) {
+	c, f := newFakeClient(append(workloads(), testAutoscaler(2, 8))...)
+	defer f.Close()
+	c.PinDuration = time.Hour
+
+	ctx := context.Background()
+	if err := c.Scale(ctx, testDeployment, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Scale(ctx, testDeployment, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := c.Get(ctx, testDeployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Autoscaler.Pinned() || !s.Autoscaler.Temporary() {
+		t.Errorf("autoscaler not temporarily pinned: %+v", s.Autoscaler)
+	}
+
+	if err = c.Release(ctx, testDeployment); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = c.Get(ctx, testDeployment); err != nil {
+		t.Fatal(err)
+	}
+	if s.Autoscaler.Min != 2 || s.Autoscaler.Max != 8 || s.Autoscaler.Temporary() {
+		t.Errorf("autoscaler not restored to its original bounds: %+v", s.Autoscaler)
+	}
+}
+
+func TestFloorAfterPin(t *testing.T) {
+	c, f := newFakeClient(append(workloads(), testAutoscaler(1, 10))...)
+	defer f.Close()
+	c.PinDuration = time.Hour
+
+	ctx := context.Background()
+	if err := c.Scale(ctx, testDeployment, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	c.AutoscalerMode = FloorAutoscaler
+	if err := c.Scale(ctx, testDeployment, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := c.Get(ctx, testDeployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Autoscaler.Min != 6 || s.Autoscaler.Max != 10 {
+		t.Errorf("floor took the pinned maximum: bounds %d-%d, want 6-10", s.Autoscaler.Min, s.Autoscaler.Max)
+	}
+
+	if err = c.Release(ctx, testDeployment); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = c.Get(ctx, testDeployment); err != nil {
+		t.Fatal(err)
+	}
+	if s.Autoscaler.Min != 1 || s.Autoscaler.Max != 10 {
+		t.Errorf("autoscaler not restored to its original bounds: %+v", s.Autoscaler)
+	}
+}
+
+func TestReleaseKeepsOperatorChanges(t *testing.T) {
+	c, f := newFakeClient(append(workloads(), testAutoscaler(2, 8))...)
+	defer f.Close()
+	c.PinDuration = time.Hour
+
+	ctx := context.Background()
+	if err := c.Scale(ctx, testDeployment, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// The operator changes the bounds while the pin holds
+	p := "/apis/autoscaling/v1/namespaces/voip/horizontalpodautoscalers/asterisk"
+	h := f.get(p).(*autoscaling.HorizontalPodAutoscaler)
+	h.Spec.MinReplicas = k8s.Int32(3)
+	h.Spec.MaxReplicas = k8s.Int32(12)
+
+	if err := c.Release(ctx, testDeployment); err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.Get(ctx, testDeployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Autoscaler.Min != 3 || s.Autoscaler.Max != 12 {
+		t.Errorf("operator's bounds overwritten: %d-%d, want 3-12", s.Autoscaler.Min, s.Autoscaler.Max)
+	}
+	if s.Autoscaler.Temporary() {
+		t.Error("pin not cleared")
+	}
+}
+
+func TestWait(t *testing.T) {
+	defer func(d time.Duration) { PollInterval = d }(PollInterval)
+	PollInterval = 10 * time.Millisecond
+
+	c, f := newFakeClient(workloads()...)
+	defer f.Close()
+
+	// A converged workload is returned at once
+	s, err := c.Wait(context.Background(), testStatefulSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Converged() {
+		t.Errorf("got unconverged status %+v", *s)
+	}
+
+	// An unconverged workload is waited on until its context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	s, err = c.Wait(ctx, testDeployment)
+	cancel()
+	if err == nil {
+		t.Error("expected error waiting for unconverged workload")
+	}
+	if s == nil || s.Ready != 2 {
+		t.Errorf("got %+v, want the last status", s)
+	}
+
+	// and returned once it converges
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		d := workloads()[0].(*v1.Deployment)
+		d.Status.ReadyReplicas = k8s.Int32(3)
+		f.set(d)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if s, err = c.Wait(ctx, testDeployment); err != nil {
+		t.Fatal(err)
+	}
+	if s.Ready != 3 || !s.Converged() {
+		t.Errorf("got %+v, want converged with 3 ready", *s)
+	}
+
+	if _, err = c.Wait(context.Background(), Target{Kind: Deployment, Namespace: "voip", Name: "missing"}); err == nil {
+		t.Error("expected error for missing workload")
+	}
+}
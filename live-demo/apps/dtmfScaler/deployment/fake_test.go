@@ -0,0 +1,192 @@
+package deployment
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ericchiang/k8s"
+	v1 "github.com/ericchiang/k8s/apis/apps/v1"
+	autoscaling "github.com/ericchiang/k8s/apis/autoscaling/v1"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/ericchiang/k8s/runtime"
+	"github.com/golang/protobuf/proto"
+)
+
+// magic prefixes kubernetes protobuf payloads
+var magic = []byte{0x6b, 0x38, 0x73, 0x00}
+
+// fakeAPI is an in-memory kubernetes API server which serves the objects
+// which the scaler reads and writes, by their API paths
+type fakeAPI struct {
+	mu      sync.Mutex
+	objects map[string]proto.Message
+
+	// updates counts the PUT requests served
+	updates int
+
+	srv *httptest.Server
+}
+
+// newFakeClient returns a Client backed by a fake API server holding the
+// given objects.  The server must be closed once the test is done.
+func newFakeClient(objects ...proto.Message) (*Client, *fakeAPI) {
+	f := &fakeAPI{objects: make(map[string]proto.Message)}
+	for _, o := range objects {
+		f.objects[objectPath(o)] = o
+	}
+
+	f.srv = httptest.NewServer(f)
+
+	return NewWithClient(&k8s.Client{
+		Endpoint: f.srv.URL,
+		Client:   f.srv.Client(),
+	}), f
+}
+
+// Close shuts down the server
+func (f *fakeAPI) Close() {
+	f.srv.Close()
+}
+
+// get returns the object at the given path, as last written
+func (f *fakeAPI) get(p string) proto.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[p]
+}
+
+// set replaces an object, as a controller updating its status would
+func (f *fakeAPI) set(o proto.Message) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[objectPath(o)] = o
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if o, ok := f.objects[r.URL.Path]; ok {
+			writeObject(w, o)
+			return
+		}
+		if l := f.list(r.URL.Path); l != nil {
+			writeObject(w, l)
+			return
+		}
+		http.NotFound(w, r)
+	case http.MethodPut:
+		o, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		updated := proto.Clone(o)
+		if err = decodeObject(data, updated); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = updated
+		f.updates++
+		writeObject(w, updated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// list returns the list of the objects under the given collection path, if
+// it is a collection which the fake serves
+func (f *fakeAPI) list(p string) proto.Message {
+	var items []proto.Message
+	for k, o := range f.objects {
+		if path.Dir(k) == p {
+			items = append(items, o)
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(p, "/horizontalpodautoscalers"):
+		l := new(autoscaling.HorizontalPodAutoscalerList)
+		for _, o := range items {
+			l.Items = append(l.Items, o.(*autoscaling.HorizontalPodAutoscaler))
+		}
+		return l
+	case strings.HasSuffix(p, "/pods"):
+		l := new(corev1.PodList)
+		for _, o := range items {
+			l.Items = append(l.Items, o.(*corev1.Pod))
+		}
+		return l
+	}
+	return nil
+}
+
+// objectPath returns the API path of a fake object
+func objectPath(o proto.Message) string {
+	var group, resource string
+	var meta *metav1.ObjectMeta
+	switch v := o.(type) {
+	case *v1.Deployment:
+		group, resource, meta = "apis/apps/v1", "deployments", v.GetMetadata()
+	case *v1.StatefulSet:
+		group, resource, meta = "apis/apps/v1", "statefulsets", v.GetMetadata()
+	case *v1.DaemonSet:
+		group, resource, meta = "apis/apps/v1", "daemonsets", v.GetMetadata()
+	case *autoscaling.HorizontalPodAutoscaler:
+		group, resource, meta = "apis/autoscaling/v1", "horizontalpodautoscalers", v.GetMetadata()
+	case *corev1.Pod:
+		group, resource, meta = "api/v1", "pods", v.GetMetadata()
+	case *corev1.Endpoints:
+		group, resource, meta = "api/v1", "endpoints", v.GetMetadata()
+	default:
+		panic("unhandled fake object type")
+	}
+	return "/" + path.Join(group, "namespaces", meta.GetNamespace(), resource, meta.GetName())
+}
+
+func writeObject(w http.ResponseWriter, o proto.Message) {
+	raw, err := proto.Marshal(o)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := proto.Marshal(&runtime.Unknown{Raw: raw})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.kubernetes.protobuf")
+	w.Write(append(append([]byte{}, magic...), data...)) // nolint: errcheck
+}
+
+func decodeObject(data []byte, o proto.Message) error {
+	u := new(runtime.Unknown)
+	if err := proto.Unmarshal(data[len(magic):], u); err != nil {
+		return err
+	}
+	o.Reset()
+	return proto.Unmarshal(u.Raw, o)
+}
+
+func meta(name string) *metav1.ObjectMeta {
+	return &metav1.ObjectMeta{
+		Name:      k8s.String(name),
+		Namespace: k8s.String("voip"),
+	}
+}
+
+func int64p(n int64) *int64 {
+	return &n
+}
@@ -0,0 +1,91 @@
+package deployment
+
+import (
+	"context"
+
+	"github.com/ericchiang/k8s"
+	v1 "github.com/ericchiang/k8s/apis/apps/v1"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	"github.com/pkg/errors"
+)
+
+// CrashLoopBackOff is the reason for which a container which keeps crashing
+// waits to be restarted
+const CrashLoopBackOff = "CrashLoopBackOff"
+
+// CrashLooping returns the names of the pods of the workload which have a
+// container in CrashLoopBackOff
+func (c *Client) CrashLooping(ctx context.Context, t Target) ([]string, error) {
+	labels, err := c.selector(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	l := new(k8s.LabelSelector)
+	for k, v := range labels {
+		l.Eq(k, v)
+	}
+
+	var pods corev1.PodList
+	if err := c.k.List(ctx, t.Namespace, &pods, l.Selector()); err != nil {
+		return nil, errors.Wrapf(err, "failed to list pods of %s", t)
+	}
+
+	var names []string
+	for _, p := range pods.GetItems() {
+		for _, s := range p.GetStatus().GetContainerStatuses() {
+			if s.GetState().GetWaiting().GetReason() == CrashLoopBackOff {
+				names = append(names, p.GetMetadata().GetName())
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// ReadyEndpoints returns the number of ready addresses of the named service
+func (c *Client) ReadyEndpoints(ctx context.Context, namespace, name string) (int, error) {
+	e := new(corev1.Endpoints)
+	if err := c.k.Get(ctx, namespace, name, e); err != nil {
+		return 0, errors.Wrapf(err, "failed to retrieve endpoints %s/%s", namespace, name)
+	}
+
+	var n int
+	for _, s := range e.GetSubsets() {
+		n += len(s.GetAddresses())
+	}
+	return n, nil
+}
+
+// selector returns the labels by which the workload selects its pods
+func (c *Client) selector(ctx context.Context, t Target) (map[string]string, error) {
+	var labels map[string]string
+
+	switch t.Kind {
+	case Deployment:
+		d := new(v1.Deployment)
+		if err := c.k.Get(ctx, t.Namespace, t.Name, d); err != nil {
+			return nil, errors.Wrapf(err, "failed to retrieve %s", t)
+		}
+		labels = d.GetSpec().GetSelector().GetMatchLabels()
+	case StatefulSet:
+		d := new(v1.StatefulSet)
+		if err := c.k.Get(ctx, t.Namespace, t.Name, d); err != nil {
+			return nil, errors.Wrapf(err, "failed to retrieve %s", t)
+		}
+		labels = d.GetSpec().GetSelector().GetMatchLabels()
+	case DaemonSet:
+		d := new(v1.DaemonSet)
+		if err := c.k.Get(ctx, t.Namespace, t.Name, d); err != nil {
+			return nil, errors.Wrapf(err, "failed to retrieve %s", t)
+		}
+		labels = d.GetSpec().GetSelector().GetMatchLabels()
+	default:
+		return nil, errors.Errorf("unhandled workload kind %q", t.Kind)
+	}
+
+	if len(labels) == 0 {
+		return nil, errors.Errorf("%s has no label selector", t)
+	}
+	return labels, nil
+}
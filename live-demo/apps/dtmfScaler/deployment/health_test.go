@@ -0,0 +1,103 @@
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ericchiang/k8s"
+	v1 "github.com/ericchiang/k8s/apis/apps/v1"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+func testPod(name, waiting string) *corev1.Pod {
+	p := &corev1.Pod{
+		Metadata: meta(name),
+		Status: &corev1.PodStatus{
+			ContainerStatuses: []*corev1.ContainerStatus{
+				{State: &corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			},
+		},
+	}
+	if waiting != "" {
+		p.Status.ContainerStatuses = append(p.Status.ContainerStatuses, &corev1.ContainerStatus{
+			State: &corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: k8s.String(waiting)},
+			},
+		})
+	}
+	return p
+}
+
+func TestCrashLooping(t *testing.T) {
+	c, f := newFakeClient(
+		&v1.Deployment{
+			Metadata: meta("asterisk"),
+			Spec: &v1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"component": "asterisk"}},
+			},
+		},
+		&v1.Deployment{Metadata: meta("unselective")},
+		testPod("asterisk-a", ""),
+		testPod("asterisk-b", CrashLoopBackOff),
+		testPod("asterisk-c", "ContainerCreating"),
+	)
+	defer f.Close()
+
+	names, err := c.CrashLooping(context.Background(), testDeployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "asterisk-b" {
+		t.Errorf("got %v, want [asterisk-b]", names)
+	}
+
+	if _, err = c.CrashLooping(context.Background(), Target{Kind: Deployment, Namespace: "voip", Name: "unselective"}); err == nil {
+		t.Error("expected error for workload without a selector")
+	}
+	if _, err = c.CrashLooping(context.Background(), Target{Kind: Deployment, Namespace: "voip", Name: "missing"}); err == nil {
+		t.Error("expected error for missing workload")
+	}
+}
+
+func TestReadyEndpoints(t *testing.T) {
+	addr := func(ip string) *corev1.EndpointAddress {
+		return &corev1.EndpointAddress{Ip: k8s.String(ip)}
+	}
+	c, f := newFakeClient(
+		&corev1.Endpoints{
+			Metadata: meta("asterisk"),
+			Subsets: []*corev1.EndpointSubset{
+				{
+					Addresses:         []*corev1.EndpointAddress{addr("10.0.0.1"), addr("10.0.0.2")},
+					NotReadyAddresses: []*corev1.EndpointAddress{addr("10.0.0.3")},
+				},
+				{Addresses: []*corev1.EndpointAddress{addr("10.0.1.1")}},
+			},
+		},
+		&corev1.Endpoints{Metadata: meta("kamailio")},
+	)
+	defer f.Close()
+
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"asterisk", 3},
+		{"kamailio", 0},
+	}
+	for _, tt := range tests {
+		n, err := c.ReadyEndpoints(context.Background(), "voip", tt.name)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if n != tt.want {
+			t.Errorf("%s: got %d ready endpoints, want %d", tt.name, n, tt.want)
+		}
+	}
+
+	if _, err := c.ReadyEndpoints(context.Background(), "voip", "missing"); err == nil {
+		t.Error("expected error for missing service")
+	}
+}
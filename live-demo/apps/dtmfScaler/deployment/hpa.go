@@ -0,0 +1,262 @@
+package deployment
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	autoscaling "github.com/ericchiang/k8s/apis/autoscaling/v1"
+	"github.com/pkg/errors"
+)
+
+// AutoscalerMode describes how Scale treats a workload which is managed by a
+// HorizontalPodAutoscaler.  Writing the replica count of such a workload
+// directly is pointless, since the autoscaler will undo it within seconds.
+type AutoscalerMode int
+
+const (
+	// PinAutoscaler sets both the minimum and maximum replicas of the
+	// autoscaler to the requested size.
+	PinAutoscaler AutoscalerMode = iota
+
+	// FloorAutoscaler sets the minimum replicas of the autoscaler to the
+	// requested size, raising the maximum if necessary.  The autoscaler
+	// remains free to add instances above the requested size.
+	FloorAutoscaler
+)
+
+// ReleaseInterval is the interval at which RunReleaser checks for expired
+// autoscaler pins
+var ReleaseInterval = time.Minute
+
+// ErrAutoscalerMinimum indicates that an autoscaled workload was asked to
+// scale below the minimum size an autoscaler may be given.
+var ErrAutoscalerMinimum = errors.New("autoscaled workloads cannot be scaled below one instance")
+
+const (
+	annotationOriginalMin = "asterisk-k8s-demo.cycoresystems.com/original-min-replicas"
+	annotationOriginalMax = "asterisk-k8s-demo.cycoresystems.com/original-max-replicas"
+	annotationPinnedUntil = "asterisk-k8s-demo.cycoresystems.com/pinned-until"
+	annotationPinnedMin   = "asterisk-k8s-demo.cycoresystems.com/pinned-min-replicas"
+	annotationPinnedMax   = "asterisk-k8s-demo.cycoresystems.com/pinned-max-replicas"
+)
+
+// Autoscaler describes a HorizontalPodAutoscaler which manages a workload
+type Autoscaler struct {
+	Name string
+
+	// Min and Max are the bounds within which the autoscaler may size the
+	// workload
+	Min int32
+	Max int32
+
+	// Current and Desired are the autoscaler's view of the workload size
+	Current int32
+	Desired int32
+
+	// PinnedUntil is the time at which a temporary change to the bounds
+	// will be reverted.  It is zero if the bounds are not temporary.
+	PinnedUntil time.Time
+}
+
+// Pinned indicates whether the autoscaler is held at a single size
+func (a *Autoscaler) Pinned() bool {
+	return a.Min == a.Max
+}
+
+// Temporary indicates whether the current bounds will be reverted
+func (a *Autoscaler) Temporary() bool {
+	return !a.PinnedUntil.IsZero()
+}
+
+func newAutoscaler(h *autoscaling.HorizontalPodAutoscaler) *Autoscaler {
+	a := &Autoscaler{
+		Name:    h.GetMetadata().GetName(),
+		Min:     1,
+		Max:     h.GetSpec().GetMaxReplicas(),
+		Current: h.GetStatus().GetCurrentReplicas(),
+		Desired: h.GetStatus().GetDesiredReplicas(),
+	}
+	if h.GetSpec().MinReplicas != nil {
+		a.Min = h.GetSpec().GetMinReplicas()
+	}
+	if until, ok := h.GetMetadata().GetAnnotations()[annotationPinnedUntil]; ok {
+		a.PinnedUntil, _ = time.Parse(time.RFC3339, until) // nolint: errcheck
+	}
+	return a
+}
+
+// findAutoscaler returns the HorizontalPodAutoscaler which targets the
+// workload, if there is one.
+func (c *Client) findAutoscaler(ctx context.Context, t Target) (*autoscaling.HorizontalPodAutoscaler, error) {
+	list := new(autoscaling.HorizontalPodAutoscalerList)
+	if err := c.k.List(ctx, t.Namespace, list); err != nil {
+		return nil, errors.Wrapf(err, "failed to list autoscalers for %s", t)
+	}
+
+	for _, h := range list.GetItems() {
+		ref := h.GetSpec().GetScaleTargetRef()
+		if ref.GetKind() == string(t.Kind) && ref.GetName() == t.Name {
+			return h, nil
+		}
+	}
+	return nil, nil
+}
+
+// scaleAutoscaler adjusts the bounds of the autoscaler so that the workload
+// is held at (or, for FloorAutoscaler, above) the given size.
+func (c *Client) scaleAutoscaler(ctx context.Context, h *autoscaling.HorizontalPodAutoscaler, n int32) error {
+	if n < 1 {
+		return ErrAutoscalerMinimum
+	}
+
+	a := newAutoscaler(h)
+
+	meta := h.GetMetadata()
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+
+	// A floor leaves the autoscaler free up to the operator's maximum, not
+	// that of an earlier pin
+	ceiling := a.Max
+	if orig, err := strconv.Atoi(meta.Annotations[annotationOriginalMax]); err == nil && c.stillPinned(h) {
+		ceiling = int32(orig)
+	}
+
+	min, max := n, n
+	if c.AutoscalerMode == FloorAutoscaler && ceiling > n {
+		max = ceiling
+	}
+
+	if c.PinDuration > 0 {
+		// Keep the bounds from before the first pin so that a series of
+		// temporary changes reverts to the operator's configuration.
+		if _, ok := meta.Annotations[annotationOriginalMax]; !ok || !c.stillPinned(h) {
+			meta.Annotations[annotationOriginalMin] = strconv.Itoa(int(a.Min))
+			meta.Annotations[annotationOriginalMax] = strconv.Itoa(int(a.Max))
+		}
+		meta.Annotations[annotationPinnedUntil] = time.Now().Add(c.PinDuration).UTC().Format(time.RFC3339)
+		meta.Annotations[annotationPinnedMin] = strconv.Itoa(int(min))
+		meta.Annotations[annotationPinnedMax] = strconv.Itoa(int(max))
+	} else {
+		clearPin(meta.Annotations)
+	}
+
+	h.GetSpec().MinReplicas = &min
+	h.GetSpec().MaxReplicas = &max
+
+	return errors.Wrapf(c.k.Update(ctx, h), "failed to update autoscaler %s", a.Name)
+}
+
+// Release reverts any temporary change made by Scale to the autoscaler of
+// the workload.
+func (c *Client) Release(ctx context.Context, t Target) error {
+	h, err := c.findAutoscaler(ctx, t)
+	if err != nil || h == nil {
+		return err
+	}
+	return c.release(ctx, h)
+}
+
+func (c *Client) release(ctx context.Context, h *autoscaling.HorizontalPodAutoscaler) error {
+	meta := h.GetMetadata()
+	if _, ok := meta.GetAnnotations()[annotationPinnedUntil]; !ok {
+		return nil
+	}
+
+	// An operator who has changed the bounds since the pin has taken the
+	// autoscaler back, so their bounds are kept
+	if !c.stillPinned(h) {
+		log.Printf("autoscaler %s was changed while pinned; keeping its bounds", meta.GetName())
+		clearPin(meta.Annotations)
+		return errors.Wrapf(c.k.Update(ctx, h), "failed to release autoscaler %s", meta.GetName())
+	}
+
+	min, err := strconv.Atoi(meta.Annotations[annotationOriginalMin])
+	if err != nil {
+		return errors.Wrapf(err, "invalid original minimum for autoscaler %s", meta.GetName())
+	}
+	max, err := strconv.Atoi(meta.Annotations[annotationOriginalMax])
+	if err != nil {
+		return errors.Wrapf(err, "invalid original maximum for autoscaler %s", meta.GetName())
+	}
+
+	min32, max32 := int32(min), int32(max)
+	h.GetSpec().MinReplicas = &min32
+	h.GetSpec().MaxReplicas = &max32
+
+	clearPin(meta.Annotations)
+
+	return errors.Wrapf(c.k.Update(ctx, h), "failed to release autoscaler %s", meta.GetName())
+}
+
+// stillPinned indicates whether the autoscaler holds the bounds set by the
+// last pin.  Pins made before the pinned bounds were recorded are assumed to
+// be held.
+func (c *Client) stillPinned(h *autoscaling.HorizontalPodAutoscaler) bool {
+	annotations := h.GetMetadata().GetAnnotations()
+	if _, ok := annotations[annotationPinnedUntil]; !ok {
+		return false
+	}
+
+	min, minErr := strconv.Atoi(annotations[annotationPinnedMin])
+	max, maxErr := strconv.Atoi(annotations[annotationPinnedMax])
+	if minErr != nil || maxErr != nil {
+		return true
+	}
+
+	a := newAutoscaler(h)
+	return a.Min == int32(min) && a.Max == int32(max)
+}
+
+// clearPin removes the annotations of a pin
+func clearPin(annotations map[string]string) {
+	delete(annotations, annotationOriginalMin)
+	delete(annotations, annotationOriginalMax)
+	delete(annotations, annotationPinnedUntil)
+	delete(annotations, annotationPinnedMin)
+	delete(annotations, annotationPinnedMax)
+}
+
+// ReleaseExpired reverts every temporary autoscaler change in the namespace
+// whose time has passed.
+func (c *Client) ReleaseExpired(ctx context.Context, namespace string) error {
+	list := new(autoscaling.HorizontalPodAutoscalerList)
+	if err := c.k.List(ctx, namespace, list); err != nil {
+		return errors.Wrapf(err, "failed to list autoscalers in namespace %s", namespace)
+	}
+
+	for _, h := range list.GetItems() {
+		a := newAutoscaler(h)
+		if !a.Temporary() || time.Now().Before(a.PinnedUntil) {
+			continue
+		}
+		if err := c.release(ctx, h); err != nil {
+			return err
+		}
+		log.Printf("released autoscaler %s to %d-%d replicas", a.Name, h.GetSpec().GetMinReplicas(), h.GetSpec().GetMaxReplicas())
+	}
+	return nil
+}
+
+// RunReleaser periodically reverts expired autoscaler changes in the
+// namespace until the context is cancelled.  Since the pin state is stored
+// on the autoscaler itself, any instance of any scaler app may release it.
+func (c *Client) RunReleaser(ctx context.Context, namespace string) {
+	ticker := time.NewTicker(ReleaseInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.ReleaseExpired(ctx, namespace); err != nil {
+			log.Println("failed to release expired autoscaler pins:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -3,18 +3,57 @@ package main
 import (
 	"context"
 	"log"
+	"os"
 	"time"
 
 	"github.com/CyCoreSystems/ari"
 	"github.com/CyCoreSystems/ari-proxy/client"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/apps/dtmfScaler/deployment"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/leg"
+	nats "github.com/nats-io/nats.go"
 )
 
 const ariApp = "demo"
 
+// AutoscalerPinDuration is the amount of time for which a scaling request
+// holds an autoscaled workload at the requested size.
+const AutoscalerPinDuration = 30 * time.Minute
+
+var scaler *deployment.Client
+
 func main() {
+	var err error
+
+	if v := os.Getenv("SCALE_TARGETS"); v != "" {
+		if scaleTargets, err = parseScaleTargets(v); err != nil {
+			log.Println("failed to parse SCALE_TARGETS:", err)
+			return
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	scaler, err = deployment.New()
+	if err != nil {
+		log.Println("failed to build kubernetes client", "error", err)
+		return
+	}
+	scaler.PinDuration = AutoscalerPinDuration
+	go scaler.RunReleaser(ctx, asteriskTarget.Namespace)
+
+	// NATS is only needed to place alerts, so it is connected to by the
+	// watcher
+	uri := os.Getenv("NATS_URI")
+	if uri == "" {
+		uri = nats.DefaultURL
+	}
+	if w := newWatcherFromEnv(scaler, uri); w != nil {
+		go w.Run(ctx)
+	} else {
+		log.Println("no on-call list; not alerting on degraded workloads")
+	}
+
 	// connect
 	log.Println("connecting to ARI")
 	cl, err := client.New(ctx, client.WithApplication(ariApp))
@@ -23,8 +62,11 @@ func main() {
 		return
 	}
 
+	d := leg.NewDispatcher()
+	d.Handle(leg.Caller, appStart)
+
 	log.Println("starting listener")
-	err = client.Listen(ctx, cl, appStart)
+	err = client.Listen(ctx, cl, d.Dispatch)
 	if err != nil {
 		log.Println("failed to listen for new calls")
 	}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/apps/dtmfScaler/deployment"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	nats "github.com/nats-io/nats.go"
+)
+
+// HealthCheckInterval is the interval at which the watched workloads are
+// checked
+var HealthCheckInterval = 30 * time.Second
+
+// DefaultDegradedDuration is the time for which a workload may have fewer
+// ready instances than desired before the on-call list is alerted.  It may
+// be overridden in minutes by the ONCALL_DEGRADED_MINUTES environment
+// variable.
+var DefaultDegradedDuration = 5 * time.Minute
+
+// DefaultRealertInterval is the time after which the on-call list is called
+// again when nobody has acknowledged a problem which has not cleared.  It
+// may be overridden in minutes by the ONCALL_REALERT_MINUTES environment
+// variable.
+var DefaultRealertInterval = 15 * time.Minute
+
+// AlertCallTimeout is the maximum time for which a call to alert a person on
+// call is waited for, from dialing to hangup
+var AlertCallTimeout = 5 * time.Minute
+
+// watchedTarget is a workload whose health is watched for the on-call list
+type watchedTarget struct {
+	deployment.Target
+
+	// Service is the service whose endpoints are the ready pods of the
+	// workload
+	Service string
+
+	// degradedSince is the time since which the workload has had fewer
+	// ready instances than desired, or zero if it has not
+	degradedSince time.Time
+
+	// resolve ends the escalation of the current problem of the workload.
+	// It is nil while the workload is healthy.
+	resolve context.CancelFunc
+}
+
+var watchedTargets = []*watchedTarget{
+	{
+		Target:  asteriskTarget,
+		Service: "asterisk",
+	},
+	{
+		Target:  proxyTarget,
+		Service: "kamailio",
+	},
+}
+
+// originator places outbound calls, as control.Client does
+type originator interface {
+	Originate(ctx context.Context, req *control.OriginateRequest) (*control.OutboundCall, error)
+}
+
+// watcher phones the on-call list, through the voice ARI app, when a watched
+// workload is degraded.  Each person is read the problem and may acknowledge
+// it, which ends the alert, or pass it on to the next person.
+type watcher struct {
+	scaler *deployment.Client
+
+	// natsURI is the NATS server through which alerts are placed, once
+	// connected to as calls
+	natsURI string
+	calls   originator
+
+	// oncall are the endpoints (such as PJSIP/1000) or numbers to call, in
+	// order of escalation
+	oncall []string
+
+	// callerID is presented to those called
+	callerID string
+
+	// degradedFor is the time for which a workload may have fewer ready
+	// instances than desired before it is alerted
+	degradedFor time.Duration
+
+	// realertAfter is the time after which the list is called again when
+	// nobody has acknowledged a problem
+	realertAfter time.Duration
+}
+
+// newWatcherFromEnv returns a watcher configured by the ONCALL_LIST (a
+// comma-separated list of endpoints or numbers), ONCALL_CALLER_ID,
+// ONCALL_DEGRADED_MINUTES and ONCALL_REALERT_MINUTES environment variables,
+// which places its alerts through the NATS server at the given URI, or nil
+// if there is no on-call list
+func newWatcherFromEnv(scaler *deployment.Client, natsURI string) *watcher {
+	var oncall []string
+	for _, s := range strings.Split(os.Getenv("ONCALL_LIST"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			oncall = append(oncall, s)
+		}
+	}
+	if len(oncall) == 0 {
+		return nil
+	}
+
+	w := &watcher{
+		scaler:       scaler,
+		natsURI:      natsURI,
+		oncall:       oncall,
+		callerID:     os.Getenv("ONCALL_CALLER_ID"),
+		degradedFor:  DefaultDegradedDuration,
+		realertAfter: DefaultRealertInterval,
+	}
+	if n, err := strconv.Atoi(os.Getenv("ONCALL_DEGRADED_MINUTES")); err == nil && n > 0 {
+		w.degradedFor = time.Duration(n) * time.Minute
+	}
+	if n, err := strconv.Atoi(os.Getenv("ONCALL_REALERT_MINUTES")); err == nil && n > 0 {
+		w.realertAfter = time.Duration(n) * time.Minute
+	}
+	return w
+}
+
+// Run connects to NATS and then checks the watched workloads at each
+// HealthCheckInterval until the context is cancelled
+func (w *watcher) Run(ctx context.Context) {
+	nc, err := w.connect(ctx)
+	if err != nil {
+		return
+	}
+	defer nc.Close()
+	w.calls = control.NewClient(nc)
+
+	t := time.NewTicker(HealthCheckInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			for _, wt := range watchedTargets {
+				w.sweep(ctx, wt)
+			}
+		}
+	}
+}
+
+// connect connects to NATS, trying again at each HealthCheckInterval until
+// it succeeds or the context is cancelled
+func (w *watcher) connect(ctx context.Context) (*nats.Conn, error) {
+	t := time.NewTicker(HealthCheckInterval)
+	defer t.Stop()
+
+	for {
+		nc, err := nats.Connect(w.natsURI)
+		if err == nil {
+			return nc, nil
+		}
+		log.Println("failed to connect to NATS; not alerting until it is reachable:", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// sweep checks a workload, starting an alert when it becomes degraded and
+// ending it once it recovers
+func (w *watcher) sweep(ctx context.Context, wt *watchedTarget) {
+	problem, err := w.check(ctx, wt)
+	if err != nil {
+		log.Printf("failed to check %s: %v", wt.Target, err)
+		return
+	}
+
+	if problem == "" {
+		if wt.resolve != nil {
+			log.Printf("%s has recovered", wt.Target)
+			wt.resolve()
+			wt.resolve = nil
+		}
+		return
+	}
+	if wt.resolve != nil {
+		return
+	}
+
+	var resolved context.Context
+	resolved, wt.resolve = context.WithCancel(ctx)
+	go w.alert(ctx, resolved, problem)
+}
+
+// check returns a description of the problem of a workload, to be read to
+// those on call, or an empty string if it is healthy
+func (w *watcher) check(ctx context.Context, wt *watchedTarget) (string, error) {
+	s, err := w.scaler.Get(ctx, wt.Target)
+	if err != nil {
+		return "", err
+	}
+	if s.Ready >= s.Desired {
+		wt.degradedSince = time.Time{}
+	} else if wt.degradedSince.IsZero() {
+		wt.degradedSince = time.Now()
+	}
+
+	crashing, err := w.scaler.CrashLooping(ctx, wt.Target)
+	if err != nil {
+		return "", err
+	}
+	if len(crashing) > 0 {
+		return fmt.Sprintf("%d %s pods are in crash loop back off.", len(crashing), wt.Name), nil
+	}
+
+	if s.Desired > 0 {
+		n, err := w.scaler.ReadyEndpoints(ctx, wt.Namespace, wt.Service)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			return fmt.Sprintf("The %s service has no ready endpoints.", wt.Service), nil
+		}
+	}
+
+	if !wt.degradedSince.IsZero() && time.Since(wt.degradedSince) >= w.degradedFor {
+		return fmt.Sprintf("Only %d of %d %s instances have been ready for %d minutes.", s.Ready, s.Desired, wt.Name, int(time.Since(wt.degradedSince)/time.Minute)), nil
+	}
+	return "", nil
+}
+
+// alert calls each person on the on-call list in turn until one acknowledges
+// the problem, or it is resolved.  If nobody acknowledges it, the list is
+// called again after the realert interval.
+func (w *watcher) alert(ctx, resolved context.Context, problem string) {
+	log.Println("alerting on-call list:", problem)
+
+	for !w.escalate(ctx, resolved, problem) {
+		log.Printf("alert was not acknowledged by anyone on call; alerting again in %s: %s", w.realertAfter, problem)
+
+		t := time.NewTimer(w.realertAfter)
+		select {
+		case <-resolved.Done():
+			t.Stop()
+			log.Println("problem resolved; no longer alerting:", problem)
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// escalate calls each person on the on-call list in turn, returning true
+// once one acknowledges the problem or it is resolved
+func (w *watcher) escalate(ctx, resolved context.Context, problem string) bool {
+	for _, who := range w.oncall {
+		if resolved.Err() != nil {
+			log.Println("problem resolved; no longer alerting:", problem)
+			return true
+		}
+
+		answer, err := w.call(ctx, who, problem)
+		if err != nil {
+			log.Printf("failed to alert %s: %v", who, err)
+			continue
+		}
+		if answer == control.AlertAcknowledge {
+			log.Printf("alert acknowledged by %s", who)
+			return true
+		}
+		log.Printf("alert passed on by %s", who)
+	}
+	return false
+}
+
+// call reads the problem to a person on call, returning their answer once
+// the call has ended
+func (w *watcher) call(ctx context.Context, who, problem string) (string, error) {
+	req := &control.OriginateRequest{
+		CallerID: w.callerID,
+		Target:   control.TargetVoice,
+		Message:  problem,
+		Alert:    true,
+		Wait:     true,
+	}
+	if strings.Contains(who, "/") {
+		req.Endpoint = who
+	} else {
+		req.Number = who
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, AlertCallTimeout)
+	defer cancel()
+
+	call, err := w.calls.Originate(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return alertAnswer(call.Digits), nil
+}
+
+// alertAnswer returns the first answer to an alert among the keys pressed by
+// its callee, or an empty string if they gave none
+func alertAnswer(digits string) string {
+	for _, d := range digits {
+		switch string(d) {
+		case control.AlertAcknowledge, control.AlertEscalate:
+			return string(d)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	"github.com/pkg/errors"
+)
+
+// fakeOriginator answers alert calls with scripted keys, in order
+type fakeOriginator struct {
+	mu      sync.Mutex
+	answers []string
+	called  []string
+}
+
+func (f *fakeOriginator) Originate(ctx context.Context, req *control.OriginateRequest) (*control.OutboundCall, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.called = append(f.called, req.Endpoint+req.Number)
+	if len(f.answers) == 0 {
+		return nil, errors.New("no answer scripted")
+	}
+	digits := f.answers[0]
+	f.answers = f.answers[1:]
+	return &control.OutboundCall{Digits: digits}, nil
+}
+
+func (f *fakeOriginator) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.called...)
+}
+
+func TestAlertRealerts(t *testing.T) {
+	f := &fakeOriginator{
+		// Nobody acknowledges the first time round
+		answers: []string{"2", "", "2", "1"},
+	}
+	w := &watcher{
+		calls:        f,
+		oncall:       []string{"PJSIP/1000", "4045551234"},
+		realertAfter: time.Millisecond,
+	}
+
+	ctx := context.Background()
+	w.alert(ctx, ctx, "test problem")
+
+	want := []string{"PJSIP/1000", "4045551234", "PJSIP/1000", "4045551234"}
+	got := f.calls()
+	if len(got) != len(want) {
+		t.Fatalf("called %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("call %d to %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestAlertStopsWhenResolved(t *testing.T) {
+	f := new(fakeOriginator)
+	w := &watcher{
+		calls:        f,
+		oncall:       []string{"PJSIP/1000"},
+		realertAfter: time.Hour,
+	}
+
+	ctx := context.Background()
+	resolved, resolve := context.WithCancel(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.alert(ctx, resolved, "test problem")
+	}()
+
+	// The alert fails, so waits to be made again
+	for deadline := time.Now().Add(time.Second); len(f.calls()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no alert was made")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	resolve()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("alert continued after the problem was resolved")
+	}
+	if n := len(f.calls()); n != 1 {
+		t.Errorf("made %d calls, want 1", n)
+	}
+}
+
+func TestAlertAnswer(t *testing.T) {
+	tests := map[string]string{
+		"":    "",
+		"1":   control.AlertAcknowledge,
+		"2":   control.AlertEscalate,
+		"#21": control.AlertEscalate,
+		"93":  "",
+	}
+	for digits, want := range tests {
+		if got := alertAnswer(digits); got != want {
+			t.Errorf("alertAnswer(%q) = %q, want %q", digits, got, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/apps/dtmfScaler/deployment"
+	"github.com/pkg/errors"
+)
+
+var asteriskTarget = deployment.Target{
+	Kind:      deployment.Deployment,
+	Namespace: "voip",
+	Name:      "asterisk",
+}
+
+var proxyTarget = deployment.Target{
+	Kind:      deployment.DaemonSet,
+	Namespace: "voip",
+	Name:      "kamailio",
+}
+
+// ErrOutOfPolicy indicates that a requested size is outside the range
+// permitted for the target
+var ErrOutOfPolicy = errors.New("requested size is outside of policy")
+
+// scaleTarget describes a workload which may be selected from the IVR
+type scaleTarget struct {
+	deployment.Target
+
+	// Key is the DTMF digit which selects the target
+	Key string
+
+	// Min and Max are the bounds within which a caller may scale the target
+	Min int32
+	Max int32
+}
+
+// Validate checks that the requested size is permitted for the target
+func (t *scaleTarget) Validate(n int32) error {
+	if t.Kind == deployment.DaemonSet {
+		return deployment.ErrNotScalable
+	}
+	if n < t.Min || n > t.Max {
+		return ErrOutOfPolicy
+	}
+	return nil
+}
+
+// scaleTargets are the workloads offered by the IVR: asterisk on 1, and the
+// proxies (kamailio) on 2 and the media relay (rtpproxy) on 3.  The proxies
+// and the media relay run together in the kamailio DaemonSet, which cannot
+// be scaled, so callers are told so.  They may be replaced by the
+// SCALE_TARGETS environment variable; see parseScaleTargets.
+var scaleTargets = []*scaleTarget{
+	{
+		Target: asteriskTarget,
+		Key:    "1",
+		Min:    1,
+		Max:    10,
+	},
+	{
+		Target: proxyTarget,
+		Key:    "2",
+	},
+	{
+		Target: proxyTarget,
+		Key:    "3",
+	},
+}
+
+// parseScaleTargets parses a comma-separated list of targets, each of the
+// form <key>=<kind>/<namespace>/<name>:<min>-<max>, such as
+// "1=Deployment/voip/asterisk:1-10".  DaemonSets, which cannot be scaled,
+// take no bounds, such as "2=DaemonSet/voip/kamailio".
+func parseScaleTargets(s string) ([]*scaleTarget, error) {
+	var list []*scaleTarget
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		t, err := parseScaleTarget(item)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid scale target %q", item)
+		}
+		if targetIn(list, t.Key) != nil {
+			return nil, errors.Errorf("duplicate scale target key %q", t.Key)
+		}
+		list = append(list, t)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("no scale targets")
+	}
+	return list, nil
+}
+
+func parseScaleTarget(s string) (*scaleTarget, error) {
+	eq := strings.Index(s, "=")
+	if eq < 0 {
+		return nil, errors.New("expected <key>=<kind>/<namespace>/<name>:<min>-<max>")
+	}
+
+	t := &scaleTarget{Key: s[:eq]}
+	if len(t.Key) != 1 || t.Key[0] < '0' || t.Key[0] > '9' {
+		return nil, errors.New("key must be a single digit")
+	}
+
+	workload, bounds := s[eq+1:], ""
+	if colon := strings.LastIndex(workload, ":"); colon >= 0 {
+		workload, bounds = workload[:colon], workload[colon+1:]
+	}
+
+	ref := strings.Split(workload, "/")
+	if len(ref) != 3 || ref[1] == "" || ref[2] == "" {
+		return nil, errors.New("workload must be <kind>/<namespace>/<name>")
+	}
+	t.Kind, t.Namespace, t.Name = deployment.Kind(ref[0]), ref[1], ref[2]
+	switch t.Kind {
+	case deployment.Deployment, deployment.StatefulSet:
+	case deployment.DaemonSet:
+		if bounds != "" {
+			return nil, errors.New("DaemonSets cannot be scaled, so take no bounds")
+		}
+		return t, nil
+	default:
+		return nil, errors.Errorf("unhandled workload kind %q", t.Kind)
+	}
+
+	limits := strings.Split(bounds, "-")
+	if len(limits) != 2 {
+		return nil, errors.New("bounds must be <min>-<max>")
+	}
+	min, err := strconv.Atoi(limits[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid minimum")
+	}
+	max, err := strconv.Atoi(limits[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid maximum")
+	}
+	if min < 0 || max < min {
+		return nil, errors.New("bounds must satisfy 0 <= min <= max")
+	}
+	t.Min, t.Max = int32(min), int32(max)
+
+	return t, nil
+}
+
+func targetByKey(key string) *scaleTarget {
+	return targetIn(scaleTargets, key)
+}
+
+func targetIn(list []*scaleTarget, key string) *scaleTarget {
+	for _, t := range list {
+		if t.Key == key {
+			return t
+		}
+	}
+	return nil
+}
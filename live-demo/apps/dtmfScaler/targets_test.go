@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/apps/dtmfScaler/deployment"
+)
+
+func TestParseScaleTargets(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []scaleTarget
+		err  bool
+	}{
+		{
+			in: "1=Deployment/voip/asterisk:1-10",
+			want: []scaleTarget{
+				{Target: asteriskTarget, Key: "1", Min: 1, Max: 10},
+			},
+		},
+		{
+			in: " 1=Deployment/voip/asterisk:1-10, 2=StatefulSet/voip/redis:0-3 ,",
+			want: []scaleTarget{
+				{Target: asteriskTarget, Key: "1", Min: 1, Max: 10},
+				{Target: deployment.Target{Kind: deployment.StatefulSet, Namespace: "voip", Name: "redis"}, Key: "2", Min: 0, Max: 3},
+			},
+		},
+		{
+			in: "1=Deployment/voip/asterisk:1-10,2=DaemonSet/voip/kamailio",
+			want: []scaleTarget{
+				{Target: asteriskTarget, Key: "1", Min: 1, Max: 10},
+				{Target: proxyTarget, Key: "2"},
+			},
+		},
+		{in: "", err: true},
+		{in: "1=DaemonSet/voip/kamailio:1-2", err: true},
+		{in: "1=Deployment/voip/asterisk:", err: true},
+		{in: "1=CronJob/voip/x:1-2", err: true},
+		{in: "12=Deployment/voip/asterisk:1-10", err: true},
+		{in: "a=Deployment/voip/asterisk:1-10", err: true},
+		{in: "1=Deployment/asterisk:1-10", err: true},
+		{in: "1=Deployment/voip/asterisk", err: true},
+		{in: "1=Deployment/voip/asterisk:10-1", err: true},
+		{in: "1=Deployment/voip/asterisk:-1-3", err: true},
+		{in: "1=Deployment/voip/asterisk:1-10,1=Deployment/voip/other:1-2", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseScaleTargets(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expected error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %d targets, want %d", tt.in, len(got), len(tt.want))
+			continue
+		}
+		for i := range got {
+			if *got[i] != tt.want[i] {
+				t.Errorf("%q: target %d is %+v, want %+v", tt.in, i, *got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	st := &scaleTarget{Target: asteriskTarget, Key: "1", Min: 1, Max: 10}
+	tests := []struct {
+		n    int32
+		want error
+	}{
+		{n: 1},
+		{n: 10},
+		{n: 0, want: ErrOutOfPolicy},
+		{n: 11, want: ErrOutOfPolicy},
+		{n: -1, want: ErrOutOfPolicy},
+	}
+	for _, tt := range tests {
+		if err := st.Validate(tt.n); err != tt.want {
+			t.Errorf("%d: got %v, want %v", tt.n, err, tt.want)
+		}
+	}
+}
+
+func TestDefaultScaleTargets(t *testing.T) {
+	tests := []struct {
+		key      string
+		scalable bool
+	}{
+		{"1", true},
+		{"2", false},
+		{"3", false},
+	}
+	for _, tt := range tests {
+		st := targetByKey(tt.key)
+		if st == nil {
+			t.Errorf("no target on key %s", tt.key)
+			continue
+		}
+		if got := st.Validate(st.Max) != deployment.ErrNotScalable; got != tt.scalable {
+			t.Errorf("%s: scalable %v, want %v", tt.key, got, tt.scalable)
+		}
+	}
+	if targetByKey("4") != nil {
+		t.Error("unexpected target on key 4")
+	}
+}
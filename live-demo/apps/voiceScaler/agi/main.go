@@ -1,17 +1,34 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strconv"
 
 	"github.com/CyCoreSystems/agi"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/metadata"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/routing"
 	"github.com/gofrs/uuid"
 )
 
 const listenAddr = ":8080"
 const audiosocketAddr = "audiosocket:8080"
 
+// languageVariable is the channel variable which may be set by the dialplan
+// to choose the language of the voice service for the call
+const languageVariable = "VOICE_LANGUAGE"
+
+var callMetadata metadata.Store
+var routes *routing.Source
+
 func main() {
+	routes = routing.FromEnv()
+
+	store := metadata.NewRedisStoreFromEnv()
+	defer store.Close() // nolint: errcheck
+	callMetadata = store
+
 	log.Fatalln(agi.Listen(listenAddr, callHandler))
 }
 
@@ -19,18 +36,94 @@ func callHandler(a *agi.AGI) {
 	log.Println("new call from", a.Variables["agi_callerid"])
 
 	id := uuid.Must(uuid.NewV1())
+	addr := audiosocketAddr
 
+	// Calls routed to the dialplan continue there when the AGI ends
+	var continued bool
 	defer func() {
-		a.Hangup() // nolint
-		a.Close()  // nolint
+		if !continued {
+			a.Hangup() // nolint
+		}
+		a.Close() // nolint
 	}()
 
+	if r := routes.Route(dnisFromAGI(a), a.Variables["agi_callerid"]); r != nil {
+		log.Printf("routing call by route %q to %s", r.Name, r.Target.Kind())
+		switch r.Target.Kind() {
+		case routing.KindAudioSocket:
+			addr = r.Target.AudioSocket
+		case routing.KindIVR:
+			if _, err := a.Exec("Stasis", r.Target.IVR); err != nil {
+				log.Printf("failed to send call to IVR %s: %v", r.Target.IVR, err)
+			}
+			return
+		default:
+			if err := continueInDialplan(a, r.Target); err != nil {
+				log.Println("failed to send call to dialplan:", err)
+				return
+			}
+			continued = true
+			return
+		}
+	}
+
 	if err := a.Answer(); err != nil {
 		log.Println("failed to answer call:", err)
 		return
 	}
 
-	if _, err := a.Exec("AudioSocket", fmt.Sprintf("%s,%s", id.String(), audiosocketAddr)); err != nil {
-		log.Printf("failed to execute AudioSocket to %s: %v", audiosocketAddr, err)
+	// Tell the voice service about the call before it connects
+	if err := callMetadata.Put(context.Background(), callMetadataFromAGI(a, id.String())); err != nil {
+		log.Println("failed to publish call metadata:", err)
+	}
+	defer callMetadata.Delete(context.Background(), id.String()) // nolint: errcheck
+
+	if _, err := a.Exec("AudioSocket", fmt.Sprintf("%s,%s", id.String(), addr)); err != nil {
+		log.Printf("failed to execute AudioSocket to %s: %v", addr, err)
+	}
+}
+
+// continueInDialplan sets the dialplan location at which the call continues
+// when the AGI ends
+func continueInDialplan(a *agi.AGI, t *routing.Target) error {
+	if t.Context != "" {
+		if err := a.Command("SET", "CONTEXT", t.Context).Err(); err != nil {
+			return err
+		}
+	}
+	if err := a.Command("SET", "EXTENSION", t.Extension).Err(); err != nil {
+		return err
+	}
+	return a.Command("SET", "PRIORITY", strconv.Itoa(t.Priority)).Err()
+}
+
+// dnisFromAGI returns the number dialed by the caller
+func dnisFromAGI(a *agi.AGI) string {
+	dnis := a.Variables["agi_dnid"]
+	if dnis == "" || dnis == "unknown" {
+		dnis = a.Variables["agi_extension"]
+	}
+	return dnis
+}
+
+// callMetadataFromAGI returns the metadata of the call from its AGI
+// variables
+func callMetadataFromAGI(a *agi.AGI, id string) *metadata.Metadata {
+	m := &metadata.Metadata{
+		ID:         id,
+		CallerID:   a.Variables["agi_callerid"],
+		CallerName: a.Variables["agi_calleridname"],
+		DNIS:       dnisFromAGI(a),
+		Channel:    a.Variables["agi_channel"],
+		Vars: map[string]string{
+			"uniqueid": a.Variables["agi_uniqueid"],
+		},
+	}
+	if node, err := a.Get("SYSTEMNAME"); err == nil {
+		m.Node = node
+	}
+	if l, err := a.Get(languageVariable); err == nil {
+		m.Language = l
 	}
+	return m
 }
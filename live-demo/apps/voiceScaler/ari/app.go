@@ -9,15 +9,57 @@ import (
 
 	"github.com/CyCoreSystems/ari"
 	"github.com/CyCoreSystems/ari/ext/bridgemon"
+	"github.com/CyCoreSystems/ari/ext/play"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/leg"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/metadata"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/routing"
 	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 )
 
-const audiosocketEndpoint = "AudioSocket/%s:8080/%s"
+const audiosocketEndpoint = "AudioSocket/%s/%s"
+
+// ivrVariable is the channel variable through which the ARI application of
+// an IVR target is passed to the dialplan
+const ivrVariable = "ROUTE_IVR"
+
+// ivrContext and ivrExtension are the dialplan location which sends a call
+// to the ARI application named by ivrVariable
+const (
+	ivrContext   = "inbound"
+	ivrExtension = "ivr"
+)
+
+// languageVariable is the channel variable which may be set by the dialplan
+// to choose the language of the voice service for the call
+const languageVariable = "VOICE_LANGUAGE"
+
+// messageVariable is the channel variable which may be set to a message for
+// the voice service to speak before anything else, as for outbound calls
+const messageVariable = "VOICE_MESSAGE"
+
+// modeVariable is the channel variable which may be set to the mode in which
+// the voice service handles the call, such as metadata.ModeAlert
+const modeVariable = "VOICE_MODE"
 
 // LocalChannelAnswerTimeout is the maximum time to wait for a local channel to be answered
 var LocalChannelAnswerTimeout = time.Second
 
+// MediaStartTimeout is the maximum time to wait for the media leg of a call
+// to enter the app.  Unlike a local channel, an ExternalMedia channel must
+// set up its RTP session before it does.
+var MediaStartTimeout = 5 * time.Second
+
+// AudioSocketAttempts is the number of times the AudioSocket leg of a call
+// is tried before the call fails.  It may be overridden by the
+// AUDIOSOCKET_ATTEMPTS environment variable.
+var AudioSocketAttempts = 3
+
+// AudioSocketFailureSound is played to the caller when their AudioSocket leg
+// cannot be connected
+var AudioSocketFailureSound = "sound:an-error-has-occurred"
+
 // State is the structure for storing application execution data
 type State struct {
 	h *ari.ChannelHandle
@@ -36,26 +78,60 @@ func init() {
 func app(ctx context.Context, ac ari.Client, h *ari.ChannelHandle) error {
 	log.Println("running voice app")
 
+	// Send the call where the routing table says.  Calls without an
+	// AudioSocket address of their own go to the audiosocket service.
+	var addr string
+	r, data := routeCall(h)
+	if r != nil {
+		log.Printf("routing call by route %q to %s", r.Name, r.Target.Kind())
+		if r.Target.Kind() != routing.KindAudioSocket {
+			return sendToTarget(h, data, r.Target)
+		}
+		addr = r.Target.AudioSocket
+	}
+
+	h.Answer()
+	time.Sleep(time.Second)
+
+	return runVoice(ctx, ac, h, data, r, addr)
+}
+
+// runVoice connects an answered call to a voice service, at the given
+// AudioSocket address or else the audiosocket service, and carries out the
+// commands of the service until the call ends.  The route of the call, if
+// any, gives the fallback should the voice service be unreachable.
+func runVoice(ctx context.Context, ac ari.Client, h *ari.ChannelHandle, data *ari.ChannelData, r *routing.Route, addr string) error {
 	// Always quit on hangup
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	go func() {
 		sub := h.Subscribe(ari.Events.ChannelDestroyed)
 		defer sub.Cancel()
 		select {
 		case <-sub.Events():
+			log.Println("caller hung up")
+			cancel()
 		case <-ctx.Done():
 		}
 	}()
 
-	h.Answer()
-	time.Sleep(time.Second)
-
 	id := uuid.Must(uuid.NewV1())
 
+	// Tell the voice service about the call before it connects
+	if err := publishMetadata(ctx, h, id.String()); err != nil {
+		log.Println("failed to publish call metadata:", err)
+	}
+	defer callMetadata.Delete(context.Background(), id.String()) // nolint: errcheck
+
+	// Hold the call against the reaper while it runs
+	defer owners.Claim(ctx, id.String())()
+
 	// Bridge to voice app (via AudioSocket)
 	br, err := ac.Bridge().Create(h.Key(), "mixing", "bridge-"+id.String())
 	if err != nil {
 		return errors.Wrap(err, "failed to stage bridge creation")
 	}
+	defer br.Delete() // nolint: errcheck
 	m := bridgemon.New(br)
 	defer m.Close()
 
@@ -66,46 +142,36 @@ func app(ctx context.Context, ac ari.Client, h *ari.ChannelHandle) error {
 	}
 	defer br.RemoveChannel(h.ID()) // nolint
 
-	// Create an AudioSocket channel
-	as, err := ac.Channel().StageOriginate(h.Key(), ari.OriginateRequest{
-		Endpoint:   fmt.Sprintf(audiosocketEndpoint, os.Getenv("AUDIOSOCKET_SERVICE_HOST"), id.String()),
-		ChannelID:  id.String(),
-		App:        ac.ApplicationName(),
-		AppArgs:    "noop",
-		Originator: h.ID(),
-		Variables: map[string]string{
-			"AUDIOSOCKET_ID": id.String(),
-		},
-	})
+	// Take commands from the voice service until the app ends
+	requests := make(chan *controlRequest)
+	unsubscribe, err := subscribeControl(ctx, id.String(), requests)
 	if err != nil {
-		return errors.Wrap(err, "failed to stage AudioSocket channel creation")
+		return err
 	}
+	defer unsubscribe()
 
-	asStart := as.Subscribe(ari.Events.StasisStart)
-	go func() {
-		defer asStart.Cancel()
+	// Relay the caller's keys to the voice service
+	go relayDTMF(ctx, h, id.String())
 
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(LocalChannelAnswerTimeout):
-			return
-		case <-asStart.Events():
-			break
-		}
+	as, err := connectAudioSocket(ctx, ac, h, br, id.String(), addr, callerLegs)
+	if err != nil {
+		return audioSocketFailed(ctx, h, data, r, err)
+	}
+	defer as.Hangup() // nolint: errcheck
 
-		// Send AudioSocket channel to the bridge
-		if err := br.AddChannel(as.ID()); err != nil {
-			log.Println("failed to send AudioSocket channel to bridge:", err)
-			return
+	c := &call{
+		id:    id.String(),
+		ac:    ac,
+		h:     h,
+		br:    br,
+		media: as,
+	}
+	defer func() {
+		if c.transferee != nil {
+			c.transferee.Hangup() // nolint: errcheck
 		}
 	}()
 
-	if err := as.Exec(); err != nil {
-		return errors.Wrap(err, "failed to create AudioSocket channel")
-	}
-	defer as.Hangup() // nolint: errcheck
-
 	// Wait for the bridge to be left
 	log.Println("waiting for bridge quorum")
 	var hadQuorum bool
@@ -114,6 +180,16 @@ func app(ctx context.Context, ac ari.Client, h *ari.ChannelHandle) error {
 		case <-ctx.Done():
 			log.Println("context terminated")
 			return nil
+		case req := <-requests:
+			done, err := c.control(ctx, req.cmd)
+			req.result <- err
+			if done {
+				return err
+			}
+			if req.cmd.Kind == control.Transfer && err == nil {
+				// The bridge must fill again with the transferee
+				hadQuorum = false
+			}
 		case data := <-brEvents:
 			if len(data.ChannelIDs) > 1 {
 				log.Println("bridge quorum achieved")
@@ -127,3 +203,207 @@ func app(ctx context.Context, ac ari.Client, h *ari.ChannelHandle) error {
 		}
 	}
 }
+
+// connectAudioSocket creates the AudioSocket leg of the call and adds it to
+// the bridge.  If no address is given, the leg goes to the least loaded pod
+// of the audiosocket service, and is tried on another pod if it does not
+// start.  The leg and its attempts are counted in the given metrics.
+func connectAudioSocket(ctx context.Context, ac ari.Client, h *ari.ChannelHandle, br *ari.BridgeHandle, id, addr string, m *legMetrics) (*ari.ChannelHandle, error) {
+	tried := make(map[string]bool)
+
+	var err error
+	for i := 0; i < AudioSocketAttempts; i++ {
+		target := addr
+		if target == "" {
+			target = pickAudioSocket(tried)
+		}
+		tried[target] = true
+
+		// Channel IDs may not be reused, even once hung up
+		chID := id
+		if i > 0 {
+			chID = fmt.Sprintf("%s-%d", id, i)
+		}
+
+		var as *ari.ChannelHandle
+		if as, err = startAudioSocket(ctx, ac, h, chID, id, target); err == nil {
+			if err = br.AddChannel(as.ID()); err == nil {
+				if i > 0 {
+					m.outcomes.Add(outcomeRetried, 1)
+				} else {
+					m.outcomes.Add(outcomeConnected, 1)
+				}
+				return as, nil
+			}
+			err = errors.Wrap(err, "failed to send media channel to bridge")
+			as.Hangup() // nolint: errcheck
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		m.attemptFailures.Add(1)
+		log.Printf("AudioSocket leg to %s failed: %v", target, err)
+	}
+	return nil, err
+}
+
+// audioSocketFailed handles a call whose AudioSocket leg could not be
+// connected.  Unless the caller has gone, they are told of the failure and
+// sent to the fallback of their route, if there is one.
+func audioSocketFailed(ctx context.Context, h *ari.ChannelHandle, data *ari.ChannelData, r *routing.Route, err error) error {
+	if ctx.Err() != nil {
+		callerLegs.outcomes.Add(outcomeAbandoned, 1)
+		return nil
+	}
+	log.Println("failed to connect AudioSocket leg:", err)
+
+	if err := play.Play(ctx, h, play.URI(AudioSocketFailureSound)).Err(); err != nil {
+		log.Println("failed to play failure sound:", err)
+	}
+
+	t := routes.Fallback(r)
+	if t == nil {
+		callerLegs.outcomes.Add(outcomeFailed, 1)
+		return errors.Wrap(err, "failed to connect AudioSocket leg")
+	}
+
+	log.Printf("sending call to fallback %s", t.Kind())
+	callerLegs.outcomes.Add(outcomeFallback, 1)
+	return sendToTarget(h, data, t)
+}
+
+// sendToTarget sends the call to an IVR or dialplan target
+func sendToTarget(h *ari.ChannelHandle, data *ari.ChannelData, t *routing.Target) error {
+	if t.Kind() == routing.KindIVR {
+		if err := h.SetVariable(ivrVariable, t.IVR); err != nil {
+			return errors.Wrap(err, "failed to set IVR of call")
+		}
+		return continueInDialplan(h, ivrContext, ivrExtension, 1)
+	}
+
+	dpContext := t.Context
+	if dpContext == "" && data.GetDialplan() != nil {
+		dpContext = data.GetDialplan().GetContext()
+	}
+	return continueInDialplan(h, dpContext, t.Extension, t.Priority)
+}
+
+// pickAudioSocket returns the address of the audiosocket service pod to
+// which a call should be sent, excluding those already tried.  Without a
+// balancer, or once every pod has been tried, it returns the address of the
+// service.
+func pickAudioSocket(tried map[string]bool) string {
+	if lb != nil {
+		if addr, ok := lb.Pick(tried); ok {
+			return addr
+		}
+	}
+	return os.Getenv("AUDIOSOCKET_SERVICE_HOST") + ":8080"
+}
+
+// startAudioSocket originates an AudioSocket channel, or an ExternalMedia
+// channel when media is carried by RTP, to the given address, and waits for
+// it to enter the app
+func startAudioSocket(ctx context.Context, ac ari.Client, h *ari.ChannelHandle, chID, id, addr string) (*ari.ChannelHandle, error) {
+	as, err := ac.Channel().StageOriginate(h.Key(), ari.OriginateRequest{
+		Endpoint:   mediaEndpoint(addr, id),
+		ChannelID:  chID,
+		App:        ac.ApplicationName(),
+		AppArgs:    string(leg.Media),
+		Originator: h.ID(),
+		Variables: map[string]string{
+			"AUDIOSOCKET_ID": id,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stage media channel creation")
+	}
+
+	sub := as.Subscribe(ari.Events.StasisStart, ari.Events.ChannelDestroyed)
+	defer sub.Cancel()
+
+	if err := as.Exec(); err != nil {
+		return nil, errors.Wrap(err, "failed to create media channel")
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(MediaStartTimeout):
+		err = errors.New("timed out waiting for media channel to start")
+	case e := <-sub.Events():
+		if _, ok := e.(*ari.StasisStart); !ok {
+			err = errors.New("media channel hung up")
+			break
+		}
+		if err = registerMedia(ctx, as, id); err == nil {
+			return as, nil
+		}
+	}
+	as.Hangup() // nolint: errcheck
+	if err == nil {
+		err = ctx.Err()
+	}
+	return nil, err
+}
+
+// routeCall returns the route of the call from the routing table, if any,
+// along with the channel data from which it was chosen
+func routeCall(h *ari.ChannelHandle) (*routing.Route, *ari.ChannelData) {
+	data, err := h.Data()
+	if err != nil {
+		log.Println("failed to get channel data for routing:", err)
+		return nil, nil
+	}
+
+	var dnis, callerID string
+	if d := data.GetDialplan(); d != nil {
+		dnis = d.GetExten()
+	}
+	if c := data.GetCaller(); c != nil {
+		callerID = c.GetNumber()
+	}
+	return routes.Route(dnis, callerID), data
+}
+
+// continueInDialplan sends the call back to the dialplan at the given
+// location
+func continueInDialplan(h *ari.ChannelHandle, context, extension string, priority int) error {
+	if err := h.Continue(context, extension, priority); err != nil {
+		return errors.Wrapf(err, "failed to continue call at %s,%s,%d", context, extension, priority)
+	}
+	return ErrTransferred
+}
+
+// publishMetadata publishes the metadata of the call for the voice service,
+// keyed by the AudioSocket ID of the call
+func publishMetadata(ctx context.Context, h *ari.ChannelHandle, id string) error {
+	data, err := h.Data()
+	if err != nil {
+		return errors.Wrap(err, "failed to get channel data")
+	}
+
+	m := &metadata.Metadata{
+		ID:         id,
+		Channel:    h.ID(),
+		Node:       h.Key().Node,
+		SampleRate: mediaSampleRate(),
+	}
+	if c := data.GetCaller(); c != nil {
+		m.CallerID = c.GetNumber()
+		m.CallerName = c.GetName()
+	}
+	if d := data.GetDialplan(); d != nil {
+		m.DNIS = d.GetExten()
+	}
+	if l, err := h.GetVariable(languageVariable); err == nil {
+		m.Language = l
+	}
+	if msg, err := h.GetVariable(messageVariable); err == nil {
+		m.Message = msg
+	}
+	if mode, err := h.GetVariable(modeVariable); err == nil {
+		m.Mode = mode
+	}
+
+	return callMetadata.Put(ctx, m)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/load"
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// LoadReportTTL is the age after which the load report of a pod is ignored
+var LoadReportTTL = 3 * load.ReportInterval
+
+// WatchRetryInterval is the time to wait before watching the Endpoints again
+// after the watch fails
+var WatchRetryInterval = 5 * time.Second
+
+// balancer picks the pod of the audiosocket service to which each call is
+// sent, by the least active calls.  It watches the Endpoints of the service
+// for its ready pods and listens for the load reports of the pods.
+type balancer struct {
+	k         *k8s.Client
+	namespace string
+	name      string
+
+	mu    sync.Mutex
+	port  int32
+	ready []string
+	pods  map[string]*podLoad
+}
+
+// podLoad is the load of a pod
+type podLoad struct {
+	// calls is the number of active calls last reported by the pod
+	calls int64
+
+	// reported is the time of the last report
+	reported time.Time
+
+	// assigned is the number of calls sent to the pod since its last report
+	assigned int64
+}
+
+// current returns the estimated number of active calls of the pod
+func (p *podLoad) current() int64 {
+	if time.Since(p.reported) > LoadReportTTL {
+		return p.assigned
+	}
+	return p.calls + p.assigned
+}
+
+// newBalancer returns a balancer for the named Endpoints, using the
+// in-cluster kubernetes configuration
+func newBalancer(namespace, name string) (*balancer, error) {
+	k, err := k8s.NewInClusterClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get kubernetes client")
+	}
+	return &balancer{
+		k:         k,
+		namespace: namespace,
+		name:      name,
+		pods:      make(map[string]*podLoad),
+	}, nil
+}
+
+// Run watches the Endpoints and load reports until the context is cancelled
+func (b *balancer) Run(ctx context.Context, nc *nats.Conn) error {
+	sub, err := load.Subscribe(nc, b.report)
+	if err != nil {
+		return errors.Wrap(err, "failed to subscribe to load reports")
+	}
+	defer sub.Unsubscribe() // nolint: errcheck
+
+	for ctx.Err() == nil {
+		if err := b.watch(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to watch endpoints %s/%s: %v", b.namespace, b.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(WatchRetryInterval):
+		}
+	}
+	return nil
+}
+
+func (b *balancer) watch(ctx context.Context) error {
+	w, err := b.k.Watch(ctx, b.namespace, new(corev1.Endpoints), k8s.QueryParam("fieldSelector", "metadata.name="+b.name))
+	if err != nil {
+		return err
+	}
+	defer w.Close() // nolint: errcheck
+
+	for {
+		e := new(corev1.Endpoints)
+		eventType, err := w.Next(e)
+		if err != nil {
+			return err
+		}
+		if eventType == k8s.EventDeleted {
+			e = new(corev1.Endpoints)
+		}
+		b.update(e)
+	}
+}
+
+// update replaces the ready pods with those of the Endpoints
+func (b *balancer) update(e *corev1.Endpoints) {
+	var (
+		port  int32
+		ready []string
+	)
+	for _, s := range e.GetSubsets() {
+		for _, p := range s.GetPorts() {
+			if port == 0 || p.GetName() == "audiosocket" {
+				port = p.GetPort()
+			}
+		}
+		for _, a := range s.GetAddresses() {
+			ready = append(ready, a.GetIp())
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.port = port
+	b.ready = ready
+	for ip := range b.pods {
+		if !contains(ready, ip) {
+			delete(b.pods, ip)
+		}
+	}
+	for _, ip := range ready {
+		if b.pods[ip] == nil {
+			b.pods[ip] = new(podLoad)
+		}
+	}
+	log.Printf("%d ready pods of %s/%s", len(ready), b.namespace, b.name)
+}
+
+func (b *balancer) report(r *load.Report) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Pods of other services report on the same subject
+	p, ok := b.pods[r.Address]
+	if !ok {
+		return
+	}
+	p.calls = r.Calls
+	p.reported = time.Now()
+	p.assigned = 0
+}
+
+// Pick returns the address of the ready pod with the least active calls,
+// excluding the given addresses.  It returns false if there is no such pod.
+func (b *balancer) Pick(exclude map[string]bool) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var (
+		best string
+		min  int64
+	)
+	for _, ip := range b.ready {
+		addr := net.JoinHostPort(ip, fmt.Sprint(b.port))
+		if exclude[addr] {
+			continue
+		}
+		if n := b.pods[ip].current(); best == "" || n < min {
+			best, min = ip, n
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+	b.pods[best].assigned++
+	return net.JoinHostPort(best, fmt.Sprint(b.port)), true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/CyCoreSystems/ari"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/leg"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+// ErrTransferred indicates that the caller has been sent back to the
+// dialplan, so must not be hung up when the app ends
+var ErrTransferred = errors.New("transferred to dialplan")
+
+// errCallEnded indicates that a command arrived after the call had ended
+var errCallEnded = errors.New("call ended")
+
+// call holds the resources of a call which commands from the voice service
+// may act upon
+type call struct {
+	// id is the AudioSocket ID of the call
+	id string
+
+	ac ari.Client
+	h  *ari.ChannelHandle
+	br *ari.BridgeHandle
+
+	// media is the AudioSocket leg to the voice service, or nil once it
+	// has been removed
+	media *ari.ChannelHandle
+
+	// transferee is the channel to which the caller has been transferred,
+	// if any
+	transferee *ari.ChannelHandle
+
+	recording *ari.LiveRecordingHandle
+}
+
+// controlRequest is a command received from the voice service, which is
+// passed to the app's event loop to be carried out
+type controlRequest struct {
+	cmd    *control.Command
+	result chan error
+}
+
+// subscribeControl subscribes to the commands for the call with the given
+// AudioSocket ID, passing each to the requests channel.  The subscription
+// must be cancelled when the call ends.
+func subscribeControl(ctx context.Context, id string, requests chan<- *controlRequest) (func(), error) {
+	sub, err := control.Subscribe(natsConn, id, func(cmd *control.Command) error {
+		req := &controlRequest{
+			cmd:    cmd,
+			result: make(chan error, 1),
+		}
+		select {
+		case requests <- req:
+		case <-ctx.Done():
+			return errCallEnded
+		}
+		select {
+		case err := <-req.result:
+			return err
+		case <-ctx.Done():
+			return errCallEnded
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to subscribe to control channel")
+	}
+	return func() {
+		sub.Unsubscribe() // nolint: errcheck
+	}, nil
+}
+
+// control carries out a command from the voice service.  It returns done if
+// the app should end, along with any error which should end it.
+func (c *call) control(ctx context.Context, cmd *control.Command) (done bool, err error) {
+	log.Printf("received %s command for channel %s", cmd.Kind, c.h.ID())
+
+	switch cmd.Kind {
+	case control.Transfer:
+		if cmd.Endpoint != "" {
+			return false, c.transferToEndpoint(ctx, cmd.Endpoint)
+		}
+		if cmd.Extension == "" {
+			return false, errors.New("transfer requires an extension or an endpoint")
+		}
+		c.removeMedia()
+		if err := c.h.Continue(cmd.Context, cmd.Extension, 1); err != nil {
+			return true, errors.Wrap(err, "failed to continue in dialplan")
+		}
+		return true, ErrTransferred
+	case control.Play:
+		if cmd.Sound == "" {
+			return false, errors.New("play requires a sound")
+		}
+		_, err := c.h.Play(uuid.Must(uuid.NewV1()).String(), "sound:"+cmd.Sound)
+		return false, errors.Wrap(err, "failed to play sound")
+	case control.DTMF:
+		if cmd.Digits == "" {
+			return false, errors.New("dtmf requires digits")
+		}
+		return false, errors.Wrap(c.h.SendDTMF(cmd.Digits, nil), "failed to send DTMF")
+	case control.StartRecording:
+		if c.recording != nil {
+			return false, errors.New("already recording")
+		}
+		name := cmd.Recording
+		if name == "" {
+			name = c.br.ID()
+		}
+		rec, err := c.br.Record(name, &ari.RecordingOptions{
+			Format: "wav",
+			Exists: "overwrite",
+		})
+		if err != nil {
+			return false, errors.Wrap(err, "failed to start recording")
+		}
+		c.recording = rec
+		return false, nil
+	case control.StopRecording:
+		if c.recording == nil {
+			return false, errors.New("not recording")
+		}
+		err := c.recording.Stop()
+		c.recording = nil
+		return false, errors.Wrap(err, "failed to stop recording")
+	case control.Hangup:
+		return true, nil
+	}
+	return false, errors.Errorf("unknown command %q", cmd.Kind)
+}
+
+// removeMedia removes the AudioSocket leg from the call and hangs it up
+func (c *call) removeMedia() {
+	if c.media == nil {
+		return
+	}
+	if err := c.br.RemoveChannel(c.media.ID()); err != nil {
+		log.Println("failed to remove media channel from bridge:", err)
+	}
+	if err := c.media.Hangup(); err != nil {
+		log.Println("failed to hang up media channel:", err)
+	}
+	c.media = nil
+}
+
+// transferToEndpoint replaces the AudioSocket leg of the call with a call to
+// the endpoint.  The AudioSocket leg is only removed once the endpoint
+// answers, so the caller stays with the voice service if it does not.
+func (c *call) transferToEndpoint(ctx context.Context, endpoint string) error {
+	if c.transferee != nil {
+		return errors.New("already transferred")
+	}
+
+	ch, err := c.ac.Channel().StageOriginate(c.h.Key(), ari.OriginateRequest{
+		Endpoint:   endpoint,
+		ChannelID:  c.id + "-transfer",
+		App:        c.ac.ApplicationName(),
+		AppArgs:    string(leg.Transfer),
+		Originator: c.h.ID(),
+		Timeout:    int(control.TransferTimeout.Seconds()),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to stage transfer channel creation")
+	}
+
+	start := ch.Subscribe(ari.Events.StasisStart)
+	defer start.Cancel()
+	destroyed := ch.Subscribe(ari.Events.ChannelDestroyed)
+	defer destroyed.Cancel()
+	callerEnd := c.h.Subscribe(ari.Events.StasisEnd)
+	defer callerEnd.Cancel()
+
+	if err = ch.Exec(); err != nil {
+		return errors.Wrap(err, "failed to create transfer channel")
+	}
+
+	timer := time.NewTimer(control.TransferTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-start.Events():
+	case <-destroyed.Events():
+		return errors.Errorf("transfer to %s failed", endpoint)
+	case <-timer.C:
+		ch.Hangup() // nolint: errcheck
+		return errors.Errorf("transfer to %s was not answered", endpoint)
+	case <-callerEnd.Events():
+		ch.Hangup() // nolint: errcheck
+		return errCallEnded
+	case <-ctx.Done():
+		ch.Hangup() // nolint: errcheck
+		return errCallEnded
+	}
+
+	if err = c.br.AddChannel(ch.ID()); err != nil {
+		ch.Hangup() // nolint: errcheck
+		return errors.Wrap(err, "failed to send transfer channel to bridge")
+	}
+	c.removeMedia()
+	c.transferee = ch
+	return nil
+}
+
+// relayDTMF publishes the keys pressed by the caller to the voice service,
+// since the AudioSocket does not carry them
+func relayDTMF(ctx context.Context, h *ari.ChannelHandle, id string) {
+	sub := h.Subscribe(ari.Events.ChannelDtmfReceived)
+	defer sub.Cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-sub.Events():
+			v, ok := e.(*ari.ChannelDtmfReceived)
+			if !ok {
+				continue
+			}
+			if err := control.PublishEvent(natsConn, id, &control.Event{
+				Kind:  control.EventDTMF,
+				Digit: v.Digit,
+			}); err != nil {
+				log.Println("failed to relay DTMF:", err)
+			}
+		}
+	}
+}
@@ -3,15 +3,36 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/CyCoreSystems/ari"
 	"github.com/CyCoreSystems/ari-proxy/client"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/leg"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/metadata"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/routing"
+	nats "github.com/nats-io/nats.go"
 )
 
 const ariApp = "test"
 
+// MaxCallDuration is the maximum length of a call handled by the app.  The
+// voice service ends its calls sooner, except during continuous
+// transcription, so this only bounds calls which it has lost track of.
+const MaxCallDuration = 4 * time.Hour
+
 var baseClient *client.Client
+var callMetadata metadata.Store
+var natsConn *nats.Conn
+var owners *ownerStore
+var routes *routing.Source
+
+// lb balances calls across the pods of the audiosocket service.  It is nil
+// when running outside kubernetes.
+var lb *balancer
 
 func main() {
 	var err error
@@ -19,6 +40,63 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	store := metadata.NewRedisStoreFromEnv()
+	defer store.Close() // nolint: errcheck
+	callMetadata = store
+
+	owners = newOwnerStore(metadata.RedisAddr())
+	defer owners.Close() // nolint: errcheck
+
+	routes = routing.FromEnv()
+
+	calls := newOutbound(metadata.RedisAddr())
+	defer calls.Close() // nolint: errcheck
+	http.Handle("/outbound/", &outboundHandler{o: calls})
+
+	if err = configureMedia(metadata.RedisAddr()); err != nil {
+		log.Println("failed to configure media transport:", err)
+		return
+	}
+	if mediaRegistry != nil {
+		defer mediaRegistry.Close() // nolint: errcheck
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("AUDIOSOCKET_ATTEMPTS")); err == nil && n > 0 {
+		AudioSocketAttempts = n
+	}
+
+	go func() {
+		log.Println("metrics server failed:", http.ListenAndServe(metricsAddr, nil))
+	}()
+
+	uri := os.Getenv("NATS_URI")
+	if uri == "" {
+		uri = nats.DefaultURL
+	}
+	if natsConn, err = nats.Connect(uri); err != nil {
+		log.Println("failed to connect to NATS:", err)
+		return
+	}
+	defer natsConn.Close()
+
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = "voip"
+	}
+	endpoints := os.Getenv("AUDIOSOCKET_ENDPOINTS")
+	if endpoints == "" {
+		endpoints = "audiosocket"
+	}
+	if lb, err = newBalancer(namespace, endpoints); err != nil {
+		log.Println("not balancing AudioSocket calls:", err)
+	} else {
+		go func() {
+			if err := lb.Run(ctx, natsConn); err != nil {
+				log.Println("AudioSocket balancer failed:", err)
+			}
+		}()
+	}
+
 	// connect
 	log.Println("connecting to ARI")
 	baseClient, err = client.New(ctx, client.WithApplication(ariApp))
@@ -27,8 +105,34 @@ func main() {
 		return
 	}
 
+	go (&reaper{ac: baseClient, owners: owners}).Run(ctx)
+
+	// Media, transfer and snoop legs are managed by the call which created
+	// them
+	d := leg.NewDispatcher()
+	d.Handle(leg.Caller, appStart)
+	d.Handle(leg.Transcribe, transcribeStart)
+	d.Handle(leg.Outbound, outboundStart)
+	d.Ignore(leg.Media)
+	d.Ignore(leg.Transfer)
+	d.Ignore(leg.Snoop)
+
+	sub, err := control.SubscribeTranscribe(natsConn, transcribeRequest)
+	if err != nil {
+		log.Println("failed to subscribe to transcription requests:", err)
+		return
+	}
+	defer sub.Unsubscribe() // nolint: errcheck
+
+	osub, err := control.SubscribeOriginate(natsConn, calls.Originate)
+	if err != nil {
+		log.Println("failed to subscribe to outbound call requests:", err)
+		return
+	}
+	defer osub.Unsubscribe() // nolint: errcheck
+
 	log.Println("starting listener")
-	err = client.Listen(ctx, baseClient, appStart)
+	err = client.Listen(ctx, baseClient, d.Dispatch)
 	if err != nil {
 		log.Println("failed to listen for new calls")
 	}
@@ -40,10 +144,15 @@ func main() {
 func appStart(h *ari.ChannelHandle, startEvent *ari.StasisStart) {
 	log.Println("running app:", "channel", h.Key().ID)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5*time.Minute))
+	ctx, cancel := context.WithTimeout(context.Background(), MaxCallDuration)
 	defer cancel()
 
-	if err := app(ctx, baseClient.New(ctx), h); err != nil {
+	err := app(ctx, baseClient.New(ctx), h)
+	if err == ErrTransferred {
+		log.Println("channel transferred")
+		return
+	}
+	if err != nil {
 		log.Println("app execution failed:", err.Error())
 	}
 
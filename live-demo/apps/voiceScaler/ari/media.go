@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/CyCoreSystems/ari"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/rtp"
+	"github.com/pkg/errors"
+)
+
+// Transports by which the media legs of calls reach the voice services
+const (
+	// TransportAudioSocket carries media over TCP by app_audiosocket
+	TransportAudioSocket = "audiosocket"
+
+	// TransportRTP carries media as RTP by an ExternalMedia (UnicastRTP)
+	// channel, for Asterisk deployments which cannot load app_audiosocket
+	TransportRTP = "rtp"
+)
+
+// externalMediaEndpoint is the endpoint of an ExternalMedia channel, given
+// the address to which it sends RTP and its format
+const externalMediaEndpoint = "UnicastRTP/%s/c(%s)"
+
+// Channel variables in which Asterisk gives the address from which an
+// ExternalMedia channel sends its RTP
+const (
+	rtpAddressVariable = "UNICASTRTP_LOCAL_ADDRESS"
+	rtpPortVariable    = "UNICASTRTP_LOCAL_PORT"
+)
+
+// mediaTransport is the transport of the media legs of calls.  It may be
+// set by the MEDIA_TRANSPORT environment variable.
+var mediaTransport = TransportAudioSocket
+
+// rtpFormat is the format of the audio of RTP media legs: ulaw, alaw or
+// slin16.  It may be set by the RTP_FORMAT environment variable.
+var rtpFormat = "ulaw"
+
+// mediaRegistry records the calls of RTP media legs for the voice services.
+// It is nil unless the RTP transport is used.
+var mediaRegistry *rtp.Registry
+
+// configureMedia sets the transport of media legs from the environment
+func configureMedia(redisAddr string) error {
+	if t := os.Getenv("MEDIA_TRANSPORT"); t != "" {
+		mediaTransport = t
+	}
+	if f := os.Getenv("RTP_FORMAT"); f != "" {
+		rtpFormat = f
+	}
+
+	switch mediaTransport {
+	case TransportAudioSocket:
+		return nil
+	case TransportRTP:
+	default:
+		return errors.Errorf("unknown media transport %q", mediaTransport)
+	}
+	if rtp.FormatByName(rtpFormat) == nil {
+		return errors.Errorf("unsupported RTP format %q", rtpFormat)
+	}
+
+	mediaRegistry = rtp.NewRegistry(redisAddr)
+	return nil
+}
+
+// mediaEndpoint returns the endpoint of the media leg of a call to the voice
+// service at the given address
+func mediaEndpoint(addr, id string) string {
+	if mediaTransport == TransportRTP {
+		return fmt.Sprintf(externalMediaEndpoint, addr, rtpFormat)
+	}
+	return fmt.Sprintf(audiosocketEndpoint, addr, id)
+}
+
+// mediaSampleRate returns the sample rate of the audio of media legs, if it
+// is known before the audio arrives.  AudioSocket marks the rate of each
+// message itself.
+func mediaSampleRate() int {
+	if f := rtp.FormatByName(rtpFormat); mediaTransport == TransportRTP && f != nil {
+		return f.ClockRate
+	}
+	return 0
+}
+
+// registerMedia tells the voice services which call an RTP media leg
+// belongs to, by the address from which Asterisk sends its RTP, which is
+// all that they will see of it.  AudioSocket legs carry their own call ID.
+func registerMedia(ctx context.Context, h *ari.ChannelHandle, id string) error {
+	if mediaRegistry == nil {
+		return nil
+	}
+
+	addr, err := h.GetVariable(rtpAddressVariable)
+	if err != nil {
+		return errors.Wrap(err, "failed to get RTP address of media channel")
+	}
+	port, err := h.GetVariable(rtpPortVariable)
+	if err != nil {
+		return errors.Wrap(err, "failed to get RTP port of media channel")
+	}
+	return mediaRegistry.Register(ctx, net.JoinHostPort(addr, port), id)
+}
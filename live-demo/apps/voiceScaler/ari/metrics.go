@@ -0,0 +1,58 @@
+package main
+
+import (
+	"expvar"
+)
+
+// metricsAddr is the address on which metrics are served, as expvar JSON at
+// /debug/vars
+const metricsAddr = ":9090"
+
+// Outcomes of the AudioSocket leg of a call
+const (
+	// outcomeConnected indicates that the leg connected at the first
+	// attempt
+	outcomeConnected = "connected"
+
+	// outcomeRetried indicates that the leg connected after one or more
+	// failed attempts
+	outcomeRetried = "retried"
+
+	// outcomeFallback indicates that the leg failed and the call was sent
+	// to its fallback
+	outcomeFallback = "fallback"
+
+	// outcomeFailed indicates that the leg failed and the call was hung up
+	outcomeFailed = "failed"
+
+	// outcomeAbandoned indicates that the caller hung up before the leg
+	// connected
+	outcomeAbandoned = "abandoned"
+)
+
+// legMetrics count the AudioSocket legs of one kind
+type legMetrics struct {
+	// outcomes counts the legs by their outcome
+	outcomes *expvar.Map
+
+	// attemptFailures counts the failed attempts to connect the legs
+	attemptFailures *expvar.Int
+}
+
+// callerLegs counts the AudioSocket legs which carry calls to the voice
+// services
+var callerLegs = &legMetrics{
+	outcomes:        expvar.NewMap("audiosocket_legs"),
+	attemptFailures: expvar.NewInt("audiosocket_leg_attempt_failures"),
+}
+
+// transcriptionLegs counts the AudioSocket legs which carry the parties to
+// transcribed calls to the transcriber.  Their outcomes are connected,
+// retried, failed or abandoned.
+var transcriptionLegs = &legMetrics{
+	outcomes:        expvar.NewMap("transcription_legs"),
+	attemptFailures: expvar.NewInt("transcription_leg_attempt_failures"),
+}
+
+// reaped counts the orphaned objects torn down by the reaper, by kind
+var reaped = expvar.NewMap("reaped")
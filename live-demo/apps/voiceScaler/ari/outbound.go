@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/ari"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/leg"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/metadata"
+	"github.com/go-redis/redis"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+// defaultOutboundEndpoint is the endpoint through which numbers are called,
+// given the number.  It may be overridden by the OUTBOUND_ENDPOINT
+// environment variable.
+const defaultOutboundEndpoint = "PJSIP/%s@proxies"
+
+// numberPattern matches the numbers which outbound calls may dial
+var numberPattern = regexp.MustCompile(`^\+?[0-9]+$`)
+
+// ErrEndpointNotAllowed indicates that an outbound call was requested to an
+// endpoint which is not in OUTBOUND_ENDPOINT_PREFIXES
+var ErrEndpointNotAllowed = errors.New("endpoint not allowed")
+
+// defaultOutboundIVR is the ARI application of the IVR to which outbound
+// calls with the IVR target are sent, unless the request names another
+const defaultOutboundIVR = "demo"
+
+// outboundKeyPrefix is the prefix of the Redis keys of outbound calls.  The
+// full key is the prefix followed by the ID of the call.
+const outboundKeyPrefix = "audiosocket:outbound:"
+
+// OutboundRingTimeout is the time for which an outbound call rings before it
+// is given up, unless the request says otherwise
+var OutboundRingTimeout = 30 * time.Second
+
+// MaxOutboundDuration is the maximum length of an outbound call which is
+// tracked
+var MaxOutboundDuration = time.Hour
+
+// ErrOutboundNotFound indicates that no outbound call has the given ID
+var ErrOutboundNotFound = errors.New("outbound call not found")
+
+// Q.850 causes by which outbound calls which are not answered are hung up
+const (
+	causeUserBusy       = 17
+	causeNoUserResponse = 18
+	causeNoAnswer       = 19
+)
+
+// outbound places outbound calls and tracks their progress in Redis, so
+// that it may be read by any app
+type outbound struct {
+	c *redis.Client
+}
+
+func newOutbound(addr string) *outbound {
+	return &outbound{
+		c: redis.NewClient(&redis.Options{
+			Addr: addr,
+		}),
+	}
+}
+
+// Load returns the progress of the outbound call with the given ID
+func (o *outbound) Load(id string) (*control.OutboundCall, error) {
+	data, err := o.c.Get(outboundKeyPrefix + id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrOutboundNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load outbound call")
+	}
+
+	call := new(control.OutboundCall)
+	if err = json.Unmarshal(data, call); err != nil {
+		return nil, errors.Wrap(err, "failed to decode outbound call")
+	}
+	return call, nil
+}
+
+// put stores the progress of an outbound call and publishes it to NATS
+func (o *outbound) put(call *control.OutboundCall) {
+	data, err := json.Marshal(call)
+	if err != nil {
+		log.Println("failed to encode outbound call:", err)
+		return
+	}
+	if err = o.c.Set(outboundKeyPrefix+call.ID, data, MaxOutboundDuration).Err(); err != nil {
+		log.Println("failed to store outbound call:", err)
+	}
+	if err = natsConn.Publish(control.OutboundSubject(call.ID), data); err != nil {
+		log.Println("failed to publish outbound call:", err)
+	}
+}
+
+// Close closes the connection to Redis
+func (o *outbound) Close() error {
+	return o.c.Close()
+}
+
+// Originate places an outbound call, returning its progress once it has
+// been placed, or if req.Wait is set, once it has ended
+func (o *outbound) Originate(req *control.OriginateRequest) (*control.OutboundCall, error) {
+	endpoint, err := outboundEndpoint(req)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string]string{
+		languageVariable: req.Language,
+		messageVariable:  req.Message,
+	}
+	switch req.Target {
+	case "", control.TargetVoice:
+	case control.TargetIVR:
+		ivr := req.IVR
+		if ivr == "" {
+			ivr = defaultOutboundIVR
+		}
+		vars[ivrVariable] = ivr
+	default:
+		return nil, errors.Errorf("unknown target %q", req.Target)
+	}
+	if req.Alert {
+		if vars[ivrVariable] != "" {
+			return nil, errors.New("alerts must be sent to the voice target")
+		}
+		if req.Message == "" {
+			return nil, errors.New("alerts require a message")
+		}
+		vars[modeVariable] = metadata.ModeAlert
+	}
+
+	timeout := OutboundRingTimeout
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+
+	call := &control.OutboundCall{
+		ID:      uuid.Must(uuid.NewV1()).String(),
+		Request: *req,
+		State:   control.StateDialing,
+		Created: time.Now(),
+	}
+
+	// The call is placed and subscribed to on a single node, since the
+	// subscription sees only the events of the node of its key
+	node, err := pickNode()
+	if err != nil {
+		return nil, err
+	}
+	key := ari.NewKey(ari.ChannelKey, call.ID, ari.WithNode(node))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+MaxOutboundDuration)
+
+	// Hold the call against the reaper while it is tracked
+	release := owners.Claim(ctx, call.ID)
+
+	h, err := baseClient.Channel().StageOriginate(key, ari.OriginateRequest{
+		Endpoint:  endpoint,
+		ChannelID: call.ID,
+		App:       ariApp,
+		AppArgs:   string(leg.Outbound),
+		CallerID:  req.CallerID,
+		Timeout:   int(timeout / time.Second),
+		Variables: vars,
+	})
+	if err != nil {
+		release()
+		cancel()
+		return nil, errors.Wrap(err, "failed to stage outbound call")
+	}
+
+	sub := h.Subscribe(ari.Events.ChannelStateChange, ari.Events.StasisStart, ari.Events.ChannelDtmfReceived, ari.Events.ChannelDestroyed)
+
+	if err = h.Exec(); err != nil {
+		sub.Cancel()
+		release()
+		cancel()
+		call.State = control.StateFailed
+		call.Error = err.Error()
+		o.put(call)
+		return call, errors.Wrap(err, "failed to place outbound call")
+	}
+	log.Printf("placed outbound call %s to %s on node %s", call.ID, endpoint, node)
+	o.put(call)
+
+	// The call is updated by its tracker from here on
+	placed := *call
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer cancel()
+		defer release()
+		defer sub.Cancel()
+		o.track(ctx, call, sub)
+	}()
+
+	if !req.Wait {
+		return &placed, nil
+	}
+	<-done
+	return call, nil
+}
+
+// track follows the progress of an outbound call by its channel events until
+// it ends
+func (o *outbound) track(ctx context.Context, call *control.OutboundCall, sub ari.Subscription) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("stopped tracking outbound call %s", call.ID)
+			return
+		case e := <-sub.Events():
+			switch v := e.(type) {
+			case *ari.ChannelStateChange:
+				switch v.Channel.GetState() {
+				case "Ringing":
+					o.setState(call, control.StateRinging)
+				case "Up":
+					o.answered(call)
+				}
+			case *ari.StasisStart:
+				o.answered(call)
+			case *ari.ChannelDtmfReceived:
+				call.Digits += v.Digit
+				o.put(call)
+			case *ari.ChannelDestroyed:
+				now := time.Now()
+				call.Ended = &now
+				call.Cause = v.Cause
+				call.State = hangupState(call, v.Cause)
+				if call.State == control.StateFailed {
+					call.Error = v.CauseTxt
+				}
+				log.Printf("outbound call %s ended: %s (%s)", call.ID, call.State, v.CauseTxt)
+				o.put(call)
+				return
+			}
+		}
+	}
+}
+
+// setState records a new state of the call, if it has changed
+func (o *outbound) setState(call *control.OutboundCall, state string) {
+	if call.State == state {
+		return
+	}
+	call.State = state
+	o.put(call)
+}
+
+// answered records the answer of the call
+func (o *outbound) answered(call *control.OutboundCall) {
+	if call.Answered != nil {
+		return
+	}
+	now := time.Now()
+	call.Answered = &now
+	o.setState(call, control.StateAnswered)
+}
+
+// hangupState returns the final state of an outbound call hung up with the
+// given cause
+func hangupState(call *control.OutboundCall, cause int) string {
+	if call.Answered != nil {
+		return control.StateCompleted
+	}
+	switch cause {
+	case causeUserBusy:
+		return control.StateBusy
+	case causeNoUserResponse, causeNoAnswer:
+		return control.StateNoAnswer
+	}
+	return control.StateFailed
+}
+
+// pickNode returns the Asterisk node on which to place an outbound call,
+// chosen at random from those running the app
+func pickNode() (string, error) {
+	apps, err := baseClient.Application().List(nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list Asterisk nodes")
+	}
+	var nodes []string
+	for _, k := range apps {
+		if k.ID == ariApp && k.Node != "" {
+			nodes = append(nodes, k.Node)
+		}
+	}
+	if len(nodes) == 0 {
+		return "", errors.New("no Asterisk node is running the app")
+	}
+	return nodes[rand.Intn(len(nodes))], nil
+}
+
+// outboundEndpoint returns the endpoint to call for the request.  Endpoints
+// must begin with one of the comma-separated OUTBOUND_ENDPOINT_PREFIXES,
+// and numbers may contain only digits and a leading "+".
+func outboundEndpoint(req *control.OriginateRequest) (string, error) {
+	if req.Endpoint != "" {
+		if !endpointAllowed(req.Endpoint, os.Getenv("OUTBOUND_ENDPOINT_PREFIXES")) {
+			return "", errors.Wrap(ErrEndpointNotAllowed, req.Endpoint)
+		}
+		return req.Endpoint, nil
+	}
+	if req.Number == "" {
+		return "", errors.New("endpoint or number required")
+	}
+	if !numberPattern.MatchString(req.Number) {
+		return "", errors.Errorf("invalid number %q", req.Number)
+	}
+
+	format := os.Getenv("OUTBOUND_ENDPOINT")
+	if format == "" {
+		format = defaultOutboundEndpoint
+	}
+	return fmt.Sprintf(format, req.Number), nil
+}
+
+// endpointAllowed reports whether the endpoint begins with one of the
+// comma-separated prefixes
+func endpointAllowed(endpoint, prefixes string) bool {
+	for _, p := range strings.Split(prefixes, ",") {
+		if p = strings.TrimSpace(p); p != "" && strings.HasPrefix(endpoint, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// outboundStart handles an outbound call which has been answered, connecting
+// the callee to the target of the call
+func outboundStart(h *ari.ChannelHandle, startEvent *ari.StasisStart) {
+	log.Println("running outbound call:", "channel", h.Key().ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), MaxOutboundDuration)
+	defer cancel()
+
+	if ivr, err := h.GetVariable(ivrVariable); err == nil && ivr != "" {
+		// The call leaves the app for the IVR, so its events must be
+		// subscribed to explicitly for it to be tracked to the end
+		app := ari.NewKey(ari.ApplicationKey, ariApp, ari.WithNode(h.Key().Node))
+		if err := baseClient.Application().Subscribe(app, "channel:"+h.ID()); err != nil {
+			log.Println("failed to subscribe to outbound call:", err)
+		}
+		if err = continueInDialplan(h, ivrContext, ivrExtension, 1); err != ErrTransferred {
+			log.Println("failed to send outbound call to IVR:", err)
+			h.Hangup() // nolint: errcheck
+		}
+		return
+	}
+
+	data, err := h.Data()
+	if err != nil {
+		log.Println("failed to get channel data of outbound call:", err)
+	}
+
+	err = runVoice(ctx, baseClient.New(ctx), h, data, nil, "")
+	if err == ErrTransferred {
+		log.Println("channel transferred")
+		return
+	}
+	if err != nil {
+		log.Println("outbound call failed:", err.Error())
+	}
+
+	h.Hangup()
+	log.Println("outbound call hung up")
+}
+
+// outboundHandler serves the outbound calling API.  A POST to the base path
+// (e.g. /outbound/) with a JSON OriginateRequest places a call, and a GET
+// of a call ID (e.g. /outbound/<uuid>) returns the progress of that call.
+type outboundHandler struct {
+	o *outbound
+}
+
+// ServeHTTP implements http.Handler
+func (s *outboundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		out *control.OutboundCall
+		err error
+	)
+	switch r.Method {
+	case http.MethodPost:
+		req := new(control.OriginateRequest)
+		if err = json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		status := http.StatusCreated
+		if out, err = s.o.Originate(req); err != nil {
+			if out == nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// The call was refused by Asterisk
+			status = http.StatusBadGateway
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+	case http.MethodGet:
+		id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if id == "" {
+			http.Error(w, "call ID required", http.StatusNotFound)
+			return
+		}
+		out, err = s.o.Load(id)
+		if err == ErrOutboundNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("failed to retrieve outbound call:", err)
+			http.Error(w, "failed to retrieve outbound call", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(out); err != nil {
+		log.Println("failed to encode outbound call:", err)
+	}
+}
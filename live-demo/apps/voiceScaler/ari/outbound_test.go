@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+)
+
+func TestOutboundEndpoint(t *testing.T) {
+	os.Setenv("OUTBOUND_ENDPOINT_PREFIXES", "PJSIP/1000, PJSIP/oncall-") // nolint: errcheck
+	os.Setenv("OUTBOUND_ENDPOINT", "")                                   // nolint: errcheck
+	defer os.Unsetenv("OUTBOUND_ENDPOINT_PREFIXES")                      // nolint: errcheck
+
+	tests := []struct {
+		req  control.OriginateRequest
+		want string
+		err  bool
+	}{
+		{req: control.OriginateRequest{Endpoint: "PJSIP/1000"}, want: "PJSIP/1000"},
+		{req: control.OriginateRequest{Endpoint: "PJSIP/oncall-ops"}, want: "PJSIP/oncall-ops"},
+		{req: control.OriginateRequest{Endpoint: "PJSIP/2000"}, err: true},
+		{req: control.OriginateRequest{Endpoint: "Local/s@default"}, err: true},
+		{req: control.OriginateRequest{Number: "+14045551234"}, want: "PJSIP/+14045551234@proxies"},
+		{req: control.OriginateRequest{Number: "4045551234"}, want: "PJSIP/4045551234@proxies"},
+		{req: control.OriginateRequest{Number: "1000@other"}, err: true},
+		{req: control.OriginateRequest{Number: "1000&PJSIP/2000"}, err: true},
+		{req: control.OriginateRequest{}, err: true},
+	}
+	for _, tt := range tests {
+		got, err := outboundEndpoint(&tt.req)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%+v: expected error, got %q", tt.req, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", tt.req, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.req, got, tt.want)
+		}
+	}
+}
+
+func TestEndpointAllowedWithoutPrefixes(t *testing.T) {
+	if endpointAllowed("PJSIP/1000", "") {
+		t.Error("endpoint allowed without any prefixes")
+	}
+}
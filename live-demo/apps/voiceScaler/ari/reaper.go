@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/ari"
+	"github.com/go-redis/redis"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+// ReapInterval is the interval at which the reaper sweeps the Asterisk nodes
+// for orphaned bridges and channels
+var ReapInterval = time.Minute
+
+// OwnerTTL is the time for which the claim of an app on a call lasts unless
+// it is renewed.  Claims are renewed at a third of this interval.
+var OwnerTTL = 30 * time.Second
+
+// ownerKeyPrefix is the prefix of the Redis keys of the claims on calls.  The
+// full key is the prefix followed by the AudioSocket ID of the call.
+const ownerKeyPrefix = "audiosocket:owner:"
+
+// bridgePrefix is the prefix of the IDs of the bridges of calls.  The full ID
+// is the prefix followed by the AudioSocket ID of the call.
+const bridgePrefix = "bridge-"
+
+// ownerStore records which calls are being run by a live app, so that the
+// reaper can tell those which have been abandoned
+type ownerStore struct {
+	c    *redis.Client
+	host string
+}
+
+func newOwnerStore(addr string) *ownerStore {
+	host, _ := os.Hostname()
+	return &ownerStore{
+		c: redis.NewClient(&redis.Options{
+			Addr: addr,
+		}),
+		host: host,
+	}
+}
+
+// Claim claims the call for this app, renewing the claim until the context
+// is cancelled or the returned function is called
+func (o *ownerStore) Claim(ctx context.Context, id string) func() {
+	ctx, cancel := context.WithCancel(ctx)
+
+	renew := func() {
+		if err := o.c.Set(ownerKeyPrefix+id, o.host, OwnerTTL).Err(); err != nil {
+			log.Println("failed to claim call:", err)
+		}
+	}
+	renew()
+
+	go func() {
+		t := time.NewTicker(OwnerTTL / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				renew()
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		o.c.Del(ownerKeyPrefix + id) // nolint: errcheck
+	}
+}
+
+// Live reports whether the call is claimed by a live app
+func (o *ownerStore) Live(id string) (bool, error) {
+	n, err := o.c.Exists(ownerKeyPrefix + id).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check owner of call")
+	}
+	return n > 0, nil
+}
+
+// Close closes the connection to Redis
+func (o *ownerStore) Close() error {
+	return o.c.Close()
+}
+
+// reaper tears down the bridges and channels which the app created for calls
+// which no live app owns, as when an app crashes mid-call.  Since a call is
+// claimed only after its AudioSocket ID is chosen, an object must be found
+// orphaned by two consecutive sweeps before it is reaped.
+type reaper struct {
+	ac     ari.Client
+	owners *ownerStore
+
+	// suspects are the objects found orphaned by the last sweep
+	suspects map[string]bool
+}
+
+// Run sweeps at each ReapInterval until the context is cancelled
+func (r *reaper) Run(ctx context.Context) {
+	t := time.NewTicker(ReapInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.sweep()
+		}
+	}
+}
+
+func (r *reaper) sweep() {
+	next := make(map[string]bool)
+	defer func() {
+		r.suspects = next
+	}()
+
+	bridges, err := r.ac.Bridge().List(nil)
+	if err != nil {
+		log.Println("reaper failed to list bridges:", err)
+		return
+	}
+	for _, k := range bridges {
+		if !strings.HasPrefix(k.ID, bridgePrefix) {
+			continue
+		}
+		if r.orphaned(k, strings.TrimPrefix(k.ID, bridgePrefix), next) {
+			r.reapBridge(k)
+		}
+	}
+
+	channels, err := r.ac.Channel().List(nil)
+	if err != nil {
+		log.Println("reaper failed to list channels:", err)
+		return
+	}
+	for _, k := range channels {
+		if r.orphaned(k, k.ID, next) {
+			r.reap(ari.ChannelKey, k, r.ac.Channel().Get(k).Hangup())
+		}
+	}
+}
+
+// orphaned reports whether the object belongs to a call which no live app
+// owns, and has been found so before.  The object ID must begin with the
+// AudioSocket ID of the call.
+func (r *reaper) orphaned(k *ari.Key, id string, next map[string]bool) bool {
+	callID, ok := callIDOf(id)
+	if !ok {
+		return false
+	}
+
+	live, err := r.owners.Live(callID)
+	if err != nil {
+		log.Println("reaper failed to check owner:", err)
+		return false
+	}
+	if live {
+		return false
+	}
+
+	name := k.Kind + ":" + k.ID
+	if !r.suspects[name] {
+		next[name] = true
+		return false
+	}
+	return true
+}
+
+// reapBridge hangs up the channels of the bridge, which are left with no
+// app to serve them, and destroys it
+func (r *reaper) reapBridge(k *ari.Key) {
+	data, err := r.ac.Bridge().Data(k)
+	if err != nil {
+		log.Println("reaper failed to get bridge data:", err)
+		return
+	}
+	for _, id := range data.ChannelIDs {
+		ck := k.New(ari.ChannelKey, id)
+		r.reap(ari.ChannelKey, ck, r.ac.Channel().Get(ck).Hangup())
+	}
+	r.reap(ari.BridgeKey, k, r.ac.Bridge().Delete(k))
+}
+
+// reap logs and counts the teardown of an object
+func (r *reaper) reap(kind string, k *ari.Key, err error) {
+	if err != nil {
+		log.Printf("reaper failed to tear down %s %s on node %s: %v", kind, k.ID, k.Node, err)
+		return
+	}
+	log.Printf("reaped orphaned %s %s on node %s", kind, k.ID, k.Node)
+	reaped.Add(kind, 1)
+}
+
+// callIDOf returns the AudioSocket ID of the call to which an object belongs,
+// from the ID of the object, which is either the AudioSocket ID of the call
+// or that ID followed by a hyphen and a suffix
+func callIDOf(id string) (string, bool) {
+	if len(id) < 36 || (len(id) > 36 && id[36] != '-') {
+		return "", false
+	}
+	if _, err := uuid.FromString(id[:36]); err != nil {
+		return "", false
+	}
+	return id[:36], true
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/CyCoreSystems/ari"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/caption"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/leg"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/metadata"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+// defaultTranscriberAddr is the address of the AudioSocket transcriber to
+// which snooped calls are sent.  It may be overridden by the
+// TRANSCRIBER_ADDR environment variable.
+const defaultTranscriberAddr = "voice-transscriber:8080"
+
+// MaxTranscriptionDuration is the maximum length of the transcription of an
+// existing call
+var MaxTranscriptionDuration = 4 * time.Hour
+
+// transcriberAddr returns the address of the AudioSocket transcriber
+func transcriberAddr() string {
+	if addr := os.Getenv("TRANSCRIBER_ADDR"); addr != "" {
+		return addr
+	}
+	return defaultTranscriberAddr
+}
+
+// transcribeStart handles a channel sent to the app by the dialplan to be
+// transcribed, which returns to the dialplan once its snoop is attached
+func transcribeStart(h *ari.ChannelHandle, startEvent *ari.StasisStart) {
+	language, _ := h.GetVariable(languageVariable) // nolint: errcheck
+
+	if _, err := transcribeChannel(baseClient, h.Key(), language); err != nil {
+		log.Println("failed to transcribe channel:", err)
+	}
+	if err := h.Continue("", "", 0); err != nil {
+		log.Println("failed to return channel to dialplan:", err)
+	}
+}
+
+// transcribeRequest handles a request to transcribe an existing channel
+func transcribeRequest(req *control.TranscribeRequest) (string, error) {
+	if req.Channel == "" {
+		return "", errors.New("channel required")
+	}
+	return transcribeChannel(baseClient, ari.NewKey(ari.ChannelKey, req.Channel, ari.WithNode(req.Node)), req.Language)
+}
+
+// party is one side of a transcribed call, which is carried to the
+// transcriber on its own leg so that its speech can be labelled
+type party struct {
+	// speaker is the label of the party's captions
+	speaker string
+
+	// spy is the direction of the snooped channel's audio which carries the
+	// party's speech
+	spy ari.Direction
+}
+
+// parties are the sides of a transcribed call.  The caller is heard on the
+// audio coming in from the snooped channel, and whoever it is talking to on
+// the audio going out to it.
+var parties = []party{
+	{speaker: caption.SpeakerCaller, spy: ari.DirectionIn},
+	{speaker: caption.SpeakerCallee, spy: ari.DirectionOut},
+}
+
+// transcriptionLeg is the snoop channel, bridge and AudioSocket leg which
+// carry one party to a transcribed call to the transcriber
+type transcriptionLeg struct {
+	id      string
+	snoop   *ari.ChannelHandle
+	as      *ari.ChannelHandle
+	br      *ari.BridgeHandle
+	release func()
+}
+
+// close tears down the leg
+func (l *transcriptionLeg) close() {
+	for _, h := range []*ari.ChannelHandle{l.snoop, l.as} {
+		if h != nil {
+			h.Hangup() // nolint: errcheck
+		}
+	}
+	if l.br != nil {
+		l.br.Delete() // nolint: errcheck
+	}
+	callMetadata.Delete(context.Background(), l.id) // nolint: errcheck
+	l.release()
+}
+
+// transcribeChannel attaches the transcriber to a channel by spy-only snoop
+// channels, one for each party to the call, so that the parties hear
+// nothing.  It returns the ID of the call's transcript once the transcriber
+// is connected.
+func transcribeChannel(ac ari.Client, key *ari.Key, language string) (string, error) {
+	// Find the node of the channel, if it is not known
+	data, err := ac.Channel().Data(key)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to find channel %s", key.ID)
+	}
+	if data.Key != nil {
+		key = data.Key
+	}
+
+	transcript := uuid.Must(uuid.NewV1()).String()
+	ctx, cancel := context.WithTimeout(context.Background(), MaxTranscriptionDuration)
+
+	var legs []*transcriptionLeg
+	cleanup := func() {
+		for _, l := range legs {
+			l.close()
+		}
+		cancel()
+	}
+
+	for _, p := range parties {
+		l, err := startTranscriptionLeg(ctx, ac, key, data, transcript, p, language)
+		if err != nil {
+			cleanup()
+			return "", err
+		}
+		legs = append(legs, l)
+	}
+
+	log.Printf("transcribing channel %s as %s", key.ID, transcript)
+	go func() {
+		defer cleanup()
+
+		// The snoop channels end with the channel on which they spy.  When
+		// any channel of any leg ends, the whole transcription ends.
+		ended := make(chan struct{}, 2*len(legs))
+		for _, l := range legs {
+			for _, h := range []*ari.ChannelHandle{l.snoop, l.as} {
+				sub := h.Subscribe(ari.Events.ChannelDestroyed)
+				defer sub.Cancel()
+
+				go func() {
+					select {
+					case <-ctx.Done():
+					case <-sub.Events():
+						ended <- struct{}{}
+					}
+				}()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ended:
+		}
+		log.Printf("transcription %s of channel %s ended", transcript, key.ID)
+	}()
+
+	return transcript, nil
+}
+
+// startTranscriptionLeg connects one party to a channel to the transcriber
+func startTranscriptionLeg(ctx context.Context, ac ari.Client, key *ari.Key, data *ari.ChannelData, transcript string, p party, language string) (l *transcriptionLeg, err error) {
+	l = &transcriptionLeg{id: uuid.Must(uuid.NewV1()).String()}
+
+	// Hold the leg against the reaper while it runs
+	l.release = owners.Claim(ctx, l.id)
+	defer func() {
+		if err != nil {
+			l.close()
+		}
+	}()
+
+	// Tell the transcriber which channel and party it is transcribing
+	m := &metadata.Metadata{
+		ID:         l.id,
+		Channel:    key.ID,
+		Node:       key.Node,
+		Language:   language,
+		Mode:       metadata.ModeTranscribe,
+		Transcript: transcript,
+		Speaker:    p.speaker,
+		SpeakerID:  speakerID(data, p.speaker),
+		SampleRate: mediaSampleRate(),
+	}
+	if c := data.GetCaller(); c != nil {
+		m.CallerID = c.GetNumber()
+		m.CallerName = c.GetName()
+	}
+	if d := data.GetDialplan(); d != nil {
+		m.DNIS = d.GetExten()
+	}
+	if err = callMetadata.Put(ctx, m); err != nil {
+		log.Println("failed to publish transcription metadata:", err)
+	}
+
+	if l.snoop, err = startSnoop(ctx, ac, key, l.id, p.spy); err != nil {
+		return l, err
+	}
+	l.br, l.as, err = connectTranscriber(ctx, ac, l.snoop, l.id)
+	return l, err
+}
+
+// speakerID returns the number of the given party to a channel's call, if
+// known.  The callee is the connected line, or else the number which was
+// dialed.
+func speakerID(data *ari.ChannelData, speaker string) string {
+	if speaker == caption.SpeakerCaller {
+		return data.GetCaller().GetNumber()
+	}
+	if n := data.GetConnected().GetNumber(); n != "" {
+		return n
+	}
+	return data.GetDialplan().GetExten()
+}
+
+// startSnoop creates a snoop channel on the channel which spies in the given
+// direction only, and waits for it to enter the app
+func startSnoop(ctx context.Context, ac ari.Client, key *ari.Key, id string, spy ari.Direction) (*ari.ChannelHandle, error) {
+	snoop, err := ac.Channel().StageSnoop(key, id+"-snoop", &ari.SnoopOptions{
+		App:     ac.ApplicationName(),
+		AppArgs: string(leg.Snoop),
+		Spy:     spy,
+		Whisper: ari.DirectionNone,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stage snoop channel creation")
+	}
+
+	sub := snoop.Subscribe(ari.Events.StasisStart)
+	defer sub.Cancel()
+
+	if err := snoop.Exec(); err != nil {
+		return nil, errors.Wrapf(err, "failed to snoop on channel %s", key.ID)
+	}
+
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-time.After(LocalChannelAnswerTimeout):
+		err = errors.New("timed out waiting for snoop channel to start")
+	case <-sub.Events():
+		return snoop, nil
+	}
+	snoop.Hangup() // nolint: errcheck
+	return nil, err
+}
+
+// connectTranscriber bridges the snoop channel to an AudioSocket leg to the
+// transcriber
+func connectTranscriber(ctx context.Context, ac ari.Client, snoop *ari.ChannelHandle, id string) (*ari.BridgeHandle, *ari.ChannelHandle, error) {
+	br, err := ac.Bridge().Create(snoop.Key(), "mixing", bridgePrefix+id)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create bridge")
+	}
+	if err := br.AddChannel(snoop.ID()); err != nil {
+		br.Delete() // nolint: errcheck
+		return nil, nil, errors.Wrap(err, "failed to add snoop channel to bridge")
+	}
+
+	as, err := connectAudioSocket(ctx, ac, snoop, br, id, transcriberAddr(), transcriptionLegs)
+	if err != nil {
+		if ctx.Err() != nil {
+			transcriptionLegs.outcomes.Add(outcomeAbandoned, 1)
+		} else {
+			transcriptionLegs.outcomes.Add(outcomeFailed, 1)
+		}
+		br.Delete() // nolint: errcheck
+		return nil, nil, err
+	}
+	return br, as, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/keypad"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/lang"
+)
+
+// AlertRepeats is the number of times an alert is read before it is given
+// up as unanswered
+const AlertRepeats = 3
+
+// AlertAnswerTimeout is the time for which the callee is given to answer an
+// alert once it has been read
+const AlertAnswerTimeout = 10 * time.Second
+
+// runAlert reads an alert to the callee until they acknowledge it or pass it
+// on, or it has been read AlertRepeats times.  The ARI app which placed the
+// call takes their answer from the keys they pressed.
+func runAlert(ctx context.Context, kc *keypad.Conn, l *lang.Language, msg string) error {
+	kc.Clear()
+	for i := 0; i < AlertRepeats; i++ {
+		if err := speak(ctx, kc, l, msg+"  "+message(l, "alertPrompt")); err != nil {
+			return err
+		}
+
+		digit, err := kc.Collect(ctx, "", AlertAnswerTimeout, func(string) bool {
+			return true
+		})
+		if err == keypad.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch digit {
+		case control.AlertAcknowledge:
+			return speak(ctx, kc, l, message(l, "alertAcknowledged"))
+		case control.AlertEscalate:
+			return speak(ctx, kc, l, message(l, "alertEscalated"))
+		}
+	}
+	return speak(ctx, kc, l, message(l, "alertUnanswered"))
+}
@@ -6,16 +6,27 @@ import (
 	"io"
 	"log"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	speech "cloud.google.com/go/speech/apiv1"
 	texttospeech "cloud.google.com/go/texttospeech/apiv1"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/apps/dtmfScaler/deployment"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/audio"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/keypad"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/lang"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/load"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/metadata"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/rtp"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/session"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/synth"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/tz"
 	"github.com/CyCoreSystems/audiosocket"
-	"github.com/ericchiang/k8s"
-	"github.com/ericchiang/k8s/apis/apps/v1"
 	"github.com/gofrs/uuid"
+	nats "github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 	speechv1 "google.golang.org/genproto/googleapis/cloud/speech/v1"
 	texttospeechv1 "google.golang.org/genproto/googleapis/cloud/texttospeech/v1"
@@ -27,22 +38,70 @@ const MaxCallDuration = 2 * time.Minute
 // MaxRecognitionDuration is the maximum amount of time to allow for a single voice recognition session to complete
 const MaxRecognitionDuration = time.Minute
 
+// AutoscalerPinDuration is the amount of time for which a spoken scaling
+// request holds an autoscaled workload at the requested size.
+const AutoscalerPinDuration = 30 * time.Minute
+
+// ScaleWaitTimeout is the maximum time for which a caller is kept waiting
+// for the instances of a scaled workload to become ready
+const ScaleWaitTimeout = 30 * time.Second
+
+// MetadataWait is the maximum time to wait for the metadata of a call to be
+// published by the front-end
+const MetadataWait = 2 * time.Second
+
 const listenAddr = ":8080"
-const redisAddr = "redis:6379"
-const languageCode = "en-US"
 
-// slinChunkSize is the number of bytes which should be sent per Slin
-// audiosocket message.  Larger data will be chunked into this size for
-// transmission of the AudioSocket.
-//
-// This is based on 8kHz, 20ms, 16-bit signed linear.
-const slinChunkSize = 320 // 8000Hz * 20ms * 2 bytes
+// serviceName identifies this service in the sessions it stores
+const serviceName = "voice-scaler"
+
+// commandState is the state of a call which is waiting for a command
+const commandState = "command"
+
+// MaxCountFailures is the number of times in a row which the number of
+// instances may fail to be understood before the caller is asked to enter it
+// on their keypad
+const MaxCountFailures = 2
+
+// InterDigitTimeout is the maximum time to wait for each digit entered on
+// the keypad
+const InterDigitTimeout = 5 * time.Second
+
+// countFailuresSlot is the session slot which counts the times in a row the
+// number of instances has not been understood
+const countFailuresSlot = "countFailures"
+
+// defaultLexiconPath is the location of the pronunciation lexicon.  It may be
+// overridden by the LEXICON environment variable.
+const defaultLexiconPath = "/etc/voice-scaler/lexicon.yaml"
 
 // ErrHangup indicates that the call should be terminated or has been terminated
 var ErrHangup = errors.New("Hangup")
 
+var asteriskTarget = deployment.Target{
+	Kind:      deployment.Deployment,
+	Namespace: "voip",
+	Name:      "asterisk",
+}
+
 var recog *speech.Client
 var tts *texttospeech.Client
+var scaler *deployment.Client
+var synthesizer *synth.Synthesizer
+var zones *tz.Resolver
+
+// languages maps dialed numbers to the languages of their calls.  It is
+// loaded from the LANGUAGE_BY_DNIS environment variable, in the form
+// "number=code,number=code".
+var languages lang.Table
+var callMetadata metadata.Store
+var sessions session.Store
+var natsConn *nats.Conn
+var activeCalls load.Counter
+
+// sampleRate is the sample rate expected of RTP streams until their
+// front-end or their audio says otherwise.  AudioSocket calls start at 8kHz.
+var sampleRate = audio.DefaultRate
 var googleCreds = "/var/secrets/google/google.json"
 
 func main() {
@@ -64,6 +123,41 @@ func main() {
 		log.Fatalln("failed to connect to Google Text-to-Speech API service:", err)
 	}
 	defer tts.Close()
+	if synthesizer, err = synth.FromEnv(defaultLexiconPath); err != nil {
+		log.Fatalln("failed to configure speech synthesis:", err)
+	}
+	if zones, err = tz.FromEnv(); err != nil {
+		log.Fatalln("failed to load time zones:", err)
+	}
+	if languages, err = lang.ParseTable(os.Getenv("LANGUAGE_BY_DNIS")); err != nil {
+		log.Fatalln("failed to parse LANGUAGE_BY_DNIS:", err)
+	}
+	if sampleRate, err = audio.RateFromEnv(); err != nil {
+		log.Fatalln("failed to configure sample rate:", err)
+	}
+	if scaler, err = deployment.New(); err != nil {
+		log.Fatalln("failed to connect to kubernetes:", err)
+	}
+	store := metadata.NewRedisStoreFromEnv()
+	defer store.Close() // nolint: errcheck
+	callMetadata = store
+
+	sessions = session.FromEnv()
+	if c, ok := sessions.(io.Closer); ok {
+		defer c.Close() // nolint: errcheck
+	}
+
+	// Keys are relayed over NATS by the ARI app, if there is one
+	if uri := os.Getenv("NATS_URI"); uri != "" {
+		if natsConn, err = nats.Connect(uri); err != nil {
+			log.Fatalln("failed to connect to NATS:", err)
+		}
+		defer natsConn.Close()
+		go activeCalls.Run(ctx, natsConn, serviceName)
+	}
+
+	scaler.PinDuration = AutoscalerPinDuration
+	go scaler.RunReleaser(ctx, asteriskTarget.Namespace)
 
 	if err = Listen(ctx); err != nil {
 		log.Fatalln("listen failure:", err)
@@ -71,15 +165,45 @@ func main() {
 	log.Println("exiting")
 }
 
-// Listen listens for and responds to Audiosocket connections
+// Listen listens for and responds to Audiosocket connections, and to RTP
+// streams from ExternalMedia channels on the same port
 func Listen(ctx context.Context) error {
 	l, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		return errors.Wrapf(err, "failed to bind listener to socket %s", listenAddr)
 	}
 
+	registry := rtp.NewRegistry(metadata.RedisAddr())
+	defer registry.Close() // nolint: errcheck
+
+	rl, err := rtp.Listen(listenAddr, registry)
+	if err != nil {
+		return err
+	}
+	defer rl.Close() // nolint: errcheck
+
+	// RTP cannot signal a hangup, so have the ARI app hang up the channel
+	if natsConn != nil {
+		controller := control.NewClient(natsConn)
+		rl.OnHangup = func(id string) {
+			if err := controller.Send(context.Background(), id, &control.Command{Kind: control.Hangup}); err != nil {
+				log.Printf("failed to hang up RTP call %s: %v", id, err)
+			}
+		}
+	}
+	go accept(ctx, rl)
+
+	accept(ctx, l)
+	return nil
+}
+
+// accept handles the connections of the listener until it is closed
+func accept(ctx context.Context, l net.Listener) {
 	for {
 		conn, err := l.Accept()
+		if err == rtp.ErrClosed {
+			return
+		}
 		if err != nil {
 			log.Println("failed to accept new connection:", err)
 			continue
@@ -92,6 +216,7 @@ func Listen(ctx context.Context) error {
 // Handle processes a call
 func Handle(pCtx context.Context, c net.Conn) {
 	ctx, cancel := context.WithTimeout(pCtx, MaxCallDuration)
+	defer activeCalls.Start()()
 
 	defer func() {
 		cancel()
@@ -109,23 +234,76 @@ func Handle(pCtx context.Context, c net.Conn) {
 	}
 	log.Printf("processing call %s", id.String())
 
-	resp, err := tts.SynthesizeSpeech(ctx, greeting())
+	ac := audio.NewConn(c, rtp.InitialRate(c, sampleRate))
+	loc := zones.Default
+	l := lang.Default()
+	m, err := metadata.Lookup(ctx, callMetadata, id.String(), MetadataWait)
+	if err == nil {
+		log.Printf("call %s is from %q to %q", id.String(), m.CallerID, m.DNIS)
+		ac.SetRate(m.SampleRate)
+		_, loc = zones.Resolve(m.CallerID, "")
+		if cl := languages.Resolve(m.Language, m.DNIS); cl != nil {
+			l = cl
+		}
+	} else {
+		log.Printf("no metadata for call %s: %v", id.String(), err)
+	}
+
+	sess := session.New(id.String(), serviceName)
+	sess.Node = commandState
+	sess.Language = l.Code
+	defer sessions.Delete(context.Background(), sess.ID) // nolint: errcheck
+
+	kc := keypad.New(ac)
+	if natsConn != nil {
+		sub, err := control.SubscribeEvents(natsConn, id.String(), func(e *control.Event) {
+			if e.Kind == control.EventDTMF && e.Digit != "" {
+				kc.Relay(keypad.SourceARI, rune(e.Digit[0]))
+			}
+		})
+		if err != nil {
+			log.Println("failed to subscribe to call events:", err)
+		} else {
+			defer sub.Unsubscribe() // nolint: errcheck
+		}
+	}
+
+	// Alert calls only read their alert and take the callee's answer
+	if m != nil && m.Mode == metadata.ModeAlert {
+		if err = runAlert(ctx, kc, l, m.Message); err != nil {
+			log.Println("failed to run alert:", err)
+		}
+		return
+	}
+
+	// Give the reason for the call first, as for outbound calls
+	if m != nil && m.Message != "" {
+		if err = speak(ctx, kc, l, m.Message); err != nil {
+			log.Println("failed to speak call message:", err)
+		}
+	}
+
+	rate := ac.Rate()
+	resp, err := tts.SynthesizeSpeech(ctx, greeting(l, rate))
 	if err != nil {
 		log.Println("failed to synthesize greeting:", err)
 		return
 	}
-	if err = sendAudio(c, resp.GetAudioContent()); err != nil {
+	if err = sendAudio(kc, rate, resp.GetAudioContent()); err != nil {
 		log.Println("failed to send greeting to Asterisk:", err)
 	}
 
 	for ctx.Err() == nil {
 		log.Println("waiting for command")
-		resp, err := processCommand(ctx, c)
+		if err := sessions.Save(ctx, sess); err != nil {
+			log.Println("failed to save session:", err)
+		}
+		resp, err := processCommand(ctx, kc, sess, l, loc)
 		if err != nil {
 			log.Println("failed to process command:", err)
 		}
 		if resp != "" {
-			if err = speak(ctx, c, resp); err != nil {
+			if err = speak(ctx, kc, l, resp); err != nil {
 				log.Println("failed to speak response:", err)
 			}
 		}
@@ -148,27 +326,18 @@ func getCallID(c net.Conn) (uuid.UUID, error) {
 	return uuid.FromBytes(m.Payload())
 }
 
-func ttsRequest(msg string) *texttospeechv1.SynthesizeSpeechRequest {
-	return &texttospeechv1.SynthesizeSpeechRequest{
-		Input: &texttospeechv1.SynthesisInput{
-			InputSource: &texttospeechv1.SynthesisInput_Text{
-				Text: msg,
-			},
-		},
-		Voice: &texttospeechv1.VoiceSelectionParams{
-			LanguageCode: languageCode,
-		},
-		AudioConfig: &texttospeechv1.AudioConfig{
-			AudioEncoding:   texttospeechv1.AudioEncoding_LINEAR16,
-			SampleRateHertz: 8000,
-		},
-	}
+// ttsRequest returns the request to synthesize the message in the given
+// language at the given sample rate
+func ttsRequest(l *lang.Language, msg string, rate int) *texttospeechv1.SynthesizeSpeechRequest {
+	return synthesizer.RequestAt(l.Code, msg, rate)
 }
 
-func recognizeRequest(pCtx context.Context, r io.Reader) (string, error) {
+func recognizeRequest(pCtx context.Context, r io.Reader, l *lang.Language) (string, error) {
 	ctx, cancel := context.WithTimeout(pCtx, MaxRecognitionDuration)
 	defer cancel()
 
+	rate := audio.RecognitionRate(audio.RateOf(r))
+
 	svc, err := recog.StreamingRecognize(ctx)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to start streaming recognition")
@@ -179,24 +348,13 @@ func recognizeRequest(pCtx context.Context, r io.Reader) (string, error) {
 			StreamingConfig: &speechv1.StreamingRecognitionConfig{
 				Config: &speechv1.RecognitionConfig{
 					Encoding:        speechv1.RecognitionConfig_LINEAR16,
-					SampleRateHertz: 8000,
-					LanguageCode:    languageCode,
+					SampleRateHertz: int32(rate),
+					LanguageCode:    l.Code,
 					Model:           "command_and_search",
 					UseEnhanced:     true,
 					SpeechContexts: []*speechv1.SpeechContext{
 						&speechv1.SpeechContext{
-							Phrases: []string{
-								"asterisk",
-								"asterisks",
-								"bye",
-								"goodbye",
-								"hello",
-								"kamailio",
-								"kamailios",
-								"proxy",
-								"proxies",
-								"scale",
-							},
+							Phrases: phrases(l, "hints"),
 						},
 					},
 				},
@@ -206,7 +364,17 @@ func recognizeRequest(pCtx context.Context, r io.Reader) (string, error) {
 		return "", errors.Wrap(err, "failed to send recognition config")
 	}
 
-	go pipeFromAsterisk(ctx, r, svc)
+	// Stop reading audio before returning, so that the next reader starts
+	// at a message boundary
+	piped := make(chan struct{})
+	go func() {
+		pipeFromAsterisk(ctx, r, svc, rate)
+		close(piped)
+	}()
+	defer func() {
+		cancel()
+		<-piped
+	}()
 
 	resp, err := svc.Recv()
 	if err == io.EOF {
@@ -232,31 +400,91 @@ func recognizeRequest(pCtx context.Context, r io.Reader) (string, error) {
 	return "", nil
 }
 
-func greeting() *texttospeechv1.SynthesizeSpeechRequest {
-	return ttsRequest("Hello.  How may I help you?")
+func greeting(l *lang.Language, rate int) *texttospeechv1.SynthesizeSpeechRequest {
+	return ttsRequest(l, message(l, "greeting"), rate)
 }
 
-func parting() *texttospeechv1.SynthesizeSpeechRequest {
-	return ttsRequest("Good-bye.  Thank you for playing.")
+func parting(l *lang.Language, rate int) *texttospeechv1.SynthesizeSpeechRequest {
+	return ttsRequest(l, message(l, "parting"), rate)
 }
 
-func scaleAsterisk(ctx context.Context, count int, w io.Writer) (string, error) {
+// scaleAsterisk scales Asterisk to the given count.  Unless it is
+// autoscaled, the caller is told that it has been scaled and kept waiting
+// for the new instances to be ready.
+func scaleAsterisk(ctx context.Context, l *lang.Language, count int, rw io.ReadWriter) (string, error) {
 	if count > 10 {
-		return "Sorry, I can only scale to ten Asterisk instances", nil
+		return message(l, "tooMany"), nil
 	}
 
-	if err := scaleDeployment(ctx, "asterisk", "voip", int32(count)); err != nil {
-		return "Sorry, I failed to scale Asterisk", err
+	status, err := scaler.Get(ctx, asteriskTarget)
+	if err != nil {
+		return message(l, "scaleFailed"), err
+	}
+
+	if err := scaler.Scale(ctx, asteriskTarget, int32(count)); err != nil {
+		if err == deployment.ErrAutoscalerMinimum {
+			return message(l, "autoscalerMinimum"), nil
+		}
+		return message(l, "scaleFailed"), err
+	}
+
+	if status.Autoscaler != nil {
+		return fmt.Sprintf(message(l, "autoscalerHeld"), instances(l, count), int(AutoscalerPinDuration.Minutes())), nil
+	}
+
+	if err := speak(ctx, rw, l, fmt.Sprintf(message(l, "scaled"), instances(l, count))); err != nil {
+		log.Println("failed to speak response:", err)
+	}
+
+	wCtx, cancel := context.WithTimeout(ctx, ScaleWaitTimeout)
+	defer cancel()
+	if _, err := scaler.Wait(wCtx, asteriskTarget); err != nil {
+		log.Println("asterisk is not yet ready:", err)
+		return message(l, "scaledNotReady"), nil
+	}
+	return message(l, "scaledReady"), nil
+}
+
+// describeAsterisk describes the state of Asterisk, giving times in the
+// caller's zone
+func describeAsterisk(ctx context.Context, l *lang.Language, loc *time.Location) (string, error) {
+	status, err := scaler.Get(ctx, asteriskTarget)
+	if err != nil {
+		return message(l, "statusFailed"), errors.Wrap(err, "failed to get asterisk deployment")
+	}
+
+	msg := fmt.Sprintf(message(l, "status"), instances(l, int(status.Desired)), status.Ready)
+
+	if a := status.Autoscaler; a != nil {
+		switch {
+		case a.Pinned() && a.Temporary():
+			msg += "  " + fmt.Sprintf(message(l, "statusHeldUntil"), instances(l, int(a.Min)), clockTime(l, a.PinnedUntil.In(loc)))
+		case a.Pinned():
+			msg += "  " + fmt.Sprintf(message(l, "statusHeld"), instances(l, int(a.Min)))
+		default:
+			msg += "  " + fmt.Sprintf(message(l, "statusAutoscaled"), a.Min, a.Max)
+		}
 	}
+	return msg, nil
+}
 
+func instances(l *lang.Language, count int) string {
 	if count == 1 {
-		return "Scaled asterisk to 1 instance", nil
+		return message(l, "instance")
+	}
+	return fmt.Sprintf(message(l, "instances"), count)
+}
+
+// clockTime formats a time of day as it is spoken in the given language
+func clockTime(l *lang.Language, t time.Time) string {
+	if l.Clock24 {
+		return t.Format("15:04")
 	}
-	return fmt.Sprintf("Asterisk has been scaled to %d instances.", count), nil
+	return t.Format("3:04 PM")
 }
 
-func scaleKamailio(count int, w io.Writer) (string, error) {
-	return "I cannot scale proxies yet", errors.New("not implemented")
+func scaleKamailio(l *lang.Language, count int, w io.Writer) (string, error) {
+	return message(l, "proxiesUnsupported"), errors.New("not implemented")
 	/*
 		var req *texttospeechv1.SynthesizeSpeechRequest
 		var err error
@@ -273,7 +501,9 @@ func scaleKamailio(count int, w io.Writer) (string, error) {
 
 }
 
-func pipeFromAsterisk(ctx context.Context, in io.Reader, out speechv1.Speech_StreamingRecognizeClient) {
+// pipeFromAsterisk sends the audio of the call to the recognizer, resampled
+// to the given rate
+func pipeFromAsterisk(ctx context.Context, in io.Reader, out speechv1.Speech_StreamingRecognizeClient, rate int) {
 	var err error
 	var m audiosocket.Message
 
@@ -293,8 +523,8 @@ func pipeFromAsterisk(ctx context.Context, in io.Reader, out speechv1.Speech_Str
 			log.Println("error from audiosocket")
 			continue
 		}
-		if m.Kind() != audiosocket.KindSlin {
-			log.Println("ignoring non-slin message", m.Kind())
+		msgRate := audio.Rate(m.Kind())
+		if msgRate == 0 {
 			continue
 		}
 		if m.ContentLength() < 1 {
@@ -303,7 +533,7 @@ func pipeFromAsterisk(ctx context.Context, in io.Reader, out speechv1.Speech_Str
 		}
 		if err = out.Send(&speechv1.StreamingRecognizeRequest{
 			StreamingRequest: &speechv1.StreamingRecognizeRequest_AudioContent{
-				AudioContent: m.Payload(),
+				AudioContent: audio.Resample(m.Payload(), msgRate, rate),
 			},
 		}); err != nil {
 			if err == io.EOF {
@@ -315,16 +545,21 @@ func pipeFromAsterisk(ctx context.Context, in io.Reader, out speechv1.Speech_Str
 	}
 }
 
-func sendAudio(w io.Writer, data []byte) error {
+// sendAudio sends audio at the given rate to the call, resampled to the
+// call's rate
+func sendAudio(w io.Writer, rate int, data []byte) error {
+	callRate := audio.RateOf(w)
+	data = audio.Resample(data, rate, callRate)
+	chunkSize := audio.ChunkSize(callRate)
 
 	var chunks int
 
 	for i := 0; i < len(data); {
-		var chunkLen = slinChunkSize
-		if i+slinChunkSize > len(data) {
+		var chunkLen = chunkSize
+		if i+chunkSize > len(data) {
 			chunkLen = len(data) - i
 		}
-		if _, err := w.Write(audiosocket.SlinMessage(data[i : i+chunkLen])); err != nil {
+		if _, err := w.Write(audio.Message(callRate, data[i:i+chunkLen])); err != nil {
 			return errors.Wrap(err, "failed to write chunk to audiosocket")
 		}
 		chunks++
@@ -334,121 +569,94 @@ func sendAudio(w io.Writer, data []byte) error {
 	return nil
 }
 
-func processCommand(ctx context.Context, rw io.ReadWriter) (string, error) {
-	cmd, err := recognizeRequest(ctx, rw)
+func processCommand(ctx context.Context, rw *keypad.Conn, s *session.Session, l *lang.Language, loc *time.Location) (string, error) {
+	cmd, err := recognizeRequest(ctx, rw, l)
 	if err != nil {
-		return "Sorry, I failed to listen to you", errors.Wrap(err, "failed to recognize request")
+		return message(l, "listenFailure"), errors.Wrap(err, "failed to recognize request")
 	}
+	s.AddTurn(commandState, cmd, "")
 
 	switch {
-	case strings.Contains(cmd, "scale"):
+	case said(l, cmd, "scale"):
 		switch {
-		case strings.Contains(cmd, "asterisk") || strings.Contains(cmd, "astris") || strings.Contains(cmd, "media"):
-			count, err := parseCount(cmd)
+		case said(l, cmd, "asterisk"):
+			count, err := getCount(ctx, rw, s, l, cmd, "Asterisk")
 			if err != nil {
-				return "Sorry, I could not understand how many Asterisk instances to scale to", errors.Wrapf(err, "failed to parse count in phrase (%s)", cmd)
+				return fmt.Sprintf(message(l, "countNotUnderstood"), "Asterisk"), errors.Wrapf(err, "failed to parse count in phrase (%s)", cmd)
 			}
-			current, err := currentDeploymentSize(ctx, "asterisk", "voip")
-			if current > 6 && count > 6 {
-				_, err = scaleAsterisk(ctx, 1, rw)
-				if err != nil {
-					return "Sorry, I was just too tired.  I could not scale up, as you requested.", errors.Wrapf(err, "failed to scale asterisk")
+			current, err := scaler.Get(ctx, asteriskTarget)
+			if err != nil {
+				return message(l, "statusFailed"), errors.Wrap(err, "failed to get asterisk deployment")
+			}
+			if current.Desired > 6 && count > 6 {
+				// The caller is told of this below, and not kept waiting
+				if err = scaler.Scale(ctx, asteriskTarget, 1); err != nil {
+					return message(l, "tooTired"), errors.Wrapf(err, "failed to scale asterisk")
 				}
-				return "Sorry, you are too poor. I have scaled to a single instance instead.  Have you considered using a Raspberry Pi?", nil
+				return message(l, "tooPoor"), nil
 			}
-			return scaleAsterisk(ctx, count, rw)
-		case strings.Contains(cmd, "prox") || strings.Contains(cmd, "kamailio"):
-			count, err := parseCount(cmd)
+			return scaleAsterisk(ctx, l, count, rw)
+		case said(l, cmd, "proxy"):
+			count, err := getCount(ctx, rw, s, l, cmd, "Kamailio")
 			if err != nil {
-				return "Sorry, I could not understand how many Kamailio instances to scale to", errors.Wrapf(err, "failed to parse count in phrase (%s)", cmd)
+				return fmt.Sprintf(message(l, "countNotUnderstood"), "Kamailio"), errors.Wrapf(err, "failed to parse count in phrase (%s)", cmd)
 			}
-			return scaleKamailio(count, rw)
+			return scaleKamailio(l, count, rw)
 		}
-	case strings.Contains(cmd, "hello"):
-		return "Hello.  How may I help you?", nil
-	case strings.Contains(cmd, "bye"):
-		return "Good bye!", ErrHangup
+	case said(l, cmd, "status"):
+		return describeAsterisk(ctx, l, loc)
+	case said(l, cmd, "hello"):
+		return message(l, "greeting"), nil
+	case said(l, cmd, "bye"):
+		return message(l, "goodbye"), ErrHangup
 	}
 
 	log.Println("failed to parse command:", cmd)
-	return "Sorry, I don't know how to do that", nil
+	return message(l, "unknown"), nil
 }
 
-func speak(ctx context.Context, rw io.ReadWriter, msg string) error {
-
-	resp, err := tts.SynthesizeSpeech(ctx, ttsRequest(msg))
-	if err != nil {
-		return errors.Wrap(err, "failed to synthesize speech")
-	}
-	if err = sendAudio(rw, resp.GetAudioContent()); err != nil {
-		return errors.Wrap(err, "failed to send speech to Asterisk")
+// getCount returns the number of instances of the named workload spoken in
+// the command.  Once the number has not been understood MaxCountFailures
+// times in a row, the caller is asked to enter it on their keypad instead.
+func getCount(ctx context.Context, kc *keypad.Conn, s *session.Session, l *lang.Language, cmd, name string) (int, error) {
+	count, err := l.ParseCount(cmd)
+	if err == nil {
+		delete(s.Slots, countFailuresSlot)
+		return count, nil
 	}
-	return nil
-}
 
-func parseCount(msg string) (int, error) {
-	for _, word := range strings.Split(msg, " ") {
-		// Try direct number parsing
-		if count, err := strconv.Atoi(word); err == nil {
-			return int(count), nil
-		}
-
-		switch word {
-		case "one":
-			return 1, nil
-		case "two":
-			return 2, nil
-		case "three":
-			return 3, nil
-		case "four":
-			return 4, nil
-		case "five":
-			return 5, nil
-		case "six":
-			return 6, nil
-		case "seven":
-			return 7, nil
-		case "eight":
-			return 8, nil
-		case "nine":
-			return 9, nil
-		case "ten":
-			return 10, nil
-		}
+	failures, _ := strconv.Atoi(s.Slots[countFailuresSlot])
+	if failures++; failures < MaxCountFailures {
+		s.Slots[countFailuresSlot] = strconv.Itoa(failures)
+		return 0, err
 	}
-	return 0, errors.New("failed to find count in message")
-}
+	delete(s.Slots, countFailuresSlot)
 
-func currentDeploymentSize(ctx context.Context, name, namespace string) (int, error) {
-	k, err := k8s.NewInClusterClient()
-	if err != nil {
-		return 0, errors.Wrap(err, "failed to get kubernetes client")
+	kc.Clear()
+	if err = speak(ctx, kc, l, fmt.Sprintf(message(l, "enterCount"), name)); err != nil {
+		return 0, errors.Wrap(err, "failed to ask for keypad entry")
 	}
-
-	d := new(v1.Deployment)
-	if err = k.Get(ctx, namespace, name, d); err != nil {
-		return 0, errors.Wrap(err, "failed to retrieve current deployment")
+	digits, err := kc.Collect(ctx, "", InterDigitTimeout, func(digits string) bool {
+		return strings.HasSuffix(digits, "#") || len(digits) >= 2
+	})
+	digits = strings.TrimSuffix(digits, "#")
+	if err != nil && !(err == keypad.ErrTimeout && digits != "") {
+		return 0, errors.Wrap(err, "failed to collect digits")
 	}
+	s.AddTurn(commandState, "", digits)
 
-	return int(d.GetSpec().GetReplicas()), nil
+	return strconv.Atoi(digits)
 }
 
-func scaleDeployment(ctx context.Context, name, namespace string, size int32) error {
-	k, err := k8s.NewInClusterClient()
-	if err != nil {
-		return errors.Wrap(err, "failed to get kubernetes client")
-	}
+func speak(ctx context.Context, rw io.ReadWriter, l *lang.Language, msg string) error {
+	rate := audio.RateOf(rw)
 
-	d := new(v1.Deployment)
-	if err = k.Get(ctx, namespace, name, d); err != nil {
-		return errors.Wrap(err, "failed to retrieve current deployment")
+	resp, err := tts.SynthesizeSpeech(ctx, ttsRequest(l, msg, rate))
+	if err != nil {
+		return errors.Wrap(err, "failed to synthesize speech")
 	}
-
-	current := d.GetSpec().GetReplicas()
-	if current == size {
-		return nil
+	if err = sendAudio(rw, rate, resp.GetAudioContent()); err != nil {
+		return errors.Wrap(err, "failed to send speech to Asterisk")
 	}
-
-	d.GetSpec().Replicas = &size
-	return k.Update(ctx, d)
+	return nil
 }
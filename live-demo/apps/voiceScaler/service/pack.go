@@ -0,0 +1,234 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/content"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/lang"
+)
+
+// defaultContentPath is the directory of the content packs, which is
+// normally mounted from a ConfigMap.  It may be overridden by the CONTENT
+// environment variable.
+const defaultContentPath = "/etc/voice-scaler-content"
+
+// defaultContent is the built-in content pack, over which any mounted
+// content packs are merged
+const defaultContent = `
+messages:
+  greeting:
+    en: Hello.  How may I help you?
+    es: Hola.  ¿En qué puedo ayudarle?
+    de: Hallo.  Wie kann ich Ihnen helfen?
+  parting:
+    en: Good-bye.  Thank you for playing.
+    es: Adiós.  Gracias por jugar.
+    de: Auf Wiedersehen.  Danke fürs Mitspielen.
+  goodbye:
+    en: Good bye!
+    es: ¡Adiós!
+    de: Tschüss!
+  listenFailure:
+    en: Sorry, I failed to listen to you
+    es: Lo siento, no he podido escucharle
+    de: Entschuldigung, ich konnte Ihnen nicht zuhören
+  unknown:
+    en: Sorry, I don't know how to do that
+    es: Lo siento, no sé hacer eso
+    de: Entschuldigung, das kann ich nicht
+  enterCount:
+    en: Sorry, I still did not catch that.  Please enter the number of %s instances on your keypad, followed by the pound key.
+    es: Lo siento, sigo sin entenderlo.  Marque el número de instancias de %s en su teclado, seguido de la tecla almohadilla.
+    de: Entschuldigung, ich habe es immer noch nicht verstanden.  Bitte geben Sie die Anzahl der %s-Instanzen auf Ihrer Tastatur ein, gefolgt von der Rautetaste.
+  countNotUnderstood:
+    en: Sorry, I could not understand how many %s instances to scale to
+    es: Lo siento, no he entendido a cuántas instancias de %s escalar
+    de: Entschuldigung, ich habe nicht verstanden, auf wie viele %s-Instanzen ich skalieren soll
+  tooMany:
+    en: Sorry, I can only scale to ten Asterisk instances
+    es: Lo siento, solo puedo escalar hasta diez instancias de Asterisk
+    de: Entschuldigung, ich kann nur auf bis zu zehn Asterisk-Instanzen skalieren
+  tooTired:
+    en: Sorry, I was just too tired.  I could not scale up, as you requested.
+    es: Lo siento, estaba demasiado cansado.  No he podido escalar como me pidió.
+    de: Entschuldigung, ich war einfach zu müde.  Ich konnte nicht wie gewünscht hochskalieren.
+  tooPoor:
+    en: Sorry, you are too poor. I have scaled to a single instance instead.  Have you considered using a Raspberry Pi?
+    es: Lo siento, es usted demasiado pobre.  He escalado a una sola instancia.  ¿Ha pensado en usar una Raspberry Pi?
+    de: Entschuldigung, Sie sind zu arm.  Ich habe stattdessen auf eine einzige Instanz skaliert.  Haben Sie schon an einen Raspberry Pi gedacht?
+  scaleFailed:
+    en: Sorry, I failed to scale Asterisk
+    es: Lo siento, no he podido escalar Asterisk
+    de: Entschuldigung, ich konnte Asterisk nicht skalieren
+  autoscalerMinimum:
+    en: Sorry, Asterisk is autoscaled, so I cannot scale it to fewer than one instance
+    es: Lo siento, Asterisk se escala automáticamente, así que no puedo escalarlo a menos de una instancia
+    de: Entschuldigung, Asterisk wird automatisch skaliert, daher kann ich es nicht auf weniger als eine Instanz skalieren
+  autoscalerHeld:
+    en: Autoscaling is active for Asterisk, so I have held the autoscaler at %s for the next %d minutes.
+    es: El escalado automático está activo para Asterisk, así que lo he fijado en %s durante los próximos %d minutos.
+    de: Die automatische Skalierung ist für Asterisk aktiv, daher habe ich sie für die nächsten %[2]d Minuten bei %[1]s festgehalten.
+  scaled:
+    en: Asterisk has been scaled to %s.
+    es: Asterisk se ha escalado a %s.
+    de: Asterisk wurde auf %s skaliert.
+  scaledReady:
+    en: All of them are now ready.
+    es: Ahora todas están listas.
+    de: Alle sind jetzt bereit.
+  scaledNotReady:
+    en: They are not all ready yet.  Ask me for the status in a minute.
+    es: Todavía no están todas listas.  Pregúntame por el estado dentro de un minuto.
+    de: Noch sind nicht alle bereit.  Fragen Sie mich in einer Minute nach dem Status.
+  statusFailed:
+    en: Sorry, I could not find out how many Asterisk instances are running
+    es: Lo siento, no he podido averiguar cuántas instancias de Asterisk se están ejecutando
+    de: Entschuldigung, ich konnte nicht herausfinden, wie viele Asterisk-Instanzen laufen
+  status:
+    en: Asterisk is running %s, and %d of them are ready.
+    es: Asterisk está ejecutando %s, y %d de ellas están listas.
+    de: Asterisk läuft mit %s, und %d davon sind bereit.
+  statusHeldUntil:
+    en: Autoscaling is active, but it is held at %s until %s.
+    es: El escalado automático está activo, pero está fijado en %s hasta las %s.
+    de: Die automatische Skalierung ist aktiv, aber bis %[2]s bei %[1]s festgehalten.
+  statusHeld:
+    en: Autoscaling is active, but it is held at %s.
+    es: El escalado automático está activo, pero está fijado en %s.
+    de: Die automatische Skalierung ist aktiv, aber bei %s festgehalten.
+  statusAutoscaled:
+    en: Autoscaling is active, so the count may change on its own between %d and %d instances.
+    es: El escalado automático está activo, así que el número puede cambiar por sí solo entre %d y %d instancias.
+    de: Die automatische Skalierung ist aktiv, daher kann sich die Anzahl von selbst zwischen %d und %d Instanzen ändern.
+  instance:
+    en: 1 instance
+    es: 1 instancia
+    de: 1 Instanz
+  instances:
+    en: "%d instances"
+    es: "%d instancias"
+    de: "%d Instanzen"
+  proxiesUnsupported:
+    en: I cannot scale proxies yet
+    es: Todavía no puedo escalar los proxies
+    de: Ich kann die Proxys noch nicht skalieren
+  alertPrompt:
+    en: Press 1 to acknowledge this alert, or 2 to pass it to the next person on call.
+    es: Pulse 1 para confirmar esta alerta, o 2 para pasarla a la siguiente persona de guardia.
+    de: Drücken Sie 1, um diese Warnung zu bestätigen, oder 2, um sie an die nächste Person in Bereitschaft weiterzugeben.
+  alertAcknowledged:
+    en: Thank you.  The alert has been acknowledged.
+    es: Gracias.  La alerta ha sido confirmada.
+    de: Danke.  Die Warnung wurde bestätigt.
+  alertEscalated:
+    en: The alert will be passed to the next person on call.
+    es: La alerta se pasará a la siguiente persona de guardia.
+    de: Die Warnung wird an die nächste Person in Bereitschaft weitergegeben.
+  alertUnanswered:
+    en: No answer was given.  The alert will be passed to the next person on call.
+    es: No se ha recibido respuesta.  La alerta se pasará a la siguiente persona de guardia.
+    de: Es kam keine Antwort.  Die Warnung wird an die nächste Person in Bereitschaft weitergegeben.
+
+# The commands are recognized by any of their phrases
+phrases:
+  scale:
+    en: [scale]
+    es: [escala, escalar]
+    de: [skalier]
+  asterisk:
+    en: [asterisk, astris, media]
+    es: [asterisk, astris, medios]
+    de: [asterisk, astris, medien]
+  proxy:
+    en: [prox, kamailio]
+    es: [prox, kamailio]
+    de: [prox, kamailio]
+  status:
+    en: [status, how many]
+    es: [estado, cuántas, cuantas]
+    de: [status, wie viele]
+  hello:
+    en: [hello]
+    es: [hola]
+    de: [hallo]
+  bye:
+    en: [bye]
+    es: [adiós, adios]
+    de: [tschüss, wiedersehen]
+  hints:
+    en:
+      - asterisk
+      - asterisks
+      - bye
+      - goodbye
+      - hello
+      - kamailio
+      - kamailios
+      - proxy
+      - proxies
+      - scale
+      - status
+    es:
+      - adiós
+      - asterisk
+      - escala
+      - escalar
+      - estado
+      - hola
+      - kamailio
+      - proxy
+      - proxies
+    de:
+      - asterisk
+      - hallo
+      - kamailio
+      - proxy
+      - proxys
+      - skaliere
+      - status
+      - tschüss
+`
+
+var contentSource *content.Source
+
+func init() {
+	path := os.Getenv("CONTENT")
+	if path == "" {
+		path = defaultContentPath
+	}
+	contentSource = content.NewSource(path, []byte(defaultContent))
+}
+
+// message returns the named message in the given language from the current
+// content pack
+func message(l *lang.Language, name string) string {
+	pack, err := contentSource.Pack()
+	if err != nil {
+		log.Println("using previous content:", err)
+	}
+	return pack.Message(l.Code, name)
+}
+
+// phrases returns the named phrases in the given language from the current
+// content pack
+func phrases(l *lang.Language, name string) []string {
+	pack, err := contentSource.Pack()
+	if err != nil {
+		log.Println("using previous content:", err)
+	}
+	return pack.PhrasesFor(l.Code, name)
+}
+
+// said indicates whether the command contains any of the named phrases of
+// the given language
+func said(l *lang.Language, cmd, name string) bool {
+	cmd = strings.ToLower(cmd)
+	for _, p := range phrases(l, name) {
+		if strings.Contains(cmd, strings.ToLower(p)) {
+			return true
+		}
+	}
+	return false
+}
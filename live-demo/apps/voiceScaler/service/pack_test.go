@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/content"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/lang"
+)
+
+func TestDefaultContentLanguages(t *testing.T) {
+	pack, err := content.Parse([]byte(defaultContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, m := range pack.Messages {
+		for _, l := range lang.All {
+			msg, ok := m[l.Base()]
+			if !ok {
+				t.Errorf("message %s has no %s translation", name, l.Name)
+				continue
+			}
+			if strings.Count(msg, "%") != strings.Count(m["en"], "%") {
+				t.Errorf("message %s in %s does not take the arguments of the English", name, l.Name)
+			}
+		}
+	}
+	for name, p := range pack.Phrases {
+		for _, l := range lang.All {
+			if len(p[l.Base()]) == 0 {
+				t.Errorf("phrases %s have no %s translation", name, l.Name)
+			}
+		}
+	}
+}
+
+func TestSaid(t *testing.T) {
+	tests := []struct {
+		l    *lang.Language
+		cmd  string
+		name string
+		want bool
+	}{
+		{lang.English, "Scale asterisk to 3", "scale", true},
+		{lang.English, "how many are running", "status", true},
+		{lang.English, "hola", "hello", false},
+		{lang.Spanish, "escala asterisk a tres", "scale", true},
+		{lang.Spanish, "Hola", "hello", true},
+		{lang.Spanish, "cuántas hay", "status", true},
+		{lang.German, "Skaliere Asterisk auf drei", "scale", true},
+		{lang.German, "wie viele laufen", "status", true},
+		{lang.German, "hello", "hello", false},
+	}
+	for _, tt := range tests {
+		if got := said(tt.l, tt.cmd, tt.name); got != tt.want {
+			t.Errorf("%s %q %s: got %v, want %v", tt.l.Name, tt.cmd, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/ivr"
+	"github.com/pkg/errors"
+)
+
+// controller sends commands to the ARI app which connected the call, if
+// NATS is configured
+var controller *control.Client
+
+// sendCommand sends a command for the call to the ARI app which connected
+// it.  Calls which were connected directly by the dialplan have no ARI app,
+// so their commands fail.
+func (a *App) sendCommand(ctx context.Context, cmd *control.Command) error {
+	if controller == nil {
+		return errors.New("no control channel is configured")
+	}
+	return controller.Send(ctx, a.id.String(), cmd)
+}
+
+// transfer transfers the caller to the dialplan "extension" (in "context")
+// or to the "endpoint", ending the call with the voice service.  Its outcome
+// is "failed" if the transfer could not be made.
+func (a *App) transfer(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	if args["endpoint"] != "" {
+		// The endpoint must answer before the transfer is made
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, control.TransferTimeout+control.DefaultTimeout)
+		defer cancel()
+	}
+	err := a.sendCommand(ctx, &control.Command{
+		Kind:      control.Transfer,
+		Context:   args["context"],
+		Extension: args["extension"],
+		Endpoint:  args["endpoint"],
+	})
+	if err != nil {
+		log.Printf("failed to transfer call %s: %v", call.ID, err)
+		return "failed", nil
+	}
+	log.Printf("transferred call %s", call.ID)
+	a.transferred = true
+	return "", ivr.ErrHangup
+}
+
+// playSound plays the named Asterisk "sound" to the caller.  Its outcome is
+// "failed" if the sound could not be played.
+func (a *App) playSound(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	return a.command(ctx, call, &control.Command{
+		Kind:  control.Play,
+		Sound: args["sound"],
+	})
+}
+
+// sendDTMF sends the "digits" to the caller.  Its outcome is "failed" if
+// the digits could not be sent.
+func (a *App) sendDTMF(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	return a.command(ctx, call, &control.Command{
+		Kind:   control.DTMF,
+		Digits: args["digits"],
+	})
+}
+
+// record starts recording the call, under the name given by the "name"
+// argument, or stops recording it if the "stop" argument is "true".  Its
+// outcome is "failed" if recording could not be started or stopped.
+func (a *App) record(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	cmd := &control.Command{
+		Kind:      control.StartRecording,
+		Recording: args["name"],
+	}
+	if args["stop"] == "true" {
+		cmd.Kind = control.StopRecording
+	}
+	return a.command(ctx, call, cmd)
+}
+
+// command sends a command which does not end the call, returning the
+// outcome of the action which sent it
+func (a *App) command(ctx context.Context, call *ivr.Call, cmd *control.Command) (string, error) {
+	if err := a.sendCommand(ctx, cmd); err != nil {
+		log.Printf("failed to send %s command for call %s: %v", cmd.Kind, call.ID, err)
+		return "failed", nil
+	}
+	return "", nil
+}
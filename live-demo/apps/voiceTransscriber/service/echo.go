@@ -2,29 +2,30 @@ package main
 
 import (
 	"context"
+	"log"
 
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/ivr"
 	"github.com/pkg/errors"
 )
 
-func (a *App) echoStart(ctx context.Context) (stateFn, error) {
-	if err := speak(ctx, a.c, "go ahead.  say cancel or menu to exit"); err != nil {
-		return nil, errors.Wrap(err, "failed to send message to asterisk")
-	}
-	return a.echo, nil
-}
-
-func (a *App) echo(ctx context.Context) (stateFn, error) {
-	cmd, err := recognizeRequest(ctx, a.c)
+// echo repeats a single utterance of the caller back to them.  Its outcome
+// is "menu" or "hangup" if the caller asked to leave, or "failed" if their
+// speech could not be recognized.
+func (a *App) echo(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	cmd, err := a.recognize(ctx, a.phrases("menu")...)
 	if err != nil {
-		return a.listenFailure, nil
+		log.Println("failed to recognize speech:", err)
+		return "failed", nil
 	}
 
-	if containsAny(cmd, "bye", "hangup", "hang up") {
-		return nil, ErrHangup
+	if containsAny(cmd, a.phrases("hangup")...) {
+		return "hangup", nil
+	}
+	if containsAny(cmd, a.phrases("menu")...) {
+		return "menu", nil
 	}
-	if containsAny(cmd, "cancel", "menu") {
-		return a.rootMenu, nil
+	if err = a.speak(ctx, cmd); err != nil {
+		return "", errors.Wrap(err, "failed to send message to asterisk")
 	}
-	err = speak(ctx, a.c, cmd)
-	return a.echo, err
+	return "", nil
 }
@@ -4,11 +4,18 @@ go 1.12
 
 require (
 	cloud.google.com/go v0.47.0
+	github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg v0.0.0
 	github.com/CyCoreSystems/audiosocket v0.2.0
 	github.com/fatih/color v1.7.0
+	github.com/go-redis/redis v6.15.2+incompatible
 	github.com/gofrs/uuid v3.2.0+incompatible
+	github.com/golang/protobuf v1.3.2
 	github.com/mattn/go-colorable v0.1.4 // indirect
 	github.com/mattn/go-isatty v0.0.10 // indirect
+	github.com/nats-io/nats.go v1.8.1
 	github.com/pkg/errors v0.8.1
 	google.golang.org/genproto v0.0.0-20191009194640-548a555dbc03
+	gopkg.in/yaml.v2 v2.2.4
 )
+
+replace github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg => ../../../pkg
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/ivr"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/lang"
+)
+
+// languages maps dialed numbers to the languages of their calls.  It is
+// loaded from the LANGUAGE_BY_DNIS environment variable, in the form
+// "number=code,number=code".
+var languages lang.Table
+
+func init() {
+	var err error
+	if languages, err = lang.ParseTable(os.Getenv("LANGUAGE_BY_DNIS")); err != nil {
+		log.Println("ignoring invalid LANGUAGE_BY_DNIS:", err)
+	}
+}
+
+// language sets the language of the call from its "language" variable or,
+// failing that, its "dnis" variable.  The messages of the language are set
+// as call variables.  Its outcome is "unknown" if the language could not be
+// determined, unless the "default" argument is "true", in which case the
+// default language is used.
+func (a *App) language(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	l := languages.Resolve(call.Vars["language"], call.Vars["dnis"])
+	if l == nil {
+		if args["default"] != "true" {
+			return "unknown", nil
+		}
+		l = lang.Default()
+	}
+	log.Printf("call %s is in %s", call.ID, l.Code)
+
+	a.lang = l
+	for k, v := range a.content.MessagesFor(l.Code) {
+		call.Vars[k] = v
+	}
+	call.Vars["language"] = l.Code
+	return "", nil
+}
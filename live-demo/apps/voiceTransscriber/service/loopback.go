@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/audio"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/dsp"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/ivr"
+	"github.com/pkg/errors"
+)
+
+// DefaultLoopbackDelay is the delay after which the caller's audio is played
+// back to them in loopback mode
+const DefaultLoopbackDelay = 500 * time.Millisecond
+
+// DefaultLoopbackDuration is the length of a loopback session
+const DefaultLoopbackDuration = 30 * time.Second
+
+// DefaultMarkerInterval is the interval at which a marker tone is injected
+// into the loopback audio to measure round-trip latency
+const DefaultMarkerInterval = 5 * time.Second
+
+// markerFrequency is the frequency of the marker tone.  It is the standard
+// 1004Hz test tone, which is clear of the DTMF frequencies and the 2100Hz
+// echo canceller disabling tone.
+const markerFrequency = 1004
+
+const markerDuration = 100 * time.Millisecond
+
+// markerThreshold is the fraction of the energy of a received frame which
+// must be at the marker frequency for the marker to be detected
+const markerThreshold = 0.7
+
+// markerTimeout is the time after which a marker which has not returned is
+// counted as lost
+const markerTimeout = 3 * time.Second
+
+// loopbackStats describes the media path of a loopback session
+type loopbackStats struct {
+	// rate is the sample rate of the audio
+	rate int
+
+	start time.Time
+	last  time.Time
+
+	// lastDuration is the duration of the audio of the last frame
+	lastDuration time.Duration
+
+	frames int
+	bytes  int64
+
+	// jitter is the interarrival jitter of frames, as estimated by the
+	// method of RFC 3550
+	jitter time.Duration
+
+	markers int
+	rtts    []time.Duration
+}
+
+// arrive records the arrival of a frame of audio
+func (s *loopbackStats) arrive(t time.Time, n int) {
+	if s.frames > 0 {
+		d := t.Sub(s.last) - s.lastDuration
+		if d < 0 {
+			d = -d
+		}
+		s.jitter += (d - s.jitter) / 16
+	} else {
+		s.start = t
+	}
+	s.frames++
+	s.bytes += int64(n)
+	s.last = t
+	s.lastDuration = bytesToDuration(int64(n), s.rate)
+}
+
+// loss returns the fraction of the expected audio which was not received
+func (s *loopbackStats) loss() float64 {
+	if s.frames == 0 {
+		return 0
+	}
+	expected := durationToBytes(s.last.Sub(s.start)+s.lastDuration, s.rate)
+	if expected <= s.bytes {
+		return 0
+	}
+	return 1 - float64(s.bytes)/float64(expected)
+}
+
+// latency returns the mean round-trip latency of the returned markers
+func (s *loopbackStats) latency() time.Duration {
+	if len(s.rtts) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, rtt := range s.rtts {
+		total += rtt
+	}
+	return total / time.Duration(len(s.rtts))
+}
+
+func (s *loopbackStats) String() string {
+	return fmt.Sprintf("%d frames, jitter %s, loss %.1f%%, %d of %d markers returned, latency %s",
+		s.frames, s.jitter, 100*s.loss(), len(s.rtts), s.markers, s.latency())
+}
+
+// loopback plays the caller's audio back to them after a delay, and reports
+// the quality of the media path.  The delay, duration and markerInterval
+// arguments override the defaults.
+func (a *App) loopback(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	delay, err := durationArg(args, "delay", DefaultLoopbackDelay)
+	if err != nil {
+		return "", err
+	}
+	duration, err := durationArg(args, "duration", DefaultLoopbackDuration)
+	if err != nil {
+		return "", err
+	}
+	markerInterval, err := durationArg(args, "markerInterval", DefaultMarkerInterval)
+	if err != nil {
+		return "", err
+	}
+
+	lCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	stats, err := loopback(lCtx, a.c, delay, markerInterval)
+	log.Printf("loopback for call %s: %s", call.ID, stats)
+	if err == ErrHangup {
+		return "", ivr.ErrHangup
+	}
+	if err != nil {
+		return "", err
+	}
+
+	msg := fmt.Sprintf(a.message("loopbackReport"), stats.frames, stats.jitter.Seconds()*1000, 100*stats.loss())
+	if len(stats.rtts) > 0 {
+		msg += "  " + fmt.Sprintf(a.message("loopbackLatency"), stats.latency().Nanoseconds()/int64(time.Millisecond), len(stats.rtts), stats.markers)
+	} else if stats.markers > 0 {
+		msg += "  " + a.message("loopbackNoMarker")
+	}
+	if err = a.speak(ctx, msg); err != nil {
+		return "", errors.Wrap(err, "failed to send message to asterisk")
+	}
+	return "", nil
+}
+
+// loopback writes the audio read from rw back to it after the delay, until
+// the context is closed.  A marker tone is injected at each markerInterval
+// and timed until it is received back.  Received markers are not looped
+// back, so that they do not return again.
+func loopback(ctx context.Context, rw io.ReadWriter, delay, markerInterval time.Duration) (*loopbackStats, error) {
+	type frame struct {
+		due  time.Time
+		data []byte
+	}
+
+	rate := audio.RateOf(rw)
+
+	var (
+		stats = &loopbackStats{rate: rate}
+		queue []frame
+
+		// markerSent is the time at which the outstanding marker was
+		// sent, or zero if there is none
+		markerSent time.Time
+	)
+
+	frames := make(chan []byte, 50)
+	go readSlin(ctx, rw, frames, rate)
+
+	marker := dsp.Bytes(dsp.Tone(markerFrequency, markerDuration, 0.5))
+	markerTicker := time.NewTicker(markerInterval)
+	defer markerTicker.Stop()
+
+	send := time.NewTimer(delay)
+	send.Stop()
+	defer send.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return stats, nil
+		case data, ok := <-frames:
+			if !ok {
+				if ctx.Err() != nil {
+					return stats, nil
+				}
+				return stats, ErrHangup
+			}
+			now := time.Now()
+			stats.arrive(now, len(data))
+
+			if !markerSent.IsZero() && now.Sub(markerSent) > markerTimeout {
+				log.Println("loopback marker lost")
+				markerSent = time.Time{}
+			}
+			if dsp.ToneRatio(dsp.Resample(dsp.Samples(data), rate, dsp.SampleRate), markerFrequency) > markerThreshold {
+				if !markerSent.IsZero() {
+					stats.rtts = append(stats.rtts, now.Sub(markerSent))
+					markerSent = time.Time{}
+				}
+				continue
+			}
+
+			queue = append(queue, frame{due: now.Add(delay), data: data})
+			if len(queue) == 1 {
+				send.Reset(delay)
+			}
+		case <-send.C:
+			now := time.Now()
+			for len(queue) > 0 && !queue[0].due.After(now) {
+				if _, err := rw.Write(audio.Message(rate, queue[0].data)); err != nil {
+					return stats, errors.Wrap(err, "failed to write loopback audio")
+				}
+				queue = queue[1:]
+			}
+			if len(queue) > 0 {
+				send.Reset(queue[0].due.Sub(now))
+			}
+		case <-markerTicker.C:
+			if !markerSent.IsZero() {
+				continue
+			}
+			markerSent = time.Now()
+			stats.markers++
+			if err := sendAudio(rw, dsp.SampleRate, marker); err != nil {
+				return stats, errors.Wrap(err, "failed to write loopback marker")
+			}
+		}
+	}
+}
+
+// durationArg parses the named argument as a duration, returning the
+// default if it is not set
+func durationArg(args map[string]string, name string, def time.Duration) (time.Duration, error) {
+	if args[name] == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(args[name])
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s", name)
+	}
+	if d < 0 {
+		return 0, errors.Errorf("%s must not be negative", name)
+	}
+	return d, nil
+}
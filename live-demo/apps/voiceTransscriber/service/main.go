@@ -2,41 +2,78 @@ package main
 
 import (
 	"context"
+	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/audio"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/caption"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/control"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/keypad"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/lang"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/load"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/metadata"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/rtp"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/session"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/synth"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/transcript"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/tz"
 	"github.com/CyCoreSystems/audiosocket"
+	nats "github.com/nats-io/nats.go"
 
 	speech "cloud.google.com/go/speech/apiv1"
 	texttospeech "cloud.google.com/go/texttospeech/apiv1"
 	"github.com/fatih/color"
-	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 )
 
 // MaxCallDuration is the maximum amount of time to allow a call to be up before it is terminated.
+// Continuous transcription extends it to MaxTranscriptionDuration.
 const MaxCallDuration = 5 * time.Minute
 
+// PartingDuration is the maximum amount of time to allow for the parting
+// messages of a call
+const PartingDuration = 10 * time.Second
+
+// MetadataWait is the maximum amount of time to wait for the metadata of a
+// call to be published by its front-end
+const MetadataWait = 2 * time.Second
+
+// InterDigitTimeout is the maximum time to wait for each further digit once
+// a caller has started to enter digits
+const InterDigitTimeout = 3 * time.Second
+
 // MaxRecognitionDuration is the maximum amount of time to allow for a single voice recognition session to complete
 const MaxRecognitionDuration = time.Minute
 
 const listenAddr = ":8080"
-const languageCode = "en-US"
 
-// slinChunkSize is the number of bytes which should be sent per Slin
-// audiosocket message.  Larger data will be chunked into this size for
-// transmission of the AudioSocket.
-//
-// This is based on 8kHz, 20ms, 16-bit signed linear.
-const slinChunkSize = 320 // 8000Hz * 20ms * 2 bytes
+// httpAddr is the address of the HTTP server, which serves captions, the
+// list of calls and the transcripts of transcribed calls
+const httpAddr = ":8081"
 
 // ErrHangup indicates that the call should be terminated or has been terminated
 var ErrHangup = errors.New("Hangup")
 
+// defaultLexiconPath is the location of the pronunciation lexicon, which is
+// normally mounted from a ConfigMap.  It may be overridden by the LEXICON
+// environment variable.
+const defaultLexiconPath = "/etc/voice-transscriber/lexicon.yaml"
+
 var recog *speech.Client
 var tts *texttospeech.Client
+var synthesizer *synth.Synthesizer
+var zones *tz.Resolver
+var callMetadata metadata.Store
+var activeCalls load.Counter
+
+// sampleRate is the sample rate expected of RTP streams until their
+// front-end or their audio says otherwise.  AudioSocket calls start at 8kHz.
+var sampleRate = audio.DefaultRate
 var googleCreds = "/var/secrets/google/google.json"
 
 func main() {
@@ -56,6 +93,46 @@ func main() {
 		log.Fatalln("failed to connect to Google Text-to-Speech API service:", err)
 	}
 	defer tts.Close()
+	if synthesizer, err = synth.FromEnv(defaultLexiconPath); err != nil {
+		log.Fatalln("failed to configure speech synthesis:", err)
+	}
+	if zones, err = tz.FromEnv(); err != nil {
+		log.Fatalln("failed to load time zones:", err)
+	}
+	if sampleRate, err = audio.RateFromEnv(); err != nil {
+		log.Fatalln("failed to configure sample rate:", err)
+	}
+	store := metadata.NewRedisStoreFromEnv()
+	defer store.Close() // nolint: errcheck
+	callMetadata = store
+
+	sessions = session.FromEnv()
+	if c, ok := sessions.(io.Closer); ok {
+		defer c.Close() // nolint: errcheck
+	}
+
+	transcripts := transcript.NewRedisStore(metadata.RedisAddr())
+	defer transcripts.Close() // nolint: errcheck
+
+	hub := caption.NewHub()
+	captions = append(captions, hub, transcripts)
+	if uri := os.Getenv("NATS_URI"); uri != "" {
+		nc, err := nats.Connect(uri)
+		if err != nil {
+			log.Fatalln("failed to connect to NATS:", err)
+		}
+		defer nc.Close()
+		captions = append(captions, caption.NewNATSPublisher(nc))
+		controller = control.NewClient(nc)
+		go activeCalls.Run(ctx, nc, serviceName)
+	}
+
+	http.Handle("/captions/", hub)
+	http.Handle("/calls/", &session.Handler{Store: sessions})
+	http.Handle("/transcripts/", &transcript.Handler{Store: transcripts})
+	go func() {
+		log.Fatalln("HTTP server failed:", http.ListenAndServe(httpAddr, nil))
+	}()
 
 	if err = Listen(ctx); err != nil {
 		log.Fatalln("listen failure:", err)
@@ -63,15 +140,44 @@ func main() {
 	log.Println("exiting")
 }
 
-// Listen listens for and responds to Audiosocket connections
+// Listen listens for and responds to Audiosocket connections, and to RTP
+// streams from ExternalMedia channels on the same port
 func Listen(ctx context.Context) error {
 	l, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		return errors.Wrapf(err, "failed to bind listener to socket %s", listenAddr)
 	}
 
+	registry := rtp.NewRegistry(metadata.RedisAddr())
+	defer registry.Close() // nolint: errcheck
+
+	rl, err := rtp.Listen(listenAddr, registry)
+	if err != nil {
+		return err
+	}
+	defer rl.Close() // nolint: errcheck
+
+	// RTP cannot signal a hangup, so have the ARI app hang up the channel
+	if controller != nil {
+		rl.OnHangup = func(id string) {
+			if err := controller.Send(context.Background(), id, &control.Command{Kind: control.Hangup}); err != nil {
+				log.Printf("failed to hang up RTP call %s: %v", id, err)
+			}
+		}
+	}
+	go accept(ctx, rl)
+
+	accept(ctx, l)
+	return nil
+}
+
+// accept handles the connections of the listener until it is closed
+func accept(ctx context.Context, l net.Listener) {
 	for {
 		conn, err := l.Accept()
+		if err == rtp.ErrClosed {
+			return
+		}
 		if err != nil {
 			log.Println("failed to accept new connection:", err)
 			continue
@@ -84,33 +190,91 @@ func Listen(ctx context.Context) error {
 // Handle processes a call
 func Handle(pCtx context.Context, c net.Conn) {
 	var err error
-	var id uuid.UUID
 
-	ctx, cancel := context.WithTimeout(pCtx, MaxCallDuration)
+	ctx, cancel := context.WithCancel(pCtx)
+	defer activeCalls.Start()()
+
+	ac := audio.NewConn(c, rtp.InitialRate(c, sampleRate))
+	a := &App{
+		c:       keypad.New(ac),
+		lang:    lang.Default(),
+		content: loadContent(),
+		limit:   newCallLimit(MaxCallDuration, cancel),
+	}
 
 	defer func() {
-		color.Magenta("ending call %s", id.String())
+		color.Magenta("ending call %s", a.id.String())
 
-		// Tell caller good-bye
-		speak(ctx, c, partingMessage) // nolint: errcheck
+		// The call context may have expired, so allow the parting
+		// messages a little longer.
+		sCtx, sCancel := context.WithTimeout(pCtx, PartingDuration)
+		defer sCancel()
+
+		// Tell caller good-bye, unless they have been transferred or
+		// cannot hear us
+		if !a.transferred && !a.passive {
+			if a.limit.Expired() {
+				a.speak(sCtx, a.message("timeout")) // nolint: errcheck
+			}
+			a.speak(sCtx, a.message("parting")) // nolint: errcheck
+		}
 
 		// Tell AudioSocket to shut down, if it is still up
 		c.Write(audiosocket.HangupMessage()) // nolint: errcheck
 
+		a.endSession()
+
+		a.limit.Stop()
 		cancel()
 	}()
 
-	id, err = getCallID(c)
+	a.id, err = getCallID(c)
 	if err != nil {
 		log.Println("failed to get call ID:", err)
 		return
 	}
-	color.Magenta("processing call %s", id.String())
+	color.Magenta("processing call %s", a.id.String())
 
-	a := &App{
-		c:  c,
-		id: id,
+	a.meta, err = metadata.Lookup(ctx, callMetadata, a.id.String(), MetadataWait)
+	if err != nil {
+		log.Printf("no metadata for call %s: %v", a.id.String(), err)
+	} else {
+		log.Printf("call %s is from %q to %q on channel %s of %s", a.id.String(), a.meta.CallerID, a.meta.DNIS, a.meta.Channel, a.meta.Node)
+		ac.SetRate(a.meta.SampleRate)
+	}
+
+	// Existing calls snooped on by the ARI app are only transcribed
+	if a.meta != nil && a.meta.Mode == metadata.ModeTranscribe {
+		a.passive = true
+		if err := a.transcribeChannel(pCtx); err != nil && err != ErrHangup {
+			log.Printf("failed to transcribe channel %s: %v", a.meta.Channel, err)
+		}
+		return
+	}
+
+	a.session = a.loadSession(ctx)
+
+	// Take keys relayed by the ARI app, if there is one
+	if controller != nil {
+		sub, err := controller.Events(a.id.String(), func(e *control.Event) {
+			if e.Kind == control.EventDTMF && e.Digit != "" {
+				a.c.Relay(keypad.SourceARI, rune(e.Digit[0]))
+			}
+		})
+		if err != nil {
+			log.Println("failed to subscribe to call events:", err)
+		} else {
+			defer sub.Unsubscribe() // nolint: errcheck
+		}
+	}
+
+	// Give the reason for the call first, as for outbound calls
+	if a.meta != nil && a.meta.Message != "" {
+		if err := a.speak(ctx, a.meta.Message); err != nil {
+			log.Println("failed to speak call message:", err)
+		}
 	}
+
 	if err := a.Run(ctx); err != nil {
 		if err == ErrHangup {
 			return
@@ -122,3 +286,38 @@ func Handle(pCtx context.Context, c net.Conn) {
 	}
 	return
 }
+
+// callLimit ends a call once its time is up
+type callLimit struct {
+	t       *time.Timer
+	expired int32
+}
+
+// newCallLimit returns a callLimit which cancels the call after the given
+// time
+func newCallLimit(d time.Duration, cancel context.CancelFunc) *callLimit {
+	l := new(callLimit)
+	l.t = time.AfterFunc(d, func() {
+		atomic.StoreInt32(&l.expired, 1)
+		cancel()
+	})
+	return l
+}
+
+// Extend gives the call the given time from now, unless it has already
+// expired
+func (l *callLimit) Extend(d time.Duration) {
+	if l.t.Stop() {
+		l.t.Reset(d)
+	}
+}
+
+// Expired indicates whether the call was ended by its limit
+func (l *callLimit) Expired() bool {
+	return atomic.LoadInt32(&l.expired) == 1
+}
+
+// Stop stops the limit, once the call has ended
+func (l *callLimit) Stop() {
+	l.t.Stop()
+}
@@ -2,70 +2,373 @@ package main
 
 import (
 	"context"
-	"net"
+	"log"
+	"os"
 
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/content"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/ivr"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/keypad"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/lang"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/metadata"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/session"
 	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 )
 
-const greetingMessage = "Hello. Speak, and I will listen to you."
-const partingMessage = "Good bye. Thanks for calling."
-const timeoutMessage = "Sorry, your time is up."
+// defaultMenuPath is the location of the IVR definition, which is normally
+// mounted from a ConfigMap.  It may be overridden by the MENU_DEFINITION
+// environment variable.
+const defaultMenuPath = "/etc/voice-transscriber/menu.yaml"
 
-var keyPhrases = []string{
-	"bye",
-	"goodbye",
-	"hangup",
-}
+// defaultMenu is the IVR definition used when none is mounted.
+//
+// The language of the call is taken from the call's "language" variable or
+// its dialed number, if either is known; otherwise the caller is asked to
+// choose.  The messages of the chosen language are set as call variables.
+const defaultMenu = `
+start: language
+nodes:
+  language:
+    action:
+      name: language
+      outcomes:
+        unknown: languageMenu
+    next: root
+
+  languageMenu:
+    prompts:
+      - text: For English, say English.
+        language: en-US
+      - text: Para español, diga español.
+        language: es-ES
+      - text: Für Deutsch, sagen Sie Deutsch.
+        language: de-DE
+    input:
+      var: language
+      intents:
+        en-US: [english, inglés, englisch]
+        es-ES: [español, espanol, spanish, spanisch]
+        de-DE: [deutsch, german, alemán]
+    timeout: 10s
+    retries: 2
+    transitions:
+      - intent: en-US
+        next: languageChanged
+      - intent: es-ES
+        next: languageChanged
+      - intent: de-DE
+        next: languageChanged
+    exhausted: languageDefault
+    error: languageDefault
+
+  languageChanged:
+    action:
+      name: language
+      outcomes:
+        unknown: languageDefault
+    prompts:
+      - text: "{{.languageChanged}}"
+    next: root
+
+  languageDefault:
+    action:
+      name: language
+      args:
+        default: "true"
+    next: root
+
+  root:
+    prompts:
+      - text: "{{.greeting}}"
+    input:
+      var: command
+      intents:
+        time: [time, hora, uhrzeit, zeit]
+        joke: [laugh, joke, chiste, broma, witz]
+        echo: [echo, eco]
+        loopback: [loopback, loop back, bucle, schleife]
+        transcribe: [transcribe, caption, dictate, transcribir, dictar, transkribieren, diktieren]
+        language: [language, idioma, sprache]
+        human: [human, operator, agent, person, humano, operador, persona, mensch, mitarbeiter]
+        hangup: [bye, hangup, hang up, adiós, adios, colgar, tschüss, auflegen]
+    timeout: 1m
+    transitions:
+      - intent: time
+        next: time
+      - intent: joke
+        next: joke
+      - intent: loopback
+        next: loopback
+      - intent: echo
+        next: echo
+      - intent: transcribe
+        next: transcribe
+      - intent: language
+        next: languageMenu
+      - intent: human
+        next: human
+      - intent: hangup
+        next: hangup
+      - dtmf: "1"
+        next: time
+      - dtmf: "2"
+        next: joke
+      - dtmf: "3"
+        next: echo
+      - dtmf: "4"
+        next: loopback
+      - dtmf: "5"
+        next: transcribe
+      - dtmf: "9"
+        next: languageMenu
+      - dtmf: "0"
+        next: human
+    error: listenFailure
+
+  listenFailure:
+    prompts:
+      - text: "{{.listenFailure}}"
+    next: root
+
+  time:
+    action:
+      name: time
+    next: root
+
+  joke:
+    action:
+      name: joke
+    next: root
+
+  echo:
+    prompts:
+      - text: "{{.echo}}"
+    next: echoLoop
+
+  echoLoop:
+    action:
+      name: echo
+      outcomes:
+        menu: root
+        hangup: hangup
+        failed: listenFailure
+    next: echoLoop
+
+  loopback:
+    prompts:
+      - text: "{{.loopback}}"
+    next: loopbackRun
+
+  loopbackRun:
+    action:
+      name: loopback
+      args:
+        delay: 500ms
+        duration: 30s
+    next: root
+
+  transcribe:
+    prompts:
+      - text: "{{.transcribe}}"
+    next: transcribeLoop
+
+  transcribeLoop:
+    action:
+      name: transcribe
+    next: hangup
 
-type stateFn func(ctx context.Context) (stateFn, error)
+  human:
+    prompts:
+      - text: "{{.transfer}}"
+    next: humanTransfer
+
+  humanTransfer:
+    action:
+      name: transfer
+      args:
+        context: inbound
+        extension: operator
+      outcomes:
+        failed: transferFailed
+
+  transferFailed:
+    prompts:
+      - text: "{{.transferFailed}}"
+    next: root
+
+  hangup:
+    action:
+      name: hangup
+`
+
+var menu *ivr.Source
+
+func init() {
+	path := os.Getenv("MENU_DEFINITION")
+	if path == "" {
+		path = defaultMenuPath
+	}
+	menu = ivr.NewSource(path, []byte(defaultMenu))
+}
 
 // App is the base state machine application for the call
 type App struct {
-	c  net.Conn
+	c  *keypad.Conn
 	id uuid.UUID
+
+	// lang is the language of the call
+	lang *lang.Language
+
+	// meta is the metadata published for the call by its front-end, if any
+	meta *metadata.Metadata
+
+	// session is the stored state of the call
+	session *session.Session
+
+	// transferred indicates that the caller has been transferred away from
+	// the voice service
+	transferred bool
+
+	// passive indicates that the call is an existing call which is only
+	// transcribed, and whose parties cannot hear the voice service
+	passive bool
+
+	// content is the content pack for the call
+	content *content.Pack
+
+	jokes content.Jokester
+
+	// limit ends the call once its time is up
+	limit *callLimit
 }
 
-// Run executes the application set state machine
-func (a *App) Run(ctx context.Context) (err error) {
-	for next := a.rootMenu; next != nil; {
-		if ctx.Err() != nil {
-			return nil
-		}
-		if next, err = next(ctx); err != nil {
-			return err
-		}
+// Run executes the IVR definition for the call
+func (a *App) Run(ctx context.Context) error {
+	def, err := menu.Definition()
+	if def == nil {
+		return errors.Wrap(err, "failed to load menu")
 	}
-	return ErrHangup
-}
+	if err != nil {
+		log.Println("using previous menu:", err)
+	}
+
+	e := ivr.NewEngine(def, a)
+	e.Register("time", a.tellTime)
+	e.Register("joke", a.tellJoke)
+	e.Register("echo", a.echo)
+	e.Register("loopback", a.loopback)
+	e.Register("transcribe", a.transcribe)
+	e.Register("language", a.language)
+	e.Register("transfer", a.transfer)
+	e.Register("play", a.playSound)
+	e.Register("dtmf", a.sendDTMF)
+	e.Register("record", a.record)
+	e.Observe(a.observe)
 
-func (a *App) rootMenu(ctx context.Context) (stateFn, error) {
-	if err := speak(ctx, a.c, greetingMessage); err != nil {
-		return nil, errors.Wrap(err, "failed to send greeting to asterisk")
+	// A resumed call continues where it left off, with the variables it had
+	call := &ivr.Call{
+		ID:   a.id.String(),
+		Vars: a.session.Slots,
+		Node: a.session.Node,
+	}
+	if call.Node == "" && a.meta != nil {
+		call.Vars = a.meta.CallVars()
 	}
 
-	cmd, err := recognizeRequest(ctx, a.c)
-	if err != nil {
-		return a.listenFailure, nil
+	err = e.Run(ctx, call)
+	if err == nil || err == ivr.ErrHangup {
+		return ErrHangup
 	}
+	return err
+}
 
-	if containsAny(cmd, "time") {
-		return a.tellTime, nil
+// Play implements ivr.Channel
+func (a *App) Play(ctx context.Context, prompts []ivr.Prompt) error {
+	for _, p := range prompts {
+		if p.Text == "" {
+			log.Println("ignoring non-text prompt", p.Sound)
+			continue
+		}
+		code := p.Language
+		if code == "" {
+			code = a.lang.Code
+		}
+		if err := speak(ctx, a.c, code, p.Text); err != nil {
+			return errors.Wrap(err, "failed to send prompt to asterisk")
+		}
 	}
-	if containsAny(cmd, "laugh", "joke") {
-		return a.tellJoke, nil
+	return nil
+}
+
+// Collect implements ivr.Channel.  The caller may answer by speaking or by
+// pressing keys; the first key pressed stops recognition.
+func (a *App) Collect(ctx context.Context, prompts []ivr.Prompt, c *ivr.Collector) (*ivr.Response, error) {
+	// Keys pressed before the prompts are not an answer to them
+	a.c.Clear()
+
+	if err := a.Play(ctx, prompts); err != nil {
+		return nil, err
 	}
-	if containsAny(cmd, "echo") {
-		return a.echoStart, nil
+
+	rCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	type result struct {
+		speech string
+		err    error
 	}
-	if containsAny(cmd, "bye", "hangup", "hang up") {
-		return nil, ErrHangup
+	results := make(chan result, 1)
+	go func() {
+		cmd, err := a.recognize(rCtx, c.Phrases...)
+		results <- result{cmd, err}
+	}()
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			if ctx.Err() == nil && rCtx.Err() != nil {
+				// Input timed out
+				return new(ivr.Response), nil
+			}
+			return nil, r.err
+		}
+		return &ivr.Response{Speech: r.speech}, nil
+	case d := <-a.c.Digits():
+		cancel()
+		<-results
+
+		digits, err := a.c.Collect(ctx, string(d), InterDigitTimeout, func(pat string) bool {
+			_, m := c.MatchDigits(pat)
+			return m != ivr.DigitsIncomplete
+		})
+		if err == keypad.ErrTimeout {
+			return new(ivr.Response), nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to collect digits")
+		}
+		digits, _ = c.MatchDigits(digits)
+		return &ivr.Response{DTMF: digits}, nil
 	}
+}
+
+// message returns the named message in the language of the call
+func (a *App) message(name string) string {
+	return a.content.Message(a.lang.Code, name)
+}
+
+// phrases returns the named phrases in the language of the call
+func (a *App) phrases(name string) []string {
+	return a.content.PhrasesFor(a.lang.Code, name)
+}
 
-	return a.rootMenu, nil
+// recognize recognizes the caller's speech in the language of the call.  The
+// phrases with which the caller may hang up are always included in the hints.
+func (a *App) recognize(ctx context.Context, hints ...string) (string, error) {
+	return recognizeRequest(ctx, a.c, a.lang.Code, append(a.phrases("hangup"), hints...)...)
 }
 
-func (a *App) listenFailure(ctx context.Context) (stateFn, error) {
-	err := speak(ctx, a.c, "Sorry, I failed to listen")
-	return a.rootMenu, err
+// speak says the message to the caller in the language of the call
+func (a *App) speak(ctx context.Context, msg string) error {
+	return speak(ctx, a.c, a.lang.Code, msg)
 }
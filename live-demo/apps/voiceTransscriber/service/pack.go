@@ -0,0 +1,146 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/content"
+)
+
+// defaultContentPath is the directory of the content packs, which is
+// normally mounted from a ConfigMap.  It may be overridden by the CONTENT
+// environment variable.
+const defaultContentPath = "/etc/voice-transscriber-content"
+
+// defaultContent is the built-in content pack, over which any mounted
+// content packs are merged
+const defaultContent = `
+messages:
+  greeting:
+    en-US: 'Hello. Speak, and I will listen to you.'
+    es-ES: 'Hola. Hable, y le escucharé.'
+    de-DE: 'Hallo. Sprechen Sie, und ich höre Ihnen zu.'
+  parting:
+    en-US: 'Good bye. Thanks for calling.'
+    es-ES: 'Adiós. Gracias por llamar.'
+    de-DE: 'Auf Wiederhören. Danke für Ihren Anruf.'
+  timeout:
+    en-US: 'Sorry, your time is up.'
+    es-ES: 'Lo siento, se ha acabado su tiempo.'
+    de-DE: 'Entschuldigung, Ihre Zeit ist abgelaufen.'
+  listenFailure:
+    en-US: 'Sorry, I failed to listen'
+    es-ES: 'Lo siento, no he podido escucharle'
+    de-DE: 'Entschuldigung, ich konnte Sie nicht verstehen'
+  echo:
+    en-US: 'go ahead.  say cancel or menu to exit'
+    es-ES: 'adelante.  diga cancelar o menú para salir'
+    de-DE: 'legen Sie los.  sagen Sie abbrechen oder menü zum Beenden'
+  transcribe:
+    en-US: 'I am listening.  Everything you say from now on will be transcribed.'
+    es-ES: 'Le escucho.  Todo lo que diga a partir de ahora será transcrito.'
+    de-DE: 'Ich höre zu.  Alles, was Sie ab jetzt sagen, wird transkribiert.'
+  languageChanged:
+    en-US: 'I will speak English.'
+    es-ES: 'Hablaré español.'
+    de-DE: 'Ich spreche Deutsch.'
+  time:
+    en-US: 'It is %s.'
+    es-ES: 'Son las %s.'
+    de-DE: 'Es ist %s.'
+  timeIn:
+    en-US: 'In %s, it is %s.'
+    es-ES: 'En %s, son las %s.'
+    de-DE: 'In %s ist es %s.'
+  loopback:
+    en-US: 'Loopback mode.  I will play your audio back to you for thirty seconds.'
+    es-ES: 'Modo de bucle.  Le devolveré su audio durante treinta segundos.'
+    de-DE: 'Schleifenmodus.  Ich spiele Ihnen Ihr Audio dreißig Sekunden lang zurück.'
+  loopbackReport:
+    en-US: 'I received %d frames, with %.0f milliseconds of jitter and %.1f percent loss.'
+    es-ES: 'He recibido %d tramas, con %.0f milisegundos de variación y %.1f por ciento de pérdida.'
+    de-DE: 'Ich habe %d Rahmen empfangen, mit %.0f Millisekunden Jitter und %.1f Prozent Verlust.'
+  loopbackLatency:
+    en-US: 'The round trip latency was %d milliseconds, over %d of %d markers.'
+    es-ES: 'La latencia de ida y vuelta fue de %d milisegundos, en %d de %d marcas.'
+    de-DE: 'Die Umlaufzeit betrug %d Millisekunden, bei %d von %d Markierungen.'
+  transfer:
+    en-US: 'Connecting you to a human.  Please hold.'
+    es-ES: 'Le paso con una persona.  Espere, por favor.'
+    de-DE: 'Ich verbinde Sie mit einem Menschen.  Bitte warten Sie.'
+  transferFailed:
+    en-US: 'Sorry, nobody is available to take your call.'
+    es-ES: 'Lo siento, no hay nadie disponible para atender su llamada.'
+    de-DE: 'Entschuldigung, es ist niemand erreichbar.'
+  loopbackNoMarker:
+    en-US: 'None of the latency markers returned.'
+    es-ES: 'Ninguna de las marcas de latencia ha vuelto.'
+    de-DE: 'Keine der Latenzmarkierungen ist zurückgekommen.'
+
+jokes:
+  en:
+    - 'I asked God for a bike, but I know God doesn''t work that way so I stole a bike and asked for forgiveness.'
+    - 'I hate Russian dolls, they''re so full of themselves.'
+    - 'Throwing acid is wrong, in some people''s eyes.'
+    - 'The first time I got a universal remote control I thought to myself, "This changes everything".'
+    - 'Say what you want about deaf people...'
+    - 'I''ve spent the last four years looking for my ex-girlfriend''s killer, but no-one will do it.'
+    - 'I refused to believe my road worker father was stealing from his job, but when I got home all the signs were there.'
+    - 'I recently decided to sell my vacuum cleaner as all it was doing was gathering dust.'
+    - 'You can never lose a homing pigeon - if your homing pigeon doesn''t come back what you''ve lost is a pigeon.'
+    - 'My girlfriend told me to go out and get something that makes her look sexy... so I got drunk.'
+    - 'Don''t you hate it when someone answers their own questions? I do.'
+    - 'As I watched the dog chasing his tail I thought "Dogs are easily amused", then I realized I was watching the dog chasing his tail.'
+    - 'PMS jokes are not funny or appropriate. Period!'
+    - 'Gambling addiction hotlines would do so much better if every fifth caller was a winner.'
+    - 'Where there''s a will, there''s a relative.'
+    - 'Hedgehogs, eh? Why can''t they just share the hedge?'
+    - 'Just because nobody complains doesn''t mean all parachutes are perfect.'
+    - 'To the man on crutches, dressed in camouflage, who stole my wallet - you can hide, but you can''t run.'
+    - 'Velcro - what a rip-off!'
+    - 'My friend keeps trying to convince me that he''s a compulsive liar but I don''t believe him.'
+    - 'It’s always hard to explain puns to kleptomaniacs because they’re always taking things literally.'
+  es:
+    - '¿Qué le dice una iguana a su hermana gemela? Somos iguanitas.'
+    - '¿Cuál es el café más peligroso del mundo? El ex-preso.'
+    - '¿Qué hace una abeja en el gimnasio? Zum-ba.'
+    - 'Me han dicho que soy muy indeciso. Bueno, no sé.'
+    - '¿Por qué el libro de matemáticas está triste? Porque tiene demasiados problemas.'
+    - '¿Cómo se dice pañuelo en japonés? Saka-moko.'
+  de:
+    - 'Treffen sich zwei Jäger. Beide tot.'
+    - 'Was ist orange und läuft durch den Wald? Eine Wanderine.'
+    - 'Was sitzt auf einem Baum und winkt? Ein Huhu.'
+    - 'Warum können Geister so schlecht lügen? Weil man durch sie hindurchsehen kann.'
+    - 'Wie nennt man einen Bumerang, der nicht zurückkommt? Stock.'
+    - 'Was macht ein Clown im Büro? Faxen.'
+
+phrases:
+  hangup:
+    en: [bye, goodbye, hangup, hang up]
+    es: [adiós, adios, colgar]
+    de: [tschüss, auf wiederhören, auflegen]
+  menu:
+    en: [cancel, menu]
+    es: [cancelar, menú, menu]
+    de: [abbrechen, menü, menu]
+`
+
+var contentSource *content.Source
+
+func init() {
+	path := os.Getenv("CONTENT")
+	if path == "" {
+		path = defaultContentPath
+	}
+	contentSource = content.NewSource(path, []byte(defaultContent))
+}
+
+// loadContent returns the current content pack
+func loadContent() *content.Pack {
+	pack, err := contentSource.Pack()
+	if err != nil {
+		log.Println("using previous content:", err)
+	}
+	return pack
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/ivr"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/lang"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/session"
+)
+
+// serviceName identifies this service in the sessions it stores
+const serviceName = "voice-transscriber"
+
+var sessions session.Store
+
+// loadSession returns the stored session of the call, taking it over from
+// the replica which was handling it, or a new session if there is none.
+func (a *App) loadSession(ctx context.Context) *session.Session {
+	s, err := sessions.Load(ctx, a.id.String())
+	if err != nil {
+		if err != session.ErrNotFound {
+			log.Printf("failed to load session of call %s: %v", a.id.String(), err)
+		}
+		return session.New(a.id.String(), serviceName)
+	}
+	if s.State != session.StateActive {
+		return session.New(a.id.String(), serviceName)
+	}
+
+	log.Printf("resuming call %s from %s at %s", s.ID, s.Owner, s.Node)
+	s.Owner = session.Hostname()
+	if l := lang.Lookup(s.Language); l != nil {
+		a.lang = l
+	}
+	return s
+}
+
+// observe records the progress of the call in its session
+func (a *App) observe(ctx context.Context, call *ivr.Call, resp *ivr.Response) {
+	s := a.session
+	s.Node = call.Node
+	s.Slots = call.Vars
+	s.Language = a.lang.Code
+	if resp != nil {
+		s.AddTurn(call.Node, resp.Speech, resp.DTMF)
+	}
+	if err := sessions.Save(ctx, s); err != nil {
+		log.Printf("failed to save session of call %s: %v", s.ID, err)
+	}
+}
+
+// endSession removes the session of the call
+func (a *App) endSession() {
+	if a.session == nil {
+		return
+	}
+	if err := sessions.Delete(context.Background(), a.session.ID); err != nil {
+		log.Printf("failed to delete session of call %s: %v", a.session.ID, err)
+	}
+}
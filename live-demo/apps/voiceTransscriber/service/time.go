@@ -2,14 +2,31 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"time"
 
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/ivr"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/synth"
 	"github.com/pkg/errors"
 )
 
-func (a *App) tellTime(ctx context.Context) (stateFn, error) {
-	if err := speak(ctx, a.c, time.Now().Format("15 04")); err != nil {
-		return nil, errors.Wrap(err, "failed to send message to asterisk")
+// tellTime tells the caller the time in the place they asked about (e.g.
+// "what time is it in Tokyo"), or else in their own zone, as determined by
+// their caller ID or the default zone.
+func (a *App) tellTime(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	place, loc := zones.Resolve(call.Vars["callerid"], call.Vars[ivr.SpeechVar])
+	log.Printf("telling time in %s to call %s", loc, call.ID)
+
+	now := synth.Time(time.Now().In(loc), a.lang.Clock24)
+
+	msg := fmt.Sprintf(synth.Text(a.message("time")), now)
+	if place != "" {
+		msg = fmt.Sprintf(synth.Text(a.message("timeIn")), synth.Text(place), now)
+	}
+
+	if err := a.speak(ctx, synth.SSML(msg)); err != nil {
+		return "", errors.Wrap(err, "failed to send message to asterisk")
 	}
-	return a.rootMenu, nil
+	return "", nil
 }
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/audio"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/caption"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/ivr"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/lang"
+	"github.com/CyCoreSystems/audiosocket"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+	speechv1 "google.golang.org/genproto/googleapis/cloud/speech/v1"
+)
+
+// StreamRotationInterval is the age at which a continuous transcription
+// stream is replaced by a new one, at the next final result.  Google limits
+// streaming recognition to about 60 seconds of audio per stream.
+const StreamRotationInterval = 45 * time.Second
+
+// MaxStreamDuration is the amount of audio, including any replayed into it,
+// after which a continuous transcription stream is replaced by a new one,
+// even if no final result has been received.
+const MaxStreamDuration = 55 * time.Second
+
+// MaxStreamOverlap is the maximum amount of audio which is not yet covered by
+// a final result which is replayed into a new stream when a stream is
+// rotated.
+const MaxStreamOverlap = 10 * time.Second
+
+// MaxTranscriptionDuration is the maximum length of the transcription of an
+// existing call
+const MaxTranscriptionDuration = 4 * time.Hour
+
+// captions is the publisher to which all continuous transcriptions are sent
+var captions caption.Publishers
+
+// audioChunk is a single message of audio, with its position in the call
+type audioChunk struct {
+	offset int64
+	data   []byte
+}
+
+// recognitionStream is a single streaming recognition session within a
+// continuous transcription
+type recognitionStream struct {
+	svc    speechv1.Speech_StreamingRecognizeClient
+	cancel context.CancelFunc
+
+	// start is the offset of the first byte of audio sent to the stream
+	start int64
+
+	// rate is the sample rate of the audio sent to the stream
+	rate int
+
+	// replayed is the length of the audio replayed into the stream from
+	// its predecessor, which counts against the limit of the stream
+	replayed time.Duration
+
+	opened  time.Time
+	results chan *speechv1.StreamingRecognizeResponse
+}
+
+func bytesToDuration(n int64, rate int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(audio.BytesPerSecond(rate))
+}
+
+func durationToBytes(d time.Duration, rate int) int64 {
+	// Align to a whole sample
+	return int64(d) * int64(audio.BytesPerSecond(rate)) / int64(time.Second) &^ 1
+}
+
+func (a *App) transcribe(ctx context.Context, call *ivr.Call, args map[string]string) (string, error) {
+	a.limit.Extend(MaxTranscriptionDuration)
+
+	err := transcribe(ctx, a.c, a.id.String(), a.lang.Code, captions)
+	if err == ErrHangup {
+		return "", ivr.ErrHangup
+	}
+	return "", err
+}
+
+// transcribeChannel transcribes one party to an existing call, snooped on by
+// the ARI app, until it ends.  Its captions are labelled with the speaker
+// and published under the snooped channel and the call's transcript as well
+// as the leg.
+func (a *App) transcribeChannel(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, MaxTranscriptionDuration)
+	defer cancel()
+
+	if l := lang.Lookup(a.meta.Language); l != nil {
+		a.lang = l
+	}
+	log.Printf("transcribing %s of channel %s in %s as call %s", a.meta.Speaker, a.meta.Channel, a.lang.Code, a.id.String())
+
+	return transcribe(ctx, a.c, a.id.String(), a.lang.Code, caption.WithLabels(captions, caption.Labels{
+		Channel:    a.meta.Channel,
+		Transcript: a.meta.Transcript,
+		Speaker:    a.meta.Speaker,
+		SpeakerID:  a.meta.SpeakerID,
+	}))
+}
+
+// transcribe continuously transcribes the audio received from the reader
+// until it is closed, publishing interim and final results for the call.
+// Audio is transcribed at the rate of the call, as far as the recognizer
+// allows.
+func transcribe(ctx context.Context, r io.Reader, callID, languageCode string, pub caption.Publisher) error {
+	rate := audio.RecognitionRate(audio.RateOf(r))
+
+	frames := make(chan []byte, 50)
+	go readSlin(ctx, r, frames, rate)
+
+	var (
+		// total is the number of bytes of audio received
+		total int64
+
+		// pending is the audio which is not yet covered by a final result
+		pending []audioChunk
+
+		// began is the time at which the first audio was received
+		began time.Time
+	)
+
+	for ctx.Err() == nil {
+		start := total
+		if len(pending) > 0 {
+			start = pending[0].offset
+		}
+
+		s, err := openRecognitionStream(ctx, languageCode, start, rate)
+		if err != nil {
+			return err
+		}
+		log.Printf("opened transcription stream for call %s at %s", callID, bytesToDuration(start, rate))
+
+		if err = s.replay(pending); err != nil {
+			s.cancel()
+			if err == io.EOF {
+				// The stream has ended on its own; replace it
+				continue
+			}
+			return errors.Wrap(err, "failed to replay audio for transcription")
+		}
+
+		rotate, err := s.run(ctx, func(data []byte) error {
+			if total == 0 {
+				began = time.Now()
+			}
+			pending = append(pending, audioChunk{offset: total, data: data})
+			total += int64(len(data))
+
+			// Bound the audio which will be replayed, should a stream
+			// never return a final result.
+			for len(pending) > 0 && total-pending[0].offset > durationToBytes(MaxStreamOverlap, rate) {
+				pending = pending[1:]
+			}
+			return s.send(data)
+		}, func(res *speechv1.StreamingRecognitionResult) {
+			from := total
+			if len(pending) > 0 {
+				from = pending[0].offset
+			}
+			c, start, end := s.caption(callID, res, from)
+			if c == nil {
+				return
+			}
+			c.Start = began.Add(bytesToDuration(start, rate))
+			c.End = began.Add(time.Duration(c.Offset) * time.Millisecond)
+			if err := pub.Publish(c); err != nil {
+				log.Println("failed to publish caption:", err)
+			}
+			if c.Final {
+				for len(pending) > 0 && pending[0].offset+int64(len(pending[0].data)) <= end {
+					pending = pending[1:]
+				}
+			}
+		}, frames)
+		s.cancel()
+
+		if err != nil {
+			return err
+		}
+		if !rotate {
+			return nil
+		}
+	}
+	return nil
+}
+
+func openRecognitionStream(pCtx context.Context, languageCode string, start int64, rate int) (*recognitionStream, error) {
+	ctx, cancel := context.WithCancel(pCtx)
+
+	svc, err := recog.StreamingRecognize(ctx)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "failed to start streaming recognition")
+	}
+
+	if err := svc.Send(&speechv1.StreamingRecognizeRequest{
+		StreamingRequest: &speechv1.StreamingRecognizeRequest_StreamingConfig{
+			StreamingConfig: &speechv1.StreamingRecognitionConfig{
+				Config: &speechv1.RecognitionConfig{
+					Encoding:                   speechv1.RecognitionConfig_LINEAR16,
+					SampleRateHertz:            int32(rate),
+					LanguageCode:               languageCode,
+					Model:                      "phone_call",
+					UseEnhanced:                true,
+					EnableAutomaticPunctuation: true,
+					EnableWordTimeOffsets:      true,
+				},
+				InterimResults: true,
+			},
+		},
+	}); err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "failed to send recognition config")
+	}
+
+	s := &recognitionStream{
+		svc:     svc,
+		cancel:  cancel,
+		start:   start,
+		rate:    rate,
+		opened:  time.Now(),
+		results: make(chan *speechv1.StreamingRecognizeResponse, 10),
+	}
+	go s.receive()
+
+	return s, nil
+}
+
+// replay sends the audio not yet covered by a final result to the stream
+func (s *recognitionStream) replay(pending []audioChunk) error {
+	for _, c := range pending {
+		if err := s.send(c.data); err != nil {
+			return err
+		}
+		s.replayed += bytesToDuration(int64(len(c.data)), s.rate)
+	}
+	return nil
+}
+
+func (s *recognitionStream) send(data []byte) error {
+	return s.svc.Send(&speechv1.StreamingRecognizeRequest{
+		StreamingRequest: &speechv1.StreamingRecognizeRequest_AudioContent{
+			AudioContent: data,
+		},
+	})
+}
+
+// receive forwards the responses of the stream until it ends
+func (s *recognitionStream) receive() {
+	defer close(s.results)
+
+	for {
+		resp, err := s.svc.Recv()
+		if err != nil {
+			if err != io.EOF && errors.Cause(err) != context.Canceled {
+				log.Println("transcription stream ended:", err)
+			}
+			return
+		}
+		s.results <- resp
+	}
+}
+
+// run feeds audio to the stream and handles its results.  It returns true
+// if the stream should be replaced, or false if the audio has ended.
+func (s *recognitionStream) run(ctx context.Context, onAudio func([]byte) error, onResult func(*speechv1.StreamingRecognitionResult), frames <-chan []byte) (bool, error) {
+	hardLimit := time.NewTimer(MaxStreamDuration - s.replayed)
+	defer hardLimit.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-hardLimit.C:
+			return true, nil
+		case data, ok := <-frames:
+			if !ok {
+				// Collect any remaining results before finishing
+				s.svc.CloseSend() // nolint: errcheck
+				for resp := range s.results {
+					for _, res := range resp.GetResults() {
+						onResult(res)
+					}
+				}
+				return false, ErrHangup
+			}
+			if err := onAudio(data); err != nil {
+				if err == io.EOF {
+					// The stream has ended on its own; replace it
+					return true, nil
+				}
+				return false, errors.Wrap(err, "failed to send audio for transcription")
+			}
+		case resp, ok := <-s.results:
+			if !ok {
+				return true, nil
+			}
+			if err := resp.GetError(); err != nil {
+				log.Println("transcription stream error:", err.String())
+				return true, nil
+			}
+
+			var final bool
+			for _, res := range resp.GetResults() {
+				onResult(res)
+				final = final || res.GetIsFinal()
+			}
+			if final && time.Since(s.opened)+s.replayed > StreamRotationInterval {
+				return true, nil
+			}
+		}
+	}
+}
+
+// caption converts a recognition result to a caption, returning it along with
+// the offsets in the call audio of the start and end of the result.  The
+// start is that of the first word of the result, if known, or else the
+// given offset of the first audio not yet covered by a final result.
+func (s *recognitionStream) caption(callID string, res *speechv1.StreamingRecognitionResult, from int64) (*caption.Caption, int64, int64) {
+	alts := res.GetAlternatives()
+	if len(alts) < 1 || alts[0].GetTranscript() == "" {
+		return nil, 0, 0
+	}
+
+	end := s.start
+	if d, err := ptypes.Duration(res.GetResultEndTime()); err == nil {
+		end += durationToBytes(d, s.rate)
+	}
+
+	start := from
+	if words := alts[0].GetWords(); len(words) > 0 {
+		if d, err := ptypes.Duration(words[0].GetStartTime()); err == nil {
+			start = s.start + durationToBytes(d, s.rate)
+		}
+	}
+
+	return &caption.Caption{
+		CallID:    callID,
+		Final:     res.GetIsFinal(),
+		Text:      alts[0].GetTranscript(),
+		Stability: res.GetStability(),
+		Offset:    int64(bytesToDuration(end, s.rate) / time.Millisecond),
+		Time:      time.Now(),
+	}, start, end
+}
+
+// readSlin reads signed linear audio from the AudioSocket until it is closed
+// or hung up, delivering the payload of each message to the channel,
+// resampled to the given rate.
+func readSlin(ctx context.Context, in io.Reader, out chan<- []byte, rate int) {
+	defer close(out)
+
+	for ctx.Err() == nil {
+		m, err := audiosocket.NextMessage(in)
+		if errors.Cause(err) == io.EOF {
+			log.Println("audiosocket closed")
+			return
+		}
+		if err != nil {
+			log.Println("failed to read from audiosocket:", err)
+			return
+		}
+		switch m.Kind() {
+		case audiosocket.KindHangup:
+			log.Println("audiosocket received hangup command")
+			return
+		case audiosocket.KindError:
+			log.Println("error from audiosocket")
+			continue
+		}
+		msgRate := audio.Rate(m.Kind())
+		if msgRate == 0 || m.ContentLength() < 1 {
+			continue
+		}
+
+		select {
+		case out <- audio.Resample(m.Payload(), msgRate, rate):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
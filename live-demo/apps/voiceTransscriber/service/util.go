@@ -7,13 +7,13 @@ import (
 	"net"
 	"strings"
 
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/audio"
 	"github.com/CyCoreSystems/audiosocket"
 	"github.com/fatih/color"
 
 	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 	speechv1 "google.golang.org/genproto/googleapis/cloud/speech/v1"
-	texttospeechv1 "google.golang.org/genproto/googleapis/cloud/texttospeech/v1"
 )
 
 func getCallID(c net.Conn) (uuid.UUID, error) {
@@ -29,27 +29,12 @@ func getCallID(c net.Conn) (uuid.UUID, error) {
 	return uuid.FromBytes(m.Payload())
 }
 
-func ttsRequest(msg string) *texttospeechv1.SynthesizeSpeechRequest {
-	return &texttospeechv1.SynthesizeSpeechRequest{
-		Input: &texttospeechv1.SynthesisInput{
-			InputSource: &texttospeechv1.SynthesisInput_Text{
-				Text: msg,
-			},
-		},
-		Voice: &texttospeechv1.VoiceSelectionParams{
-			LanguageCode: languageCode,
-		},
-		AudioConfig: &texttospeechv1.AudioConfig{
-			AudioEncoding:   texttospeechv1.AudioEncoding_LINEAR16,
-			SampleRateHertz: 8000,
-		},
-	}
-}
-
-func recognizeRequest(pCtx context.Context, r io.Reader) (string, error) {
+func recognizeRequest(pCtx context.Context, r io.Reader, languageCode string, hints ...string) (string, error) {
 	ctx, cancel := context.WithTimeout(pCtx, MaxRecognitionDuration)
 	defer cancel()
 
+	rate := audio.RecognitionRate(audio.RateOf(r))
+
 	svc, err := recog.StreamingRecognize(ctx)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to start streaming recognition")
@@ -60,13 +45,13 @@ func recognizeRequest(pCtx context.Context, r io.Reader) (string, error) {
 			StreamingConfig: &speechv1.StreamingRecognitionConfig{
 				Config: &speechv1.RecognitionConfig{
 					Encoding:        speechv1.RecognitionConfig_LINEAR16,
-					SampleRateHertz: 8000,
+					SampleRateHertz: int32(rate),
 					LanguageCode:    languageCode,
 					Model:           "command_and_search",
 					UseEnhanced:     true,
 					SpeechContexts: []*speechv1.SpeechContext{
 						&speechv1.SpeechContext{
-							Phrases: keyPhrases,
+							Phrases: hints,
 						},
 					},
 				},
@@ -76,7 +61,17 @@ func recognizeRequest(pCtx context.Context, r io.Reader) (string, error) {
 		return "", errors.Wrap(err, "failed to send recognition config")
 	}
 
-	go pipeFromAsterisk(ctx, cancel, r, svc)
+	// Stop reading audio before returning, so that the next reader starts
+	// at a message boundary
+	piped := make(chan struct{})
+	go func() {
+		pipeFromAsterisk(ctx, cancel, r, svc, rate)
+		close(piped)
+	}()
+	defer func() {
+		cancel()
+		<-piped
+	}()
 
 	resp, err := svc.Recv()
 	if err == io.EOF {
@@ -103,7 +98,9 @@ func recognizeRequest(pCtx context.Context, r io.Reader) (string, error) {
 	return "", nil
 }
 
-func pipeFromAsterisk(ctx context.Context, cancel context.CancelFunc, in io.Reader, out speechv1.Speech_StreamingRecognizeClient) {
+// pipeFromAsterisk sends the audio of the call to the recognizer, resampled
+// to the given rate
+func pipeFromAsterisk(ctx context.Context, cancel context.CancelFunc, in io.Reader, out speechv1.Speech_StreamingRecognizeClient, rate int) {
 	var err error
 	var m audiosocket.Message
 
@@ -124,8 +121,8 @@ func pipeFromAsterisk(ctx context.Context, cancel context.CancelFunc, in io.Read
 			log.Println("error from audiosocket")
 			continue
 		}
-		if m.Kind() != audiosocket.KindSlin {
-			log.Println("ignoring non-slin message", m.Kind())
+		msgRate := audio.Rate(m.Kind())
+		if msgRate == 0 {
 			continue
 		}
 		if m.ContentLength() < 1 {
@@ -134,7 +131,7 @@ func pipeFromAsterisk(ctx context.Context, cancel context.CancelFunc, in io.Read
 		}
 		if err = out.Send(&speechv1.StreamingRecognizeRequest{
 			StreamingRequest: &speechv1.StreamingRecognizeRequest_AudioContent{
-				AudioContent: m.Payload(),
+				AudioContent: audio.Resample(m.Payload(), msgRate, rate),
 			},
 		}); err != nil {
 			if err == io.EOF {
@@ -145,16 +142,19 @@ func pipeFromAsterisk(ctx context.Context, cancel context.CancelFunc, in io.Read
 	}
 }
 
-func sendAudio(w io.Writer, data []byte) error {
-	return audiosocket.SendSlinChunks(w, slinChunkSize, data)
+// sendAudio sends audio at the given rate to the call, at the call's rate
+func sendAudio(w io.Writer, rate int, data []byte) error {
+	return audio.Send(w, rate, data)
 }
 
-func speak(ctx context.Context, rw io.ReadWriter, msg string) error {
-	resp, err := tts.SynthesizeSpeech(ctx, ttsRequest(msg))
+// speak synthesizes the message at the rate of the call and sends it
+func speak(ctx context.Context, rw io.ReadWriter, languageCode, msg string) error {
+	rate := audio.RateOf(rw)
+	resp, err := tts.SynthesizeSpeech(ctx, synthesizer.RequestAt(languageCode, msg, rate))
 	if err != nil {
 		return errors.Wrap(err, "failed to synthesize speech")
 	}
-	if err = sendAudio(rw, resp.GetAudioContent()); err != nil {
+	if err = sendAudio(rw, rate, resp.GetAudioContent()); err != nil {
 		return errors.Wrap(err, "failed to send speech to Asterisk")
 	}
 	return nil
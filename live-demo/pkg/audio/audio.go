@@ -0,0 +1,229 @@
+// Package audio negotiates the sample rate of the signed linear audio of a
+// call.  Newer versions of Asterisk send AudioSocket audio at the rate of
+// the channel, marking each message with a kind for that rate, where the
+// audiosocket package knows only 8kHz.  The rate of a call is taken from
+// the messages it sends, or before any arrive, from its configuration, and
+// audio sent to the call follows it.
+package audio
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/dsp"
+	"github.com/CyCoreSystems/audiosocket"
+	"github.com/pkg/errors"
+)
+
+// Kinds of AudioSocket message which carry signed linear audio at rates
+// above 8kHz
+const (
+	KindSlin12  audiosocket.Kind = 0x11
+	KindSlin16  audiosocket.Kind = 0x12
+	KindSlin24  audiosocket.Kind = 0x13
+	KindSlin32  audiosocket.Kind = 0x14
+	KindSlin44  audiosocket.Kind = 0x15
+	KindSlin48  audiosocket.Kind = 0x16
+	KindSlin96  audiosocket.Kind = 0x17
+	KindSlin192 audiosocket.Kind = 0x18
+)
+
+// DefaultRate is the sample rate of a call whose rate is not otherwise known
+const DefaultRate = dsp.SampleRate
+
+// MaxRecognitionRate is the highest sample rate accepted by the speech
+// recognizer
+const MaxRecognitionRate = 48000
+
+// FrameDuration is the duration of the audio carried by each message sent
+// to a call
+const FrameDuration = 20 * time.Millisecond
+
+var rates = map[audiosocket.Kind]int{
+	audiosocket.KindSlin: 8000,
+	KindSlin12:           12000,
+	KindSlin16:           16000,
+	KindSlin24:           24000,
+	KindSlin32:           32000,
+	KindSlin44:           44100,
+	KindSlin48:           48000,
+	KindSlin96:           96000,
+	KindSlin192:          192000,
+}
+
+// Rate returns the sample rate of the audio carried by messages of the given
+// kind, or zero if they do not carry audio
+func Rate(k audiosocket.Kind) int {
+	return rates[k]
+}
+
+// Kind returns the kind of message which carries audio at the given rate
+func Kind(rate int) (audiosocket.Kind, bool) {
+	for k, r := range rates {
+		if r == rate {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// Message returns a message carrying audio at the given rate.  Audio at a
+// rate which AudioSocket cannot carry is sent as 8kHz audio.
+func Message(rate int, data []byte) audiosocket.Message {
+	k, ok := Kind(rate)
+	if !ok {
+		return audiosocket.SlinMessage(Resample(data, rate, DefaultRate))
+	}
+	m := audiosocket.SlinMessage(data)
+	m[0] = byte(k)
+	return m
+}
+
+// ChunkSize returns the number of bytes of audio at the given rate which are
+// sent per message
+func ChunkSize(rate int) int {
+	return 2 * rate * int(FrameDuration/time.Millisecond) / 1000
+}
+
+// BytesPerSecond returns the data rate of audio at the given rate
+func BytesPerSecond(rate int) int {
+	return 2 * rate
+}
+
+// RecognitionRate returns the rate at which audio of the given rate is
+// sent to the speech recognizer
+func RecognitionRate(rate int) int {
+	if rate > MaxRecognitionRate {
+		return MaxRecognitionRate
+	}
+	return rate
+}
+
+// Resample converts little-endian 16-bit signed linear audio from one rate
+// to another
+func Resample(data []byte, from, to int) []byte {
+	if from == to {
+		return data
+	}
+	return dsp.Bytes(dsp.Resample(dsp.Samples(data), from, to))
+}
+
+// RateFromEnv returns the sample rate given by the AUDIO_SAMPLE_RATE
+// environment variable, or DefaultRate
+func RateFromEnv() (int, error) {
+	v := os.Getenv("AUDIO_SAMPLE_RATE")
+	if v == "" {
+		return DefaultRate, nil
+	}
+	rate, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid AUDIO_SAMPLE_RATE")
+	}
+	if _, ok := Kind(rate); !ok {
+		return 0, errors.Errorf("unsupported sample rate %d", rate)
+	}
+	return rate, nil
+}
+
+// Rater is implemented by connections which know the sample rate of their
+// call
+type Rater interface {
+	Rate() int
+}
+
+// RateOf returns the sample rate of the call of the connection, or
+// DefaultRate if the connection does not know it
+func RateOf(rw interface{}) int {
+	if r, ok := rw.(Rater); ok {
+		return r.Rate()
+	}
+	return DefaultRate
+}
+
+// Conn wraps the AudioSocket connection of a call, keeping track of the
+// sample rate of its audio.  Messages read through it are passed on
+// unchanged.
+type Conn struct {
+	rw io.ReadWriter
+
+	// pending is the unread part of the current message
+	pending []byte
+
+	mu   sync.Mutex
+	rate int
+}
+
+// NewConn wraps the AudioSocket connection of a call whose audio is
+// expected at the given rate until it shows otherwise
+func NewConn(rw io.ReadWriter, rate int) *Conn {
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+	return &Conn{rw: rw, rate: rate}
+}
+
+// Rate implements Rater
+func (c *Conn) Rate() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rate
+}
+
+// SetRate sets the expected rate of the call's audio, as configured for it
+func (c *Conn) SetRate(rate int) {
+	if rate <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.rate = rate
+	c.mu.Unlock()
+}
+
+// Write implements io.Writer
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.rw.Write(p)
+}
+
+// Read implements io.Reader, noting the rate of each audio message
+func (c *Conn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		m, err := audiosocket.NextMessage(c.rw)
+		if err != nil {
+			return 0, err
+		}
+		if rate := Rate(m.Kind()); rate > 0 {
+			c.SetRate(rate)
+		}
+		c.pending = m
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Send sends audio at the given rate to a call, resampled to the call's
+// rate, in messages paced at FrameDuration
+func Send(w io.Writer, rate int, data []byte) error {
+	callRate := RateOf(w)
+	data = Resample(data, rate, callRate)
+	size := ChunkSize(callRate)
+
+	t := time.NewTicker(FrameDuration)
+	defer t.Stop()
+
+	for i := 0; i < len(data); i += size {
+		<-t.C
+		end := i + size
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := w.Write(Message(callRate, data[i:end])); err != nil {
+			return errors.Wrap(err, "failed to write chunk to AudioSocket")
+		}
+	}
+	return nil
+}
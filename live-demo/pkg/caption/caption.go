@@ -0,0 +1,292 @@
+// Package caption distributes live transcription results of calls to
+// listeners over Server-Sent Events and NATS.
+package caption
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// SubjectPrefix is the prefix of the NATS subject on which the captions of a
+// call are published.  The full subject is the prefix followed by the call
+// UUID.
+const SubjectPrefix = "captions."
+
+// ChannelSubjectPrefix is the prefix of the NATS subject on which the
+// captions of a transcribed Asterisk channel are also published.  The full
+// subject is the prefix followed by the channel ID.
+const ChannelSubjectPrefix = "captions.channel."
+
+// TranscriptSubjectPrefix is the prefix of the NATS subject on which the
+// captions of all parties to a transcribed call are also published.  The
+// full subject is the prefix followed by the transcript ID.
+const TranscriptSubjectPrefix = "captions.transcript."
+
+// Speakers of the captions of a transcribed call
+const (
+	// SpeakerCaller is the party whose channel is transcribed
+	SpeakerCaller = "caller"
+
+	// SpeakerCallee is the party to whom the caller is talking
+	SpeakerCallee = "callee"
+)
+
+// SubscriberBuffer is the number of captions which may be queued for a slow
+// subscriber before captions are dropped
+var SubscriberBuffer = 100
+
+// Caption is a single transcription result
+type Caption struct {
+	// CallID is the UUID of the call
+	CallID string `json:"call"`
+
+	// Channel is the ID of the Asterisk channel which is transcribed, when
+	// an existing call is transcribed
+	Channel string `json:"channel,omitempty"`
+
+	// Transcript is the ID of the transcript of an existing call, which
+	// combines the captions of each of its parties
+	Transcript string `json:"transcript,omitempty"`
+
+	// Speaker is the party to an existing call whose speech is captioned,
+	// such as SpeakerCaller
+	Speaker string `json:"speaker,omitempty"`
+
+	// SpeakerID is the number of the speaker, if known
+	SpeakerID string `json:"speakerId,omitempty"`
+
+	// Final indicates that the text will not change.  Interim captions are
+	// superseded by each subsequent caption until a final caption is
+	// published.
+	Final bool `json:"final"`
+
+	// Text is the transcribed text
+	Text string `json:"text"`
+
+	// Stability is the recognizer's estimate of the likelihood that an
+	// interim caption will not change
+	Stability float32 `json:"stability,omitempty"`
+
+	// Offset is the position of the end of the caption in milliseconds,
+	// measured from the start of the transcription
+	Offset int64 `json:"offset"`
+
+	// Time is the time at which the caption was produced
+	Time time.Time `json:"time"`
+
+	// Start is the time at which the captioned speech began, by which the
+	// captions of the parties to a call are ordered
+	Start time.Time `json:"start"`
+
+	// End is the time at which the captioned speech ended
+	End time.Time `json:"end"`
+}
+
+// Subject returns the NATS subject for the captions of the given call
+func Subject(callID string) string {
+	return SubjectPrefix + callID
+}
+
+// ChannelSubject returns the NATS subject for the captions of the given
+// Asterisk channel
+func ChannelSubject(channel string) string {
+	return ChannelSubjectPrefix + channel
+}
+
+// Publisher publishes captions
+type Publisher interface {
+	Publish(c *Caption) error
+}
+
+// TranscriptSubject returns the NATS subject for the captions of the given
+// transcript
+func TranscriptSubject(transcript string) string {
+	return TranscriptSubjectPrefix + transcript
+}
+
+// Labels identify the captions of one party to a transcribed call
+type Labels struct {
+	Channel    string
+	Transcript string
+	Speaker    string
+	SpeakerID  string
+}
+
+// WithLabels returns a Publisher which labels each caption before publishing
+// it to pub
+func WithLabels(pub Publisher, l Labels) Publisher {
+	return labelPublisher{pub: pub, labels: l}
+}
+
+type labelPublisher struct {
+	pub    Publisher
+	labels Labels
+}
+
+func (p labelPublisher) Publish(c *Caption) error {
+	c.Channel = p.labels.Channel
+	c.Transcript = p.labels.Transcript
+	c.Speaker = p.labels.Speaker
+	c.SpeakerID = p.labels.SpeakerID
+	return p.pub.Publish(c)
+}
+
+// Publishers publishes captions to each of a set of Publishers
+type Publishers []Publisher
+
+// Publish implements Publisher
+func (p Publishers) Publish(c *Caption) (err error) {
+	for _, pub := range p {
+		if pErr := pub.Publish(c); pErr != nil {
+			err = pErr
+		}
+	}
+	return err
+}
+
+// NATSPublisher publishes captions to NATS
+type NATSPublisher struct {
+	nc *nats.Conn
+}
+
+// NewNATSPublisher returns a Publisher which publishes captions on the given
+// NATS connection
+func NewNATSPublisher(nc *nats.Conn) *NATSPublisher {
+	return &NATSPublisher{nc: nc}
+}
+
+// Publish implements Publisher
+func (p *NATSPublisher) Publish(c *Caption) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode caption")
+	}
+	if err = p.nc.Publish(Subject(c.CallID), data); err != nil {
+		return errors.Wrap(err, "failed to publish caption")
+	}
+	if c.Channel != "" {
+		if err = p.nc.Publish(ChannelSubject(c.Channel), data); err != nil {
+			return errors.Wrap(err, "failed to publish caption")
+		}
+	}
+	if c.Transcript != "" {
+		return errors.Wrap(p.nc.Publish(TranscriptSubject(c.Transcript), data), "failed to publish caption")
+	}
+	return nil
+}
+
+// Hub distributes captions to local subscribers, such as HTTP clients
+type Hub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan *Caption]struct{}
+}
+
+// NewHub returns a new Hub
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[string]map[chan *Caption]struct{}),
+	}
+}
+
+// Publish implements Publisher.  Captions are delivered to the subscribers
+// of the call and of its transcribed channel and transcript, if any.
+// Captions are dropped for subscribers which are not keeping up.
+func (h *Hub) Publish(c *Caption) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, id := range []string{c.CallID, c.Channel, c.Transcript} {
+		if id == "" {
+			continue
+		}
+		for ch := range h.subs[id] {
+			select {
+			case ch <- c:
+			default:
+				log.Println("dropping caption for slow subscriber of call", c.CallID)
+			}
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a channel on which the captions of the given call, or of
+// the given transcribed channel or transcript, are delivered, and a function
+// which cancels the subscription.
+func (h *Hub) Subscribe(callID string) (<-chan *Caption, func()) {
+	ch := make(chan *Caption, SubscriberBuffer)
+
+	h.mu.Lock()
+	if h.subs[callID] == nil {
+		h.subs[callID] = make(map[chan *Caption]struct{})
+	}
+	h.subs[callID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs[callID], ch)
+		if len(h.subs[callID]) == 0 {
+			delete(h.subs, callID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// ServeHTTP streams the captions of the call, transcribed channel or
+// transcript identified by the last element of the request path (e.g.
+// /captions/<uuid>) as Server-Sent Events.
+// Interim captions are sent as "interim" events and final captions as
+// "final" events.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	callID := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if callID == "" {
+		http.Error(w, "call ID required", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	captions, cancel := h.Subscribe(callID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case c := <-captions:
+			data, err := json.Marshal(c)
+			if err != nil {
+				log.Println("failed to encode caption:", err)
+				continue
+			}
+
+			event := "interim"
+			if c.Final {
+				event = "final"
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Package content loads the wording of the voice applications (messages,
+// jokes and phrase hints) from content packs, so that it may be edited
+// without rebuilding the applications.
+//
+// A content pack is a YAML document in which every entry is tagged with the
+// language to which it belongs:
+//
+//	messages:
+//	  greeting:
+//	    en-US: Hello.
+//	    es: Hola.
+//	jokes:
+//	  en:
+//	    - Velcro - what a rip-off!
+//	phrases:
+//	  hangup:
+//	    en: [bye, goodbye]
+//
+// Languages are matched by their full code first, then by their base
+// language, and finally fall back to English.
+package content
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FallbackLanguage is the language whose content is used when a language
+// has none of its own
+const FallbackLanguage = "en"
+
+// Pack is a set of content
+type Pack struct {
+	// Messages are the messages spoken by the application, keyed by name
+	// and then by language
+	Messages map[string]map[string]string `yaml:"messages,omitempty"`
+
+	// Jokes are keyed by language
+	Jokes map[string][]string `yaml:"jokes,omitempty"`
+
+	// Phrases are the words which the caller may say, used as recognition
+	// hints and keywords, keyed by name and then by language
+	Phrases map[string]map[string][]string `yaml:"phrases,omitempty"`
+}
+
+// Parse parses a content pack
+func Parse(data []byte) (*Pack, error) {
+	p := new(Pack)
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, errors.Wrap(err, "failed to parse content pack")
+	}
+	return p, nil
+}
+
+// Merge returns a pack containing the content of both packs.  Where both
+// define the same entry for the same language, the entry of o is used.
+func (p *Pack) Merge(o *Pack) *Pack {
+	out := &Pack{
+		Messages: make(map[string]map[string]string),
+		Jokes:    make(map[string][]string),
+		Phrases:  make(map[string]map[string][]string),
+	}
+	for _, in := range []*Pack{p, o} {
+		for name, byLang := range in.Messages {
+			if out.Messages[name] == nil {
+				out.Messages[name] = make(map[string]string)
+			}
+			for l, msg := range byLang {
+				out.Messages[name][l] = msg
+			}
+		}
+		for l, jokes := range in.Jokes {
+			out.Jokes[l] = jokes
+		}
+		for name, byLang := range in.Phrases {
+			if out.Phrases[name] == nil {
+				out.Phrases[name] = make(map[string][]string)
+			}
+			for l, phrases := range byLang {
+				out.Phrases[name][l] = phrases
+			}
+		}
+	}
+	return out
+}
+
+// Message returns the named message in the given language
+func (p *Pack) Message(language, name string) string {
+	return p.Messages[name][match(keysOfStrings(p.Messages[name]), language)]
+}
+
+// MessagesFor returns all messages in the given language, keyed by name
+func (p *Pack) MessagesFor(language string) map[string]string {
+	out := make(map[string]string, len(p.Messages))
+	for name := range p.Messages {
+		out[name] = p.Message(language, name)
+	}
+	return out
+}
+
+// JokesFor returns the jokes in the given language
+func (p *Pack) JokesFor(language string) []string {
+	return p.Jokes[match(keysOfLists(p.Jokes), language)]
+}
+
+// PhrasesFor returns the named phrases in the given language
+func (p *Pack) PhrasesFor(language, name string) []string {
+	return p.Phrases[name][match(keysOfLists(p.Phrases[name]), language)]
+}
+
+// Jokester tells jokes without repeating any until all have been told
+type Jokester struct {
+	told map[string]bool
+}
+
+// Next returns a joke in the given language from the pack which has not yet
+// been told.  Once all have been told, they may be told again.  It returns
+// the empty string if the pack has no jokes in the language.
+func (j *Jokester) Next(p *Pack, language string) string {
+	jokes := p.JokesFor(language)
+	if len(jokes) == 0 {
+		return ""
+	}
+	if j.told == nil {
+		j.told = make(map[string]bool)
+	}
+
+	var fresh []string
+	for _, joke := range jokes {
+		if !j.told[joke] {
+			fresh = append(fresh, joke)
+		}
+	}
+	if len(fresh) == 0 {
+		for _, joke := range jokes {
+			delete(j.told, joke)
+		}
+		fresh = jokes
+	}
+
+	joke := fresh[rand.Intn(len(fresh))]
+	j.told[joke] = true
+	return joke
+}
+
+// match returns the key which best matches the language: the language
+// itself, then its base language, then any other variant of its base
+// language.  If none match, the fallback language is matched in the same
+// way.
+func match(keys []string, language string) string {
+	sort.Strings(keys)
+	for _, want := range []string{language, FallbackLanguage} {
+		for _, k := range keys {
+			if strings.EqualFold(k, want) {
+				return k
+			}
+		}
+		for _, k := range keys {
+			if strings.EqualFold(k, base(want)) {
+				return k
+			}
+		}
+		for _, k := range keys {
+			if base(k) == base(want) {
+				return k
+			}
+		}
+	}
+	return ""
+}
+
+func base(code string) string {
+	code = strings.ToLower(code)
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		return code[:i]
+	}
+	return code
+}
+
+func keysOfStrings(m map[string]string) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return
+}
+
+func keysOfLists(m map[string][]string) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package content
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Source loads content packs from the YAML files of a directory, such as a
+// mounted ConfigMap, reloading them whenever they change.  The packs are
+// merged, in the order of their file names, over a built-in pack, so a pack
+// need only contain the entries which it changes.
+type Source struct {
+	dir      string
+	fallback []byte
+
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+	pack     *Pack
+}
+
+// NewSource returns a Source for the content packs in the given directory,
+// over the built-in pack
+func NewSource(dir string, fallback []byte) *Source {
+	return &Source{
+		dir:      dir,
+		fallback: fallback,
+	}
+}
+
+// Pack returns the current content.  If the files have changed but no
+// longer parse, the previous content is retained and the error is returned
+// along with it.  If they have never loaded, the built-in pack is returned
+// with the error instead, so a pack is always returned.
+func (s *Source) Pack() (*Pack, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.files()
+	if err != nil {
+		return s.previous(err)
+	}
+
+	modTimes := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return s.previous(errors.Wrapf(err, "failed to read content pack %s", f))
+		}
+		modTimes[f] = info.ModTime()
+	}
+	if s.pack != nil && unchanged(s.modTimes, modTimes) {
+		return s.pack, nil
+	}
+
+	pack, err := Parse(s.fallback)
+	if err != nil {
+		return s.previous(errors.Wrap(err, "failed to parse built-in content"))
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return s.previous(errors.Wrapf(err, "failed to read content pack %s", f))
+		}
+		p, err := Parse(data)
+		if err != nil {
+			return s.previous(errors.Wrapf(err, "failed to load content pack %s", f))
+		}
+		pack = pack.Merge(p)
+	}
+
+	s.pack, s.modTimes = pack, modTimes
+	return s.pack, nil
+}
+
+// previous returns the content last loaded along with the error which
+// prevented a reload.  If nothing has loaded yet, the built-in pack is used,
+// or an empty pack should even that fail to parse.  The modification times
+// are left alone, so the files are tried again on the next call.
+func (s *Source) previous(err error) (*Pack, error) {
+	if s.pack != nil {
+		return s.pack, err
+	}
+
+	pack, pErr := Parse(s.fallback)
+	if pErr != nil {
+		return new(Pack), errors.Wrapf(err, "failed to parse built-in content (%v)", pErr)
+	}
+	s.pack = pack
+	return s.pack, err
+}
+
+// files returns the content pack files of the directory, in order
+func (s *Source) files() ([]string, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(s.dir, pattern))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list content packs")
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func unchanged(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for f, t := range a {
+		if !b[f].Equal(t) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package content
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testFallback = `
+messages:
+  greeting:
+    en: Hello.
+  parting:
+    en: Good-bye.
+`
+
+func writePack(t *testing.T, path, data string, mod time.Time) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Each write must look like a change, however quickly it follows the
+	// last
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSourceMalformedPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	path := filepath.Join(dir, "pack.yaml")
+	mod := time.Now().Add(-time.Hour)
+	s := NewSource(dir, []byte(testFallback))
+
+	// A pack which has never loaded gives way to the built-in pack
+	writePack(t, path, "messages:\n  greeting: [not, a, map]\n", mod)
+	p, err := s.Pack()
+	if err == nil {
+		t.Error("expected error for malformed pack")
+	}
+	if p == nil {
+		t.Fatal("no pack returned for malformed pack")
+	}
+	if got := p.Message("en-US", "greeting"); got != "Hello." {
+		t.Errorf("greeting %q, want the built-in greeting", got)
+	}
+
+	// Unknown fields are refused, too
+	writePack(t, path, "message:\n  greeting:\n    en: Hi.\n", mod.Add(time.Minute))
+	if p, err = s.Pack(); err == nil || p == nil {
+		t.Fatalf("pack with unknown field: got %v, %v", p, err)
+	}
+
+	// Once fixed, the pack is merged over the built-in pack
+	writePack(t, path, "messages:\n  greeting:\n    en: Hi.\n", mod.Add(2*time.Minute))
+	if p, err = s.Pack(); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Message("en", "greeting"); got != "Hi." {
+		t.Errorf("greeting %q, want %q", got, "Hi.")
+	}
+	if got := p.Message("en", "parting"); got != "Good-bye." {
+		t.Errorf("parting %q, want the built-in parting", got)
+	}
+
+	// Breaking it again keeps the last good content
+	writePack(t, path, "messages: [", mod.Add(3*time.Minute))
+	if p, err = s.Pack(); err == nil {
+		t.Error("expected error for malformed pack")
+	}
+	if got := p.Message("en", "greeting"); got != "Hi." {
+		t.Errorf("greeting %q, want the last loaded greeting", got)
+	}
+}
+
+func TestSourceUnreadablePack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	// A directory named like a pack cannot be read as one
+	if err = os.Mkdir(filepath.Join(dir, "pack.yaml"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewSource(dir, []byte(testFallback)).Pack()
+	if err == nil {
+		t.Error("expected error for unreadable pack")
+	}
+	if p == nil || p.Message("en", "greeting") != "Hello." {
+		t.Errorf("got %+v, want the built-in pack", p)
+	}
+}
+
+func TestSourceMalformedFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	p, err := NewSource(dir, []byte("messages: [")).Pack()
+	if err == nil {
+		t.Error("expected error for malformed built-in pack")
+	}
+	if p == nil {
+		t.Fatal("no pack returned for malformed built-in pack")
+	}
+	if got := p.Message("en", "greeting"); got != "" {
+		t.Errorf("greeting %q from an empty pack", got)
+	}
+}
@@ -0,0 +1,481 @@
+// Package control carries commands from the voice services back to the ARI
+// app which connected a call to them, so that a voice service may do more
+// with a call than hang up its AudioSocket.  Commands are NATS requests on a
+// subject keyed by the AudioSocket UUID of the call, to which the ARI app
+// replies once the command has been carried out.  In the other direction,
+// the ARI app publishes events of the call, such as DTMF, which the
+// AudioSocket does not carry.
+//
+// The ARI apps also take requests to transcribe existing calls and to place
+// outbound calls, which are not addressed to any one call.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// SubjectPrefix is the prefix of the NATS subject on which the commands for
+// a call are sent.  The full subject is the prefix followed by the call
+// UUID.
+const SubjectPrefix = "audiosocket.control."
+
+// DefaultTimeout is the time to wait for a command to be carried out when
+// the context has no deadline
+var DefaultTimeout = 10 * time.Second
+
+// TransferTimeout is the time for which the endpoint of a transfer is
+// allowed to ring.  A transfer to an endpoint is not carried out until the
+// endpoint answers, so its command must be given at least this long.
+var TransferTimeout = 30 * time.Second
+
+// Kinds of command
+const (
+	// Transfer transfers the caller to a dialplan extension or an endpoint,
+	// removing the voice service from the call
+	Transfer = "transfer"
+
+	// Play plays a sound to the caller
+	Play = "play"
+
+	// DTMF sends DTMF digits to the caller
+	DTMF = "dtmf"
+
+	// StartRecording starts recording the call
+	StartRecording = "startRecording"
+
+	// StopRecording stops recording the call
+	StopRecording = "stopRecording"
+
+	// Hangup hangs up the caller
+	Hangup = "hangup"
+)
+
+// Command is a command for the ARI app handling a call
+type Command struct {
+	// Kind is the kind of command, such as Transfer
+	Kind string `json:"kind"`
+
+	// Context and Extension are the dialplan location to which the caller
+	// is transferred
+	Context   string `json:"context,omitempty"`
+	Extension string `json:"extension,omitempty"`
+
+	// Endpoint is the endpoint, such as PJSIP/1000, to which the caller is
+	// transferred, in place of a dialplan location
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Sound is the name of the sound to play
+	Sound string `json:"sound,omitempty"`
+
+	// Digits are the DTMF digits to send
+	Digits string `json:"digits,omitempty"`
+
+	// Recording is the name of the recording
+	Recording string `json:"recording,omitempty"`
+}
+
+// Reply is the result of a command
+type Reply struct {
+	// Error describes the failure of the command, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// Subject returns the NATS subject for the commands of the given call
+func Subject(callID string) string {
+	return SubjectPrefix + callID
+}
+
+// Client sends commands to the ARI app
+type Client struct {
+	nc *nats.Conn
+}
+
+// NewClient returns a Client which sends commands on the given NATS
+// connection
+func NewClient(nc *nats.Conn) *Client {
+	return &Client{nc: nc}
+}
+
+// Send sends a command for the given call and waits for it to be carried
+// out
+func (c *Client) Send(ctx context.Context, callID string, cmd *Command) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode command")
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	msg, err := c.nc.RequestWithContext(ctx, Subject(callID), data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to send %s command", cmd.Kind)
+	}
+
+	reply := new(Reply)
+	if err = json.Unmarshal(msg.Data, reply); err != nil {
+		return errors.Wrap(err, "failed to decode reply")
+	}
+	if reply.Error != "" {
+		return errors.Errorf("%s command failed: %s", cmd.Kind, reply.Error)
+	}
+	return nil
+}
+
+// Events subscribes to the events of the given call.  The caller must
+// unsubscribe when the call ends.
+func (c *Client) Events(callID string, h func(e *Event)) (*nats.Subscription, error) {
+	return SubscribeEvents(c.nc, callID, h)
+}
+
+// Handler carries out a command
+type Handler func(cmd *Command) error
+
+// Subscribe subscribes to the commands for the given call, passing each to
+// the handler and replying with its result.  The caller must unsubscribe
+// when the call ends.
+func Subscribe(nc *nats.Conn, callID string, h Handler) (*nats.Subscription, error) {
+	return nc.Subscribe(Subject(callID), func(m *nats.Msg) {
+		reply := new(Reply)
+
+		cmd := new(Command)
+		serve(nc, m, "command", cmd, reply, &reply.Error, func() error {
+			return h(cmd)
+		})
+	})
+}
+
+// serve decodes the request of the message into req and passes it to
+// handle, recording any failure in errMsg, which must be the error field
+// of reply, and sending reply in answer to the message.
+func serve(nc *nats.Conn, m *nats.Msg, kind string, req, reply interface{}, errMsg *string, handle func() error) {
+	if err := json.Unmarshal(m.Data, req); err != nil {
+		*errMsg = "invalid " + kind + ": " + err.Error()
+	} else if err = handle(); err != nil {
+		*errMsg = err.Error()
+	}
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		log.Println("failed to encode reply:", err)
+		return
+	}
+	if m.Reply == "" {
+		return
+	}
+	if err = nc.Publish(m.Reply, data); err != nil {
+		log.Println("failed to send reply:", err)
+	}
+}
+
+// EventSubjectPrefix is the prefix of the NATS subject on which the ARI app
+// publishes the events of a call for its voice service.  The full subject
+// is the prefix followed by the call UUID.
+const EventSubjectPrefix = "audiosocket.events."
+
+// Kinds of event
+const (
+	// EventDTMF indicates that the caller pressed a key
+	EventDTMF = "dtmf"
+)
+
+// Event is an event of a call, sent by the ARI app to the voice service
+type Event struct {
+	// Kind is the kind of event, such as EventDTMF
+	Kind string `json:"kind"`
+
+	// Digit is the key which was pressed
+	Digit string `json:"digit,omitempty"`
+}
+
+// EventSubject returns the NATS subject for the events of the given call
+func EventSubject(callID string) string {
+	return EventSubjectPrefix + callID
+}
+
+// PublishEvent publishes an event of the given call
+func PublishEvent(nc *nats.Conn, callID string, e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+	return errors.Wrap(nc.Publish(EventSubject(callID), data), "failed to publish event")
+}
+
+// SubscribeEvents subscribes to the events of the given call.  The caller
+// must unsubscribe when the call ends.
+func SubscribeEvents(nc *nats.Conn, callID string, h func(e *Event)) (*nats.Subscription, error) {
+	return nc.Subscribe(EventSubject(callID), func(m *nats.Msg) {
+		e := new(Event)
+		if err := json.Unmarshal(m.Data, e); err != nil {
+			log.Println("ignoring invalid event:", err)
+			return
+		}
+		h(e)
+	})
+}
+
+// TranscribeSubject is the NATS subject on which the ARI apps take requests
+// to transcribe existing calls
+const TranscribeSubject = "audiosocket.transcribe"
+
+// TranscribeQueue is the NATS queue group in which the ARI apps take
+// transcription requests, so that each is handled by one app
+const TranscribeQueue = "transcribe"
+
+// TranscribeRequest asks for the audio of a channel to be transcribed,
+// without the parties to the call hearing anything
+type TranscribeRequest struct {
+	// Channel is the ID of the channel
+	Channel string `json:"channel"`
+
+	// Node is the Asterisk node of the channel, if known
+	Node string `json:"node,omitempty"`
+
+	// Language is the language of the call, if known
+	Language string `json:"language,omitempty"`
+}
+
+// TranscribeReply is the result of a TranscribeRequest
+type TranscribeReply struct {
+	// ID is the ID of the transcript of the call, under which the captions
+	// of all of its parties are published and stored
+	ID string `json:"id,omitempty"`
+
+	// Error describes the failure of the request, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// Transcribe asks the ARI apps to transcribe a channel, returning the ID of
+// its transcript
+func (c *Client) Transcribe(ctx context.Context, req *TranscribeRequest) (string, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode transcription request")
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	msg, err := c.nc.RequestWithContext(ctx, TranscribeSubject, data)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to send transcription request")
+	}
+
+	reply := new(TranscribeReply)
+	if err = json.Unmarshal(msg.Data, reply); err != nil {
+		return "", errors.Wrap(err, "failed to decode reply")
+	}
+	if reply.Error != "" {
+		return "", errors.Errorf("transcription request failed: %s", reply.Error)
+	}
+	return reply.ID, nil
+}
+
+// SubscribeTranscribe subscribes to transcription requests, passing each to
+// the handler and replying with the ID of the transcription which it
+// starts.  The caller must unsubscribe when it stops taking requests.
+func SubscribeTranscribe(nc *nats.Conn, h func(req *TranscribeRequest) (string, error)) (*nats.Subscription, error) {
+	return nc.QueueSubscribe(TranscribeSubject, TranscribeQueue, func(m *nats.Msg) {
+		reply := new(TranscribeReply)
+
+		req := new(TranscribeRequest)
+		serve(nc, m, "request", req, reply, &reply.Error, func() (err error) {
+			reply.ID, err = h(req)
+			return err
+		})
+	})
+}
+
+// OriginateSubject is the NATS subject on which the ARI apps take requests
+// to place outbound calls
+const OriginateSubject = "audiosocket.originate"
+
+// OriginateQueue is the NATS queue group in which the ARI apps take
+// outbound call requests, so that each is handled by one app
+const OriginateQueue = "originate"
+
+// OutboundSubjectPrefix is the prefix of the NATS subject on which the
+// progress of an outbound call is published.  The full subject is the prefix
+// followed by the call ID.
+const OutboundSubjectPrefix = "audiosocket.outbound."
+
+// Targets to which the callee of an outbound call is connected
+const (
+	// TargetVoice connects the callee to a voice service
+	TargetVoice = "voice"
+
+	// TargetIVR connects the callee to a DTMF IVR
+	TargetIVR = "ivr"
+)
+
+// States of an outbound call
+const (
+	StateDialing   = "dialing"
+	StateRinging   = "ringing"
+	StateAnswered  = "answered"
+	StateCompleted = "completed"
+	StateBusy      = "busy"
+	StateNoAnswer  = "no-answer"
+	StateFailed    = "failed"
+)
+
+// OriginateRequest asks for an outbound call to be placed and its callee
+// connected to a voice app
+type OriginateRequest struct {
+	// Endpoint is the endpoint to call, such as PJSIP/1000
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Number is the number to call, in place of an endpoint
+	Number string `json:"number,omitempty"`
+
+	// CallerID is the caller ID presented to the callee
+	CallerID string `json:"callerId,omitempty"`
+
+	// Target is the app to which the callee is connected: TargetVoice, the
+	// default, or TargetIVR
+	Target string `json:"target,omitempty"`
+
+	// IVR is the ARI application of the IVR, for TargetIVR
+	IVR string `json:"ivr,omitempty"`
+
+	// Message is spoken to the callee by the voice service before anything
+	// else
+	Message string `json:"message,omitempty"`
+
+	// Language is the language of the call, if known
+	Language string `json:"language,omitempty"`
+
+	// Timeout is the number of seconds for which the call rings before it
+	// is given up
+	Timeout int `json:"timeout,omitempty"`
+
+	// Wait asks for the reply to be sent once the call has ended, rather
+	// than once it has been placed
+	Wait bool `json:"wait,omitempty"`
+
+	// Alert asks the voice service only to read the message to the callee
+	// and take their answer: AlertAcknowledge or AlertEscalate
+	Alert bool `json:"alert,omitempty"`
+}
+
+// Keys by which the callee of an alert answers it
+const (
+	// AlertAcknowledge acknowledges the alert
+	AlertAcknowledge = "1"
+
+	// AlertEscalate passes the alert to the next person
+	AlertEscalate = "2"
+)
+
+// OutboundCall is the progress of an outbound call
+type OutboundCall struct {
+	// ID is the ID of the call, which is also the ID of its channel
+	ID string `json:"id"`
+
+	// Request is the request by which the call was placed
+	Request OriginateRequest `json:"request"`
+
+	// State is the state of the call, such as StateRinging
+	State string `json:"state"`
+
+	// Cause is the Q.850 cause with which the call was hung up, if it has
+	// been
+	Cause int `json:"cause,omitempty"`
+
+	// Error describes the failure of the call, if it failed
+	Error string `json:"error,omitempty"`
+
+	// Digits are the keys pressed by the callee
+	Digits string `json:"digits,omitempty"`
+
+	// Created, Answered and Ended are the times at which the call was
+	// placed, answered and ended
+	Created  time.Time  `json:"created"`
+	Answered *time.Time `json:"answered,omitempty"`
+	Ended    *time.Time `json:"ended,omitempty"`
+}
+
+// Done indicates that the call has ended
+func (c *OutboundCall) Done() bool {
+	switch c.State {
+	case StateCompleted, StateBusy, StateNoAnswer, StateFailed:
+		return true
+	}
+	return false
+}
+
+// OriginateReply is the result of an OriginateRequest
+type OriginateReply struct {
+	// Call is the progress of the call
+	Call *OutboundCall `json:"call,omitempty"`
+
+	// Error describes the failure of the request, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// OutboundSubject returns the NATS subject for the progress of the given
+// outbound call
+func OutboundSubject(id string) string {
+	return OutboundSubjectPrefix + id
+}
+
+// Originate asks the ARI apps to place an outbound call, returning its
+// progress once it has been placed, or if req.Wait is set, once it has
+// ended.  When waiting, the context should allow for the whole call.
+func (c *Client) Originate(ctx context.Context, req *OriginateRequest) (*OutboundCall, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode outbound call request")
+	}
+
+	if _, ok := ctx.Deadline(); !ok && !req.Wait {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	msg, err := c.nc.RequestWithContext(ctx, OriginateSubject, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send outbound call request")
+	}
+
+	reply := new(OriginateReply)
+	if err = json.Unmarshal(msg.Data, reply); err != nil {
+		return nil, errors.Wrap(err, "failed to decode reply")
+	}
+	if reply.Error != "" {
+		return reply.Call, errors.Errorf("outbound call failed: %s", reply.Error)
+	}
+	return reply.Call, nil
+}
+
+// SubscribeOriginate subscribes to outbound call requests, passing each to
+// the handler and replying with the progress of the call which it places.
+// Requests are handled concurrently, since they may wait for their calls to
+// end.  The caller must unsubscribe when it stops taking requests.
+func SubscribeOriginate(nc *nats.Conn, h func(req *OriginateRequest) (*OutboundCall, error)) (*nats.Subscription, error) {
+	return nc.QueueSubscribe(OriginateSubject, OriginateQueue, func(m *nats.Msg) {
+		go func() {
+			reply := new(OriginateReply)
+
+			req := new(OriginateRequest)
+			serve(nc, m, "request", req, reply, &reply.Error, func() (err error) {
+				reply.Call, err = h(req)
+				return err
+			})
+		}()
+	})
+}
@@ -0,0 +1,120 @@
+// Package dsp provides simple signal processing for the 16-bit signed linear
+// audio carried by AudioSocket.
+package dsp
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// SampleRate is the sample rate of AudioSocket audio
+const SampleRate = 8000
+
+// Samples decodes little-endian 16-bit signed linear audio
+func Samples(data []byte) []int16 {
+	out := make([]int16, len(data)/2)
+	for i := range out {
+		out[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+	return out
+}
+
+// Bytes encodes samples as little-endian 16-bit signed linear audio
+func Bytes(samples []int16) []byte {
+	out := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(out[2*i:], uint16(s))
+	}
+	return out
+}
+
+// Tone generates a sine wave of the given frequency and duration.  The
+// amplitude is a fraction of full scale.
+func Tone(freq float64, d time.Duration, amplitude float64) []int16 {
+	n := int(d.Seconds() * SampleRate)
+	out := make([]int16, n)
+	for i := range out {
+		out[i] = int16(amplitude * math.MaxInt16 * math.Sin(2*math.Pi*freq*float64(i)/SampleRate))
+	}
+	return out
+}
+
+// Energy returns the sum of the squares of the samples
+func Energy(samples []int16) float64 {
+	var e float64
+	for _, s := range samples {
+		e += float64(s) * float64(s)
+	}
+	return e
+}
+
+// Goertzel returns the power of the given frequency in the samples
+func Goertzel(samples []int16, freq float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*freq/SampleRate)
+
+	var s1, s2 float64
+	for _, x := range samples {
+		s0 := float64(x) + coeff*s1 - s2
+		s2, s1 = s1, s0
+	}
+	return s1*s1 + s2*s2 - coeff*s1*s2
+}
+
+// ToneRatio returns the fraction of the energy of the samples which is at
+// the given frequency.  It is close to 1 for a pure tone of that frequency.
+func ToneRatio(samples []int16, freq float64) float64 {
+	e := Energy(samples)
+	if e == 0 || len(samples) == 0 {
+		return 0
+	}
+	return 2 * Goertzel(samples, freq) / (float64(len(samples)) * e)
+}
+
+// Resample converts samples from one sample rate to another.  Audio is
+// upsampled by linear interpolation, and downsampled by averaging the
+// samples which fall within each output sample.  That boxcar average is the
+// only filtering: there is no anti-alias filter, so energy above the new
+// Nyquist frequency is attenuated but not removed, and folds back into the
+// output.  Both methods also roll off the top of the band.  It is adequate
+// for speech, but not for wideband audio.
+func Resample(samples []int16, from, to int) []int16 {
+	if from == to || from <= 0 || to <= 0 || len(samples) == 0 {
+		return samples
+	}
+
+	n := len(samples) * to / from
+	out := make([]int16, n)
+	step := float64(from) / float64(to)
+
+	if from > to {
+		for i := range out {
+			start := int(float64(i) * step)
+			end := int(float64(i+1) * step)
+			if end > len(samples) {
+				end = len(samples)
+			}
+			if end <= start {
+				end = start + 1
+			}
+			var sum int64
+			for _, s := range samples[start:end] {
+				sum += int64(s)
+			}
+			out[i] = int16(sum / int64(end-start))
+		}
+		return out
+	}
+
+	for i := range out {
+		pos := float64(i) * step
+		j := int(pos)
+		if j+1 >= len(samples) {
+			out[i] = samples[len(samples)-1]
+			continue
+		}
+		frac := pos - float64(j)
+		out[i] = int16(float64(samples[j])*(1-frac) + float64(samples[j+1])*frac)
+	}
+	return out
+}
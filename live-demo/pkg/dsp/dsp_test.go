@@ -0,0 +1,78 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestResampleLength(t *testing.T) {
+	tests := []struct {
+		n, from, to int
+		want        int
+	}{
+		{160, 8000, 16000, 320},
+		{320, 16000, 8000, 160},
+		{160, 8000, 8000, 160},
+		{441, 44100, 8000, 80},
+		{160, 8000, 48000, 960},
+		{1, 16000, 8000, 0},
+		{0, 8000, 16000, 0},
+		{160, 0, 16000, 160},
+	}
+	for _, tt := range tests {
+		if got := len(Resample(make([]int16, tt.n), tt.from, tt.to)); got != tt.want {
+			t.Errorf("%d samples from %d to %d: got %d, want %d", tt.n, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestResampleRoundTrip(t *testing.T) {
+	tests := []struct {
+		freq float64
+
+		// minEnergy is the fraction of the energy of the tone which must
+		// survive.  Both the interpolation and the boxcar average roll off
+		// towards the top of the band.
+		minEnergy float64
+	}{
+		{300, 0.95},
+		{1000, 0.85},
+		{2500, 0.45},
+	}
+	for _, tt := range tests {
+		in := Tone(tt.freq, 100*time.Millisecond, 0.5)
+		out := Resample(Resample(in, 8000, 16000), 16000, 8000)
+
+		if len(out) != len(in) {
+			t.Fatalf("%vHz: %d samples became %d", tt.freq, len(in), len(out))
+		}
+		if r := ToneRatio(out, tt.freq); r < 0.95 {
+			t.Errorf("%vHz: tone ratio %.3f after round trip", tt.freq, r)
+		}
+		if r := Energy(out) / Energy(in); r < tt.minEnergy || r > 1.01 {
+			t.Errorf("%vHz: energy ratio %.3f after round trip", tt.freq, r)
+		}
+	}
+}
+
+func TestResampleAliasing(t *testing.T) {
+	// A 6kHz tone at 16kHz is above the Nyquist frequency of 8kHz audio.
+	// The boxcar average attenuates it, but it folds back to 2kHz.
+	in := make([]int16, 1600)
+	for i := range in {
+		in[i] = int16(0.5 * math.MaxInt16 * math.Sin(2*math.Pi*6000*float64(i)/16000))
+	}
+	out := Resample(in, 16000, 8000)
+
+	r := Energy(out) / Energy(in[:2*len(out)]) * 2
+	if r > 0.25 {
+		t.Errorf("aliased tone kept %.3f of its power", r)
+	}
+	if r < 0.01 {
+		t.Errorf("aliased tone was removed (%.3f of its power kept)", r)
+	}
+	if tr := ToneRatio(out, 2000); tr < 0.9 {
+		t.Errorf("alias is not at 2kHz (tone ratio %.3f)", tr)
+	}
+}
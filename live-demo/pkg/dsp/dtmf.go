@@ -0,0 +1,118 @@
+package dsp
+
+// DTMFBlockSize is the number of samples over which DTMF tones are
+// detected.  At 8kHz, it places each DTMF frequency close to the centre of
+// a frequency bin.
+const DTMFBlockSize = 205
+
+// dtmfMinPower is the mean power below which a block is taken to be silent
+const dtmfMinPower = 1e4
+
+// dtmfMinRatio is the fraction of the energy of a block which must be in
+// its strongest row and column tones for it to be taken as a digit
+const dtmfMinRatio = 0.7
+
+// dtmfMaxTwist and dtmfMaxReverseTwist are the largest power ratios allowed
+// between the column and row tones (8dB) and the row and column tones
+// (4dB) of a digit
+const (
+	dtmfMaxTwist        = 6.3
+	dtmfMaxReverseTwist = 2.5
+)
+
+// dtmfMaxSecond is the largest power ratio allowed between the second
+// strongest and the strongest tones of a group (6dB)
+const dtmfMaxSecond = 0.25
+
+var dtmfRows = []float64{697, 770, 852, 941}
+
+var dtmfCols = []float64{1209, 1336, 1477, 1633}
+
+var dtmfKeys = [4][4]rune{
+	{'1', '2', '3', 'A'},
+	{'4', '5', '6', 'B'},
+	{'7', '8', '9', 'C'},
+	{'*', '0', '#', 'D'},
+}
+
+// DTMFDetector detects DTMF digits carried in-band in audio.  A digit is
+// reported once, when its tones have been present for two consecutive
+// blocks, and must be absent for two blocks before it may be reported
+// again.
+type DTMFDetector struct {
+	buf []int16
+
+	// last is the digit detected in the last block, or zero
+	last rune
+
+	// held is the digit which has been reported and is still held, or zero
+	held rune
+}
+
+// Detect processes the next samples of the audio, returning any digits
+// which were pressed
+func (d *DTMFDetector) Detect(samples []int16) (digits []rune) {
+	d.buf = append(d.buf, samples...)
+
+	var i int
+	for ; i+DTMFBlockSize <= len(d.buf); i += DTMFBlockSize {
+		digit := detectDTMF(d.buf[i : i+DTMFBlockSize])
+
+		if d.held != 0 && digit != d.held && d.last != d.held {
+			d.held = 0
+		}
+		if digit != 0 && digit == d.last && d.held == 0 {
+			digits = append(digits, digit)
+			d.held = digit
+		}
+		d.last = digit
+	}
+	d.buf = append(d.buf[:0], d.buf[i:]...)
+	return digits
+}
+
+// detectDTMF returns the digit whose tones are present in the block, or zero
+func detectDTMF(block []int16) rune {
+	e := Energy(block)
+	n := float64(len(block))
+	if e/n < dtmfMinPower {
+		return 0
+	}
+
+	row, rowPower, ok := strongest(block, dtmfRows)
+	if !ok {
+		return 0
+	}
+	col, colPower, ok := strongest(block, dtmfCols)
+	if !ok {
+		return 0
+	}
+
+	if 2*(rowPower+colPower)/(n*e) < dtmfMinRatio {
+		return 0
+	}
+	if colPower > dtmfMaxTwist*rowPower || rowPower > dtmfMaxReverseTwist*colPower {
+		return 0
+	}
+	return dtmfKeys[row][col]
+}
+
+// strongest returns the index and power of the strongest of the frequencies
+// in the block.  It fails if the strongest is not clearly stronger than the
+// others.
+func strongest(block []int16, freqs []float64) (int, float64, bool) {
+	var (
+		best          int
+		first, second float64
+	)
+	for i, f := range freqs {
+		p := Goertzel(block, f)
+		switch {
+		case p > first:
+			best, first, second = i, p, first
+		case p > second:
+			second = p
+		}
+	}
+	return best, first, second <= dtmfMaxSecond*first
+}
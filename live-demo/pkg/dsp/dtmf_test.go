@@ -0,0 +1,153 @@
+package dsp
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// dtmfTone generates the tones of the key at the given amplitude of each
+func dtmfTone(key rune, d time.Duration, amplitude float64) []int16 {
+	for r, row := range dtmfKeys {
+		for c, k := range row {
+			if k != key {
+				continue
+			}
+			low := Tone(dtmfRows[r], d, amplitude)
+			high := Tone(dtmfCols[c], d, amplitude)
+			for i := range low {
+				low[i] += high[i]
+			}
+			return low
+		}
+	}
+	panic("no such key: " + string(key))
+}
+
+// speech generates a voiced, speech-like signal: a fundamental of the given
+// frequency with falling harmonics, whose pitch wavers, over light noise
+func speech(f0 float64, d time.Duration, seed int64) []int16 {
+	rng := rand.New(rand.NewSource(seed))
+	out := make([]int16, int(d.Seconds()*SampleRate))
+
+	var phase float64
+	for i := range out {
+		f := f0 * (1 + 0.05*math.Sin(2*math.Pi*3*float64(i)/SampleRate))
+		phase += 2 * math.Pi * f / SampleRate
+
+		var v float64
+		for h := 1; h <= 15; h++ {
+			v += math.Sin(float64(h)*phase) / float64(h)
+		}
+		v = 0.2*v + 0.02*rng.NormFloat64()
+		out[i] = int16(math.MaxInt16 * math.Max(-1, math.Min(1, v)))
+	}
+	return out
+}
+
+// detect passes the samples to a new detector in 20ms frames
+func detect(samples []int16) string {
+	d := new(DTMFDetector)
+
+	var digits []rune
+	for len(samples) > 0 {
+		n := 160
+		if n > len(samples) {
+			n = len(samples)
+		}
+		digits = append(digits, d.Detect(samples[:n])...)
+		samples = samples[n:]
+	}
+	return string(digits)
+}
+
+func TestDetectDTMF(t *testing.T) {
+	for _, row := range dtmfKeys {
+		for _, key := range row {
+			if got := detectDTMF(dtmfTone(key, 30*time.Millisecond, 0.3)[:DTMFBlockSize]); got != key {
+				t.Errorf("key %c: detected %q", key, got)
+			}
+		}
+	}
+}
+
+func TestDetectDTMFRejects(t *testing.T) {
+	dialTone := Tone(350, 30*time.Millisecond, 0.3)
+	for i, s := range Tone(440, 30*time.Millisecond, 0.3) {
+		dialTone[i] += s
+	}
+
+	// A digit buried in speech
+	mixed := speech(150, 30*time.Millisecond, 1)
+	for i, s := range dtmfTone('5', 30*time.Millisecond, 0.05) {
+		mixed[i] += s
+	}
+
+	tests := []struct {
+		name  string
+		block []int16
+	}{
+		{"silence", make([]int16, DTMFBlockSize)},
+		{"quiet", dtmfTone('5', 30*time.Millisecond, 0.001)},
+		{"single tone", Tone(dtmfRows[0], 30*time.Millisecond, 0.3)},
+		{"two rows", func() []int16 {
+			out := Tone(dtmfRows[0], 30*time.Millisecond, 0.3)
+			for i, s := range Tone(dtmfRows[2], 30*time.Millisecond, 0.3) {
+				out[i] += s
+			}
+			return out
+		}()},
+		{"dial tone", dialTone},
+		{"speech", speech(120, 30*time.Millisecond, 2)},
+		{"digit under speech", mixed},
+	}
+	for _, tt := range tests {
+		if got := detectDTMF(tt.block[:DTMFBlockSize]); got != 0 {
+			t.Errorf("%s: detected %q", tt.name, got)
+		}
+	}
+}
+
+func TestDTMFDetector(t *testing.T) {
+	silence := func(d time.Duration) []int16 {
+		return make([]int16, int(d.Seconds()*SampleRate))
+	}
+	presses := func(keys string, on, off time.Duration) []int16 {
+		var out []int16
+		for _, k := range keys {
+			out = append(out, dtmfTone(k, on, 0.3)...)
+			out = append(out, silence(off)...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name    string
+		samples []int16
+		want    string
+	}{
+		{"all keys", presses("123A456B789C*0#D", 100*time.Millisecond, 60*time.Millisecond), "123A456B789C*0#D"},
+		{"long press", presses("7", 2*time.Second, 0), "7"},
+		{"repeated key", presses("000", 80*time.Millisecond, 60*time.Millisecond), "000"},
+		{"too short", presses("1", 20*time.Millisecond, 100*time.Millisecond), ""},
+		{"silence", silence(2 * time.Second), ""},
+		{"speech", func() []int16 {
+			var out []int16
+			for i, f0 := range []float64{100, 120, 150, 180, 220, 260} {
+				out = append(out, speech(f0, time.Second, int64(i))...)
+			}
+			return out
+		}(), ""},
+		{"digits between speech", func() []int16 {
+			out := speech(120, time.Second, 7)
+			out = append(out, presses("42", 100*time.Millisecond, 60*time.Millisecond)...)
+			return append(out, speech(200, time.Second, 8)...)
+		}(), "42"},
+	}
+	for _, tt := range tests {
+		if got := detect(tt.samples); got != tt.want {
+			t.Errorf("%s: detected %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+module github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg
+
+go 1.12
+
+require (
+	github.com/CyCoreSystems/ari v5.0.0-pre5+incompatible
+	github.com/CyCoreSystems/audiosocket v0.2.0
+	github.com/go-redis/redis v6.15.2+incompatible
+	github.com/gofrs/uuid v3.2.0+incompatible
+	github.com/nats-io/nats.go v1.8.1
+	github.com/pkg/errors v0.8.1
+	google.golang.org/genproto v0.0.0-20191009194640-548a555dbc03
+	gopkg.in/yaml.v2 v2.2.4
+)
@@ -0,0 +1,275 @@
+// Package ivr implements declarative IVR definitions and a generic engine
+// which executes them over any media channel.
+//
+// A Definition is a set of named nodes.  Each node may run an action, play
+// prompts, collect input (DTMF or speech), and transition to another node
+// based on that input.  Definitions are written in YAML (or JSON), so menus
+// may be changed through a ConfigMap without rebuilding images.
+package ivr
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Definition describes a complete IVR
+type Definition struct {
+	// Start is the name of the node at which execution begins
+	Start string `yaml:"start" json:"start"`
+
+	// Nodes is the set of nodes, keyed by name
+	Nodes map[string]*Node `yaml:"nodes" json:"nodes"`
+}
+
+// Node describes a single step of an IVR
+type Node struct {
+	// Action is an optional action which is executed when the node is
+	// entered, before any prompts are played
+	Action *Action `yaml:"action,omitempty" json:"action,omitempty"`
+
+	// Prompts are played to the caller in order
+	Prompts []Prompt `yaml:"prompts,omitempty" json:"prompts,omitempty"`
+
+	// Input describes the input to be collected from the caller.  If it is
+	// nil, no input is collected and execution proceeds to Next.
+	Input *Input `yaml:"input,omitempty" json:"input,omitempty"`
+
+	// Timeout is the maximum time to wait for input
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+
+	// Retries is the number of times the input of this node may fail (no
+	// input or no match) before execution proceeds to Exhausted.  Zero means
+	// unlimited.
+	Retries int `yaml:"retries,omitempty" json:"retries,omitempty"`
+
+	// Transitions select the next node based on the collected input.  The
+	// first matching transition wins.
+	Transitions []Transition `yaml:"transitions,omitempty" json:"transitions,omitempty"`
+
+	// NoInput is the node to go to if no input was received.  If empty, the
+	// current node is repeated.
+	NoInput string `yaml:"noInput,omitempty" json:"noInput,omitempty"`
+
+	// NoMatch is the node to go to if the input matched no transition.  If
+	// empty, the current node is repeated.
+	NoMatch string `yaml:"noMatch,omitempty" json:"noMatch,omitempty"`
+
+	// Visits is the number of times a call may enter this node.  Once they
+	// are used up, execution proceeds to Exhausted instead.  Zero means
+	// unlimited.  This bounds loops, such as a rejected entry leading back
+	// to the prompt for it, which pass through nodes without input.
+	Visits int `yaml:"visits,omitempty" json:"visits,omitempty"`
+
+	// Exhausted is the node to go to once the retries or visits of this node
+	// are used up.  If empty, execution ends with ErrTooManyRetries.
+	Exhausted string `yaml:"exhausted,omitempty" json:"exhausted,omitempty"`
+
+	// Error is the node to go to if the channel fails to play prompts or
+	// collect input.  If empty, execution ends with the error.
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+
+	// Next is the node to go to after a node without input has completed.
+	// If empty, execution ends.
+	Next string `yaml:"next,omitempty" json:"next,omitempty"`
+}
+
+// Prompt describes a single item of audio to be played to the caller.
+// Exactly one of Sound or Text should be set.  Both are templates, rendered
+// against the variables of the call.
+type Prompt struct {
+	// Sound is a media URI (e.g. "sound:hello" or "number:{{.count}}")
+	Sound string `yaml:"sound,omitempty" json:"sound,omitempty"`
+
+	// Text is text to be synthesized by a TTS engine.  It may be an SSML
+	// document, enclosed in a speak element.
+	Text string `yaml:"text,omitempty" json:"text,omitempty"`
+
+	// Language is the language in which Text is spoken.  If it is empty, the
+	// language of the call is used.
+	Language string `yaml:"language,omitempty" json:"language,omitempty"`
+}
+
+// Input describes the input to be collected from the caller
+type Input struct {
+	// Var is the name of the call variable in which the input is stored
+	Var string `yaml:"var,omitempty" json:"var,omitempty"`
+
+	// Terminator is the DTMF key which ends digit entry.  It is not
+	// included in the stored input.
+	Terminator string `yaml:"terminator,omitempty" json:"terminator,omitempty"`
+
+	// MaxDigits is the number of DTMF digits after which entry ends
+	MaxDigits int `yaml:"maxDigits,omitempty" json:"maxDigits,omitempty"`
+
+	// Intents maps intent names to the phrases which indicate them in
+	// recognized speech
+	Intents map[string][]string `yaml:"intents,omitempty" json:"intents,omitempty"`
+}
+
+// Transition describes a path from one node to another.  Exactly one of
+// DTMF, Pattern or Intent should be set.
+type Transition struct {
+	// DTMF matches the exact digits entered
+	DTMF string `yaml:"dtmf,omitempty" json:"dtmf,omitempty"`
+
+	// Pattern is a regular expression which the entered digits must match
+	Pattern string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+
+	// Intent matches recognized speech which contains any phrase of the
+	// named intent
+	Intent string `yaml:"intent,omitempty" json:"intent,omitempty"`
+
+	// Next is the node to go to
+	Next string `yaml:"next" json:"next"`
+
+	pattern *regexp.Regexp
+}
+
+// Action describes an action to be executed by a node
+type Action struct {
+	// Name is the name of the registered action
+	Name string `yaml:"name" json:"name"`
+
+	// Args are passed to the action.  Values are templates, rendered
+	// against the variables of the call.
+	Args map[string]string `yaml:"args,omitempty" json:"args,omitempty"`
+
+	// Outcomes maps the outcomes reported by the action to the node to go
+	// to.  Unmapped outcomes continue with the prompts of the node.
+	Outcomes map[string]string `yaml:"outcomes,omitempty" json:"outcomes,omitempty"`
+}
+
+// Parse parses and validates a YAML or JSON definition
+func Parse(data []byte) (*Definition, error) {
+	d := new(Definition)
+	if err := yaml.UnmarshalStrict(data, d); err != nil {
+		return nil, errors.Wrap(err, "failed to parse IVR definition")
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Validate checks that the definition is internally consistent and compiles
+// its patterns
+func (d *Definition) Validate() error {
+	if _, ok := d.Nodes[d.Start]; !ok {
+		return errors.Errorf("start node %q does not exist", d.Start)
+	}
+
+	ref := func(from, to string) error {
+		if to == "" {
+			return nil
+		}
+		if _, ok := d.Nodes[to]; !ok {
+			return errors.Errorf("node %q refers to unknown node %q", from, to)
+		}
+		return nil
+	}
+
+	for name, n := range d.Nodes {
+		if n == nil {
+			return errors.Errorf("node %q is empty", name)
+		}
+		for _, to := range []string{n.NoInput, n.NoMatch, n.Exhausted, n.Error, n.Next} {
+			if err := ref(name, to); err != nil {
+				return err
+			}
+		}
+		if n.Action != nil {
+			if n.Action.Name == "" {
+				return errors.Errorf("node %q has an action without a name", name)
+			}
+			for _, to := range n.Action.Outcomes {
+				if err := ref(name, to); err != nil {
+					return err
+				}
+			}
+		}
+		for i := range n.Transitions {
+			t := &n.Transitions[i]
+			if err := ref(name, t.Next); err != nil {
+				return err
+			}
+			if t.Pattern != "" {
+				var err error
+				if t.pattern, err = regexp.Compile(t.Pattern); err != nil {
+					return errors.Wrapf(err, "node %q has an invalid pattern", name)
+				}
+			}
+			if t.Intent != "" && (n.Input == nil || n.Input.Intents[t.Intent] == nil) {
+				return errors.Errorf("node %q refers to undefined intent %q", name, t.Intent)
+			}
+		}
+		if len(n.Transitions) > 0 && n.Input == nil {
+			return errors.Errorf("node %q has transitions but no input", name)
+		}
+	}
+	return nil
+}
+
+// Source loads a definition from a file, reloading it whenever the file
+// changes.  This allows a definition mounted from a ConfigMap to be updated
+// without restarting the application.
+type Source struct {
+	path     string
+	fallback []byte
+
+	mu      sync.Mutex
+	modTime time.Time
+	def     *Definition
+}
+
+// NewSource returns a Source for the definition at the given path.  If the
+// file does not exist, the fallback definition is used.
+func NewSource(path string, fallback []byte) *Source {
+	return &Source{
+		path:     path,
+		fallback: fallback,
+	}
+}
+
+// Definition returns the current definition.  If the file has changed but
+// no longer parses, the previous definition is retained and the error is
+// returned along with it.
+func (s *Source) Definition() (*Definition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		if s.def == nil || !s.modTime.IsZero() {
+			def, err := Parse(s.fallback)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse fallback definition")
+			}
+			s.def, s.modTime = def, time.Time{}
+		}
+		return s.def, nil
+	}
+	if err != nil {
+		return s.def, errors.Wrapf(err, "failed to read IVR definition %s", s.path)
+	}
+
+	if s.def != nil && info.ModTime().Equal(s.modTime) {
+		return s.def, nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return s.def, errors.Wrapf(err, "failed to read IVR definition %s", s.path)
+	}
+	def, err := Parse(data)
+	if err != nil {
+		return s.def, errors.Wrapf(err, "failed to load IVR definition %s", s.path)
+	}
+	s.def, s.modTime = def, info.ModTime()
+	return s.def, nil
+}
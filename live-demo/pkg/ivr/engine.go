@@ -0,0 +1,398 @@
+package ivr
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTimeout is the time to wait for input when a node does not specify
+// a timeout
+var DefaultTimeout = 5 * time.Second
+
+// SpeechVar is the call variable in which the most recent speech of the
+// caller is stored
+const SpeechVar = "speech"
+
+// ErrHangup indicates that the IVR has requested that the call be ended
+var ErrHangup = errors.New("hangup")
+
+// ErrTooManyRetries indicates that the caller failed to provide valid input
+// too many times
+var ErrTooManyRetries = errors.New("too many retries")
+
+// DigitMatch indicates the state of a DTMF digit string against the input
+// of a node
+type DigitMatch int
+
+const (
+	// DigitsIncomplete indicates that more digits are expected
+	DigitsIncomplete DigitMatch = iota
+
+	// DigitsComplete indicates that digit entry is complete
+	DigitsComplete
+
+	// DigitsInvalid indicates that the digits can never match
+	DigitsInvalid
+)
+
+// Channel is the media interface through which the engine interacts with a
+// caller.  Each media transport (ARI, AudioSocket, etc) provides its own
+// implementation.
+type Channel interface {
+	// Play plays the prompts to the caller
+	Play(ctx context.Context, prompts []Prompt) error
+
+	// Collect plays the prompts to the caller and collects their input.
+	// An empty Response indicates that no input was received.
+	Collect(ctx context.Context, prompts []Prompt, c *Collector) (*Response, error)
+}
+
+// Response is the input received from a caller
+type Response struct {
+	// DTMF is the digits entered, with any terminator removed
+	DTMF string
+
+	// Speech is the recognized speech
+	Speech string
+}
+
+// Empty indicates whether no input was received
+func (r *Response) Empty() bool {
+	return r == nil || (r.DTMF == "" && r.Speech == "")
+}
+
+// Collector describes the input which a Channel should collect
+type Collector struct {
+	// Timeout is the maximum time to wait for input
+	Timeout time.Duration
+
+	// Phrases are the phrases which may be spoken to select an intent.
+	// Channels which perform speech recognition may use these as hints.
+	Phrases []string
+
+	node *Node
+}
+
+// MatchDigits reports whether digit entry is complete, returning the digits
+// with any terminator removed.
+func (c *Collector) MatchDigits(pat string) (string, DigitMatch) {
+	in := c.node.Input
+
+	if in.Terminator != "" && strings.Contains(pat, in.Terminator) {
+		return strings.Split(pat, in.Terminator)[0], DigitsComplete
+	}
+
+	// End immediately on any exact match, so that single keys such as a
+	// cancel key do not need to be terminated.
+	var partial bool
+	for _, t := range c.node.Transitions {
+		if t.DTMF == "" {
+			continue
+		}
+		if t.DTMF == pat {
+			return pat, DigitsComplete
+		}
+		if strings.HasPrefix(t.DTMF, pat) {
+			partial = true
+		}
+	}
+
+	if in.MaxDigits > 0 {
+		if len(pat) >= in.MaxDigits {
+			return pat[:in.MaxDigits], DigitsComplete
+		}
+		return pat, DigitsIncomplete
+	}
+	if in.Terminator != "" || partial {
+		return pat, DigitsIncomplete
+	}
+	return pat, DigitsInvalid
+}
+
+// ActionFunc executes a named action for a call.  It returns an outcome,
+// which may select the next node through the Outcomes of the Action.
+type ActionFunc func(ctx context.Context, call *Call, args map[string]string) (outcome string, err error)
+
+// Call holds the state of an IVR execution for a single call
+type Call struct {
+	// ID identifies the call in logs
+	ID string
+
+	// Vars holds the variables of the call, which may be used in prompts
+	// and action arguments
+	Vars map[string]string
+
+	// Node is the name of the current node.  If it is set when the call is
+	// run, execution resumes at that node instead of the start node.
+	Node string
+
+	tries  map[string]int
+	visits map[string]int
+}
+
+// Observer is notified as a call enters each node, with a nil Response, and
+// as input is received in the current node
+type Observer func(ctx context.Context, call *Call, resp *Response)
+
+// Engine executes an IVR definition for a call
+type Engine struct {
+	def       *Definition
+	ch        Channel
+	actions   map[string]ActionFunc
+	observers []Observer
+}
+
+// NewEngine returns an Engine which runs the given definition over the
+// given channel.  The built-in "hangup" and "set" actions are registered.
+func NewEngine(def *Definition, ch Channel) *Engine {
+	e := &Engine{
+		def:     def,
+		ch:      ch,
+		actions: make(map[string]ActionFunc),
+	}
+
+	e.Register("hangup", func(context.Context, *Call, map[string]string) (string, error) {
+		return "", ErrHangup
+	})
+	e.Register("set", func(_ context.Context, call *Call, args map[string]string) (string, error) {
+		for k, v := range args {
+			call.Vars[k] = v
+		}
+		return "", nil
+	})
+	return e
+}
+
+// Register registers an action under the given name
+func (e *Engine) Register(name string, f ActionFunc) {
+	e.actions[name] = f
+}
+
+// Observe registers an Observer of the progress of calls
+func (e *Engine) Observe(o Observer) {
+	e.observers = append(e.observers, o)
+}
+
+// Run executes the definition until it ends, the caller hangs up, or the
+// context is cancelled.  Execution starts at the start node, or at the
+// current node of the call if it is being resumed.
+func (e *Engine) Run(ctx context.Context, call *Call) error {
+	if call.Vars == nil {
+		call.Vars = make(map[string]string)
+	}
+	call.tries = make(map[string]int)
+	call.visits = make(map[string]int)
+
+	start := e.def.Start
+	if call.Node != "" {
+		start = call.Node
+	}
+
+	var err error
+	for name := start; name != ""; {
+		if ctx.Err() != nil {
+			return nil
+		}
+		call.Node = name
+		e.notify(ctx, call, nil)
+		if name, err = e.step(ctx, call, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) notify(ctx context.Context, call *Call, resp *Response) {
+	for _, o := range e.observers {
+		o(ctx, call, resp)
+	}
+}
+
+// step executes a single node, returning the name of the next node
+func (e *Engine) step(ctx context.Context, call *Call, name string) (string, error) {
+	n, ok := e.def.Nodes[name]
+	if !ok {
+		return "", errors.Errorf("unknown node %q", name)
+	}
+	log.Printf("%s: entering IVR node %s", call.ID, name)
+
+	if n.Visits > 0 {
+		call.visits[name]++
+		if call.visits[name] > n.Visits {
+			log.Printf("%s: IVR node %s visited too many times", call.ID, name)
+			if n.Exhausted == "" {
+				return "", ErrTooManyRetries
+			}
+			return n.Exhausted, nil
+		}
+	}
+
+	if n.Action != nil {
+		next, err := e.runAction(ctx, call, n.Action)
+		if err != nil {
+			return "", err
+		}
+		if next != "" {
+			return next, nil
+		}
+	}
+
+	prompts, err := e.render(call, n.Prompts)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to render prompts of node %q", name)
+	}
+
+	if n.Input == nil {
+		if err := e.ch.Play(ctx, prompts); err != nil {
+			return e.fail(n, name, errors.Wrap(err, "failed to play prompts"))
+		}
+		return n.Next, nil
+	}
+
+	c := &Collector{
+		Timeout: n.Timeout,
+		node:    n,
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+	for _, phrases := range n.Input.Intents {
+		c.Phrases = append(c.Phrases, phrases...)
+	}
+
+	resp, err := e.ch.Collect(ctx, prompts, c)
+	if err != nil {
+		return e.fail(n, name, errors.Wrap(err, "failed to collect input"))
+	}
+	if resp.Empty() {
+		return e.retry(call, n, name, n.NoInput)
+	}
+	if resp.Speech != "" {
+		call.Vars[SpeechVar] = resp.Speech
+	}
+	e.notify(ctx, call, resp)
+
+	for _, t := range n.Transitions {
+		if !t.matches(n.Input, resp) {
+			continue
+		}
+
+		delete(call.tries, name)
+		if n.Input.Var != "" {
+			if t.Intent != "" {
+				call.Vars[n.Input.Var] = t.Intent
+			} else {
+				call.Vars[n.Input.Var] = resp.DTMF
+			}
+		}
+		return t.Next, nil
+	}
+
+	log.Printf("%s: no match for input %+v in IVR node %s", call.ID, *resp, name)
+	return e.retry(call, n, name, n.NoMatch)
+}
+
+func (e *Engine) runAction(ctx context.Context, call *Call, a *Action) (string, error) {
+	f, ok := e.actions[a.Name]
+	if !ok {
+		return "", errors.Errorf("unknown action %q", a.Name)
+	}
+
+	args := make(map[string]string, len(a.Args))
+	for k, v := range a.Args {
+		var err error
+		if args[k], err = e.expand(call, v); err != nil {
+			return "", errors.Wrapf(err, "failed to render argument %q of action %q", k, a.Name)
+		}
+	}
+
+	outcome, err := f(ctx, call, args)
+	if err == ErrHangup {
+		return "", err
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "action %q failed", a.Name)
+	}
+	return a.Outcomes[outcome], nil
+}
+
+// retry returns the node to which a failed input should lead
+func (e *Engine) retry(call *Call, n *Node, name, next string) (string, error) {
+	call.tries[name]++
+	if n.Retries > 0 && call.tries[name] >= n.Retries {
+		delete(call.tries, name)
+		if n.Exhausted == "" {
+			return "", ErrTooManyRetries
+		}
+		return n.Exhausted, nil
+	}
+	if next == "" {
+		return name, nil
+	}
+	return next, nil
+}
+
+// fail returns the error node of the node, if there is one, or the error
+func (e *Engine) fail(n *Node, name string, err error) (string, error) {
+	if n.Error == "" {
+		return "", errors.Wrapf(err, "node %q failed", name)
+	}
+	log.Printf("node %s failed: %v", name, err)
+	return n.Error, nil
+}
+
+func (e *Engine) render(call *Call, in []Prompt) ([]Prompt, error) {
+	out := make([]Prompt, len(in))
+	for i, p := range in {
+		var err error
+		if out[i].Sound, err = e.expand(call, p.Sound); err != nil {
+			return nil, err
+		}
+		if out[i].Text, err = e.expand(call, p.Text); err != nil {
+			return nil, err
+		}
+		if out[i].Language, err = e.expand(call, p.Language); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (e *Engine) expand(call *Call, in string) (string, error) {
+	if !strings.Contains(in, "{{") {
+		return in, nil
+	}
+
+	t, err := template.New("").Option("missingkey=zero").Parse(in)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, call.Vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (t *Transition) matches(in *Input, resp *Response) bool {
+	switch {
+	case t.DTMF != "":
+		return resp.DTMF == t.DTMF
+	case t.pattern != nil:
+		return resp.DTMF != "" && t.pattern.MatchString(resp.DTMF)
+	case t.Intent != "":
+		speech := strings.ToLower(resp.Speech)
+		for _, phrase := range in.Intents[t.Intent] {
+			if strings.Contains(speech, strings.ToLower(phrase)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package ivr
+
+import (
+	"context"
+	"testing"
+)
+
+// scriptedChannel answers each collection with the next of its responses,
+// and records the sounds played
+type scriptedChannel struct {
+	responses []string
+	played    []string
+}
+
+func (c *scriptedChannel) Play(ctx context.Context, prompts []Prompt) error {
+	for _, p := range prompts {
+		c.played = append(c.played, p.Sound)
+	}
+	return nil
+}
+
+func (c *scriptedChannel) Collect(ctx context.Context, prompts []Prompt, col *Collector) (*Response, error) {
+	if err := c.Play(ctx, prompts); err != nil {
+		return nil, err
+	}
+	if len(c.responses) == 0 {
+		return new(Response), nil
+	}
+	r := c.responses[0]
+	c.responses = c.responses[1:]
+	return &Response{DTMF: r}, nil
+}
+
+const visitsDefinition = `
+start: entry
+nodes:
+  entry:
+    prompts:
+      - sound: sound:enter
+    input:
+      var: count
+      maxDigits: 1
+    transitions:
+      - pattern: "^[0-9]$"
+        next: validate
+  validate:
+    action:
+      name: validate
+      args:
+        count: "{{.count}}"
+      outcomes:
+        invalid: rejected
+    next: accepted
+  rejected:
+    prompts:
+      - sound: sound:rejected
+    visits: 2
+    exhausted: failed
+    next: entry
+  accepted:
+    prompts:
+      - sound: sound:accepted
+  failed:
+    prompts:
+      - sound: sound:failed
+`
+
+func TestVisits(t *testing.T) {
+	def, err := Parse([]byte(visitsDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		responses []string
+		last      string
+		rejected  int
+	}{
+		{name: "accepted", responses: []string{"1", "5"}, last: "sound:accepted", rejected: 1},
+		{name: "exhausted", responses: []string{"1", "2", "3", "5"}, last: "sound:failed", rejected: 2},
+	}
+	for _, tt := range tests {
+		ch := &scriptedChannel{responses: tt.responses}
+		e := NewEngine(def, ch)
+		e.Register("validate", func(_ context.Context, _ *Call, args map[string]string) (string, error) {
+			if args["count"] != "5" {
+				return "invalid", nil
+			}
+			return "", nil
+		})
+
+		if err := e.Run(context.Background(), &Call{ID: tt.name}); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got := ch.played[len(ch.played)-1]; got != tt.last {
+			t.Errorf("%s: ended with %s, want %s", tt.name, got, tt.last)
+		}
+		var rejected int
+		for _, s := range ch.played {
+			if s == "sound:rejected" {
+				rejected++
+			}
+		}
+		if rejected != tt.rejected {
+			t.Errorf("%s: rejected %d times, want %d", tt.name, rejected, tt.rejected)
+		}
+	}
+}
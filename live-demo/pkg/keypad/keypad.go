@@ -0,0 +1,205 @@
+// Package keypad picks the DTMF digits which a caller presses out of their
+// AudioSocket connection.  Digits are taken from DTMF messages, where the
+// AudioSocket sends them, from events relayed by the ARI app, or failing
+// both, from the tones in the audio itself.
+package keypad
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/audio"
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/dsp"
+	"github.com/CyCoreSystems/audiosocket"
+	"github.com/pkg/errors"
+)
+
+// KindDTMF indicates an AudioSocket message which carries a DTMF digit.  It
+// is sent by versions of Asterisk newer than those for which the
+// audiosocket package was written.
+const KindDTMF audiosocket.Kind = 0x03
+
+// DigitBuffer is the number of digits which may be queued before further
+// digits are dropped
+var DigitBuffer = 32
+
+// Sources of digits
+const (
+	// SourceAudioSocket indicates digits from AudioSocket DTMF messages
+	SourceAudioSocket = "audiosocket"
+
+	// SourceARI indicates digits relayed from ARI events
+	SourceARI = "ari"
+
+	// SourceInBand indicates digits detected in the audio
+	SourceInBand = "inband"
+)
+
+// ErrTimeout indicates that digit entry timed out before it was complete
+var ErrTimeout = errors.New("digit entry timed out")
+
+// Conn wraps the AudioSocket connection of a call.  The messages read
+// through it are passed on unchanged, except DTMF messages, which are
+// consumed, while any digits they carry are queued.
+type Conn struct {
+	rw io.ReadWriter
+
+	digits chan rune
+
+	mu       sync.Mutex
+	pending  []byte
+	detector dsp.DTMFDetector
+
+	// source is the out-of-band source of digits, once one has been seen.
+	// In-band detection stops when digits arrive out-of-band, so that
+	// they are not reported twice.
+	source string
+}
+
+// New wraps the AudioSocket connection of a call
+func New(rw io.ReadWriter) *Conn {
+	return &Conn{
+		rw:     rw,
+		digits: make(chan rune, DigitBuffer),
+	}
+}
+
+// Rate implements audio.Rater, giving the sample rate of the wrapped
+// connection
+func (c *Conn) Rate() int {
+	return audio.RateOf(c.rw)
+}
+
+// Write implements io.Writer
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.rw.Write(p)
+}
+
+// Read implements io.Reader.  DTMF messages are consumed, and the audio of
+// each message is inspected for digits before it is returned.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.pending) == 0 {
+		m, err := audiosocket.NextMessage(c.rw)
+		if err != nil {
+			return 0, err
+		}
+
+		if m.Kind() == KindDTMF {
+			if m.ContentLength() > 0 {
+				c.queue(SourceAudioSocket, rune(m.Payload()[0]))
+			}
+			continue
+		}
+		if rate := audio.Rate(m.Kind()); rate > 0 && c.source == "" {
+			samples := dsp.Resample(dsp.Samples(m.Payload()), rate, dsp.SampleRate)
+			for _, d := range c.detector.Detect(samples) {
+				c.queue(SourceInBand, d)
+			}
+		}
+		c.pending = m
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Relay queues a digit received from the given out-of-band source, such as
+// SourceARI
+func (c *Conn) Relay(source string, digit rune) {
+	c.mu.Lock()
+	c.queue(source, digit)
+	c.mu.Unlock()
+}
+
+// queue queues a digit.  Once a digit has arrived from one out-of-band
+// source, digits from others are ignored.  It must be called with the lock
+// held.
+func (c *Conn) queue(source string, digit rune) {
+	if source != SourceInBand {
+		if c.source == "" {
+			c.source = source
+		}
+		if source != c.source {
+			return
+		}
+	}
+
+	select {
+	case c.digits <- digit:
+	default:
+		log.Println("dropping DTMF digit", string(digit))
+	}
+}
+
+// Digits returns the channel on which digits are delivered
+func (c *Conn) Digits() <-chan rune {
+	return c.digits
+}
+
+// Clear discards any queued digits
+func (c *Conn) Clear() {
+	for {
+		select {
+		case <-c.digits:
+		default:
+			return
+		}
+	}
+}
+
+// Discard reads and discards audio, so that digits continue to be
+// detected, until the context is closed or the connection fails
+func (c *Conn) Discard(ctx context.Context) error {
+	for ctx.Err() == nil {
+		m, err := audiosocket.NextMessage(c)
+		if err != nil {
+			return err
+		}
+		if m.Kind() == audiosocket.KindHangup {
+			return io.EOF
+		}
+	}
+	return nil
+}
+
+// Collect collects digits, starting with any given, until match reports
+// that entry is complete, allowing up to the given time between digits.
+// Audio is discarded meanwhile, so nothing else may read from the
+// connection until it returns.  ErrTimeout is returned with the digits
+// entered if entry times out.
+func (c *Conn) Collect(ctx context.Context, digits string, interDigit time.Duration, match func(digits string) bool) (string, error) {
+	dCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Discard(dCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for digits == "" || !match(digits) {
+		select {
+		case d := <-c.digits:
+			digits += string(d)
+		case err := <-done:
+			done <- err
+			if err == nil {
+				err = ctx.Err()
+			}
+			return digits, err
+		case <-time.After(interDigit):
+			return digits, ErrTimeout
+		case <-ctx.Done():
+			return digits, ctx.Err()
+		}
+	}
+	return digits, nil
+}
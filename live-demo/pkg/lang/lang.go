@@ -0,0 +1,189 @@
+// Package lang describes the languages in which the voice applications can
+// interact with callers, and resolves the language of each call.
+package lang
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Language describes a language in which a call may be conducted
+type Language struct {
+	// Code is the BCP-47 language code used for speech recognition and
+	// synthesis
+	Code string
+
+	// Name is the name of the language, in that language
+	Name string
+
+	// Clock24 indicates that times are spoken on the 24-hour clock
+	Clock24 bool
+
+	// Numbers maps number words to their values
+	Numbers map[string]int
+}
+
+// Base returns the base language subtag of the language (e.g. "en")
+func (l *Language) Base() string {
+	return base(l.Code)
+}
+
+// ParseCount finds the first number, written as digits or as a word, in the
+// given phrase
+func (l *Language) ParseCount(msg string) (int, error) {
+	for _, word := range strings.Fields(strings.ToLower(msg)) {
+		word = strings.Trim(word, ".,!?¡¿")
+
+		// Try direct number parsing
+		if count, err := strconv.Atoi(word); err == nil {
+			return count, nil
+		}
+
+		if count, ok := l.Numbers[word]; ok {
+			return count, nil
+		}
+	}
+	return 0, errors.New("failed to find count in message")
+}
+
+// English is US English
+var English = &Language{
+	Code: "en-US",
+	Name: "English",
+	Numbers: map[string]int{
+		"zero":  0,
+		"one":   1,
+		"two":   2,
+		"three": 3,
+		"four":  4,
+		"five":  5,
+		"six":   6,
+		"seven": 7,
+		"eight": 8,
+		"nine":  9,
+		"ten":   10,
+	},
+}
+
+// Spanish is Castilian Spanish
+var Spanish = &Language{
+	Code:    "es-ES",
+	Name:    "Español",
+	Clock24: true,
+	Numbers: map[string]int{
+		"cero":   0,
+		"uno":    1,
+		"una":    1,
+		"un":     1,
+		"dos":    2,
+		"tres":   3,
+		"cuatro": 4,
+		"cinco":  5,
+		"seis":   6,
+		"siete":  7,
+		"ocho":   8,
+		"nueve":  9,
+		"diez":   10,
+	},
+}
+
+// German is German as spoken in Germany
+var German = &Language{
+	Code:    "de-DE",
+	Name:    "Deutsch",
+	Clock24: true,
+	Numbers: map[string]int{
+		"null":   0,
+		"eins":   1,
+		"ein":    1,
+		"eine":   1,
+		"einen":  1,
+		"zwei":   2,
+		"drei":   3,
+		"vier":   4,
+		"fünf":   5,
+		"sechs":  6,
+		"sieben": 7,
+		"acht":   8,
+		"neun":   9,
+		"zehn":   10,
+	},
+}
+
+// All is the set of supported languages
+var All = []*Language{English, Spanish, German}
+
+// Lookup returns the supported language which best matches the given code.
+// An exact match is preferred, followed by a match of the base language (so
+// "es-MX" selects Spanish).  It returns nil if no language matches.
+func Lookup(code string) *Language {
+	if code == "" {
+		return nil
+	}
+	for _, l := range All {
+		if strings.EqualFold(l.Code, code) {
+			return l
+		}
+	}
+	for _, l := range All {
+		if l.Base() == base(code) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Default returns the language named by the DEFAULT_LANGUAGE environment
+// variable, or English.
+func Default() *Language {
+	if l := Lookup(os.Getenv("DEFAULT_LANGUAGE")); l != nil {
+		return l
+	}
+	return English
+}
+
+// Table maps dialed numbers (DNIS) to the languages of the calls made to them
+type Table map[string]*Language
+
+// ParseTable parses a table of the form "number=code,number=code"
+func ParseTable(s string) (Table, error) {
+	t := make(Table)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pieces := strings.SplitN(entry, "=", 2)
+		if len(pieces) != 2 {
+			return nil, errors.Errorf("invalid language table entry %q", entry)
+		}
+		l := Lookup(strings.TrimSpace(pieces[1]))
+		if l == nil {
+			return nil, errors.Errorf("unsupported language %q for number %s", pieces[1], pieces[0])
+		}
+		t[strings.TrimSpace(pieces[0])] = l
+	}
+	return t, nil
+}
+
+// Resolve determines the language of a call from, in order of preference,
+// the explicitly requested language code and the language assigned to the
+// dialed number.  It returns nil if neither identifies a supported language,
+// in which case the caller should be asked.
+func (t Table) Resolve(code, dnis string) *Language {
+	if l := Lookup(code); l != nil {
+		return l
+	}
+	return t[dnis]
+}
+
+func base(code string) string {
+	code = strings.ToLower(code)
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		return code[:i]
+	}
+	return code
+}
@@ -0,0 +1,108 @@
+// Package leg dispatches the channels which enter an ARI application to a
+// handler for the role which the channel plays in a call.  It is shared by
+// the ARI applications.
+//
+// The role of a channel is the first argument with which it entered the
+// application.  A channel which entered without arguments takes its role
+// from the LEG_ROLE channel variable, or else is a caller.
+package leg
+
+import (
+	"log"
+
+	"github.com/CyCoreSystems/ari"
+)
+
+// RoleVariable is the channel variable from which the role of a channel
+// which entered the application without arguments is taken
+const RoleVariable = "LEG_ROLE"
+
+// Role is the part which a channel plays in a call
+type Role string
+
+const (
+	// Caller is an inbound call, which the application should handle
+	Caller Role = "caller"
+
+	// Media is a media leg, such as an AudioSocket channel, originated by
+	// the application for a call
+	Media Role = "media"
+
+	// Transfer is an endpoint to which a caller is being transferred
+	Transfer Role = "transfer"
+
+	// Snoop is a channel which snoops on another channel of a call
+	Snoop Role = "snoop"
+
+	// Transcribe is a channel sent by the dialplan to be transcribed, which
+	// returns to the dialplan once a snoop is attached to it
+	Transcribe Role = "transcribe"
+
+	// Outbound is a call placed by the application
+	Outbound Role = "outbound"
+)
+
+// legacyRoles maps the arguments used before roles were introduced, which
+// may still be sent by older instances during an upgrade
+var legacyRoles = map[string]Role{
+	"noop": Media,
+}
+
+// Handler handles a channel which has entered the application
+type Handler func(h *ari.ChannelHandle, e *ari.StasisStart)
+
+// Dispatcher passes each channel which enters the application to the
+// handler of its role
+type Dispatcher struct {
+	handlers map[Role]Handler
+}
+
+// NewDispatcher returns a Dispatcher with no handlers
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		handlers: make(map[Role]Handler),
+	}
+}
+
+// Handle registers the handler of a role
+func (d *Dispatcher) Handle(r Role, h Handler) {
+	d.handlers[r] = h
+}
+
+// Ignore registers a role whose channels are managed by whoever created them,
+// so need no handling when they enter the application
+func (d *Dispatcher) Ignore(r Role) {
+	d.handlers[r] = nil
+}
+
+// Dispatch passes the channel to the handler of its role, in a goroutine of
+// its own so that long-running calls do not hold up other channels.
+// Channels whose role has no handler are hung up.
+func (d *Dispatcher) Dispatch(h *ari.ChannelHandle, e *ari.StasisStart) {
+	r := RoleOf(h, e)
+
+	f, ok := d.handlers[r]
+	if !ok {
+		log.Printf("rejecting channel %s with unknown role %q", h.ID(), r)
+		h.Hangup() // nolint: errcheck
+		return
+	}
+	if f == nil {
+		return
+	}
+	go f(h, e)
+}
+
+// RoleOf returns the role of a channel which has entered the application
+func RoleOf(h *ari.ChannelHandle, e *ari.StasisStart) Role {
+	if len(e.Args) > 0 && e.Args[0] != "" {
+		if r, ok := legacyRoles[e.Args[0]]; ok {
+			return r
+		}
+		return Role(e.Args[0])
+	}
+	if v, err := h.GetVariable(RoleVariable); err == nil && v != "" {
+		return Role(v)
+	}
+	return Caller
+}
@@ -0,0 +1,126 @@
+// Package load reports the number of active calls of each voice service pod
+// over NATS, so that the ARI app can send new calls to the least loaded
+// pod.
+package load
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// Subject is the NATS subject on which pods report their load
+const Subject = "audiosocket.load"
+
+// ReportInterval is the interval at which pods report their load
+var ReportInterval = 5 * time.Second
+
+// Report is the load of a voice service pod
+type Report struct {
+	// Service is the name of the voice service
+	Service string `json:"service"`
+
+	// Address is the IP address of the pod
+	Address string `json:"address"`
+
+	// Calls is the number of active calls of the pod
+	Calls int64 `json:"calls"`
+
+	// Time is the time at which the report was made
+	Time time.Time `json:"time"`
+}
+
+// Counter counts the active calls of a pod
+type Counter struct {
+	calls int64
+}
+
+// Start records the start of a call.  The returned function records its
+// end.
+func (c *Counter) Start() func() {
+	atomic.AddInt64(&c.calls, 1)
+	return func() {
+		atomic.AddInt64(&c.calls, -1)
+	}
+}
+
+// Calls returns the number of active calls
+func (c *Counter) Calls() int64 {
+	return atomic.LoadInt64(&c.calls)
+}
+
+// Run reports the load of the pod at each ReportInterval until the context
+// is cancelled
+func (c *Counter) Run(ctx context.Context, nc *nats.Conn, service string) {
+	addr := PodIP()
+	if addr == "" {
+		log.Println("not reporting load: failed to determine pod IP")
+		return
+	}
+
+	t := time.NewTicker(ReportInterval)
+	defer t.Stop()
+
+	for {
+		if err := publish(nc, &Report{
+			Service: service,
+			Address: addr,
+			Calls:   c.Calls(),
+			Time:    time.Now(),
+		}); err != nil {
+			log.Println("failed to report load:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func publish(nc *nats.Conn, r *Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode load report")
+	}
+	return nc.Publish(Subject, data)
+}
+
+// Subscribe calls the handler with each load report
+func Subscribe(nc *nats.Conn, h func(*Report)) (*nats.Subscription, error) {
+	return nc.Subscribe(Subject, func(m *nats.Msg) {
+		r := new(Report)
+		if err := json.Unmarshal(m.Data, r); err != nil {
+			log.Println("failed to decode load report:", err)
+			return
+		}
+		h(r)
+	})
+}
+
+// PodIP returns the IP address of the pod, from the POD_IP environment
+// variable or else from its network interfaces
+func PodIP() string {
+	if ip := os.Getenv("POD_IP"); ip != "" {
+		return ip
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			return n.IP.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,218 @@
+// Package metadata passes information about a call from the front-ends
+// which send it to an AudioSocket (the ARI app and the AGI handler) to the
+// voice services which receive it.  AudioSocket itself carries only the
+// UUID of the call, so the front-ends publish a record keyed by that UUID
+// before connecting the call, and the voice services look it up when the
+// AudioSocket connects.
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// DefaultRedisAddr is the address of the Redis server used when the
+// REDIS_ADDR environment variable is not set
+const DefaultRedisAddr = "redis:6379"
+
+// TTL is the time for which a record is kept
+const TTL = 15 * time.Minute
+
+// KeyPrefix is the prefix of the Redis keys of the records.  The full key is
+// the prefix followed by the AudioSocket UUID.
+const KeyPrefix = "audiosocket:metadata:"
+
+// LookupInterval is the interval at which Lookup retries a missing record
+var LookupInterval = 100 * time.Millisecond
+
+// ModeTranscribe is the Mode of a call which is an existing call, snooped
+// on by the ARI app, to be transcribed without being heard
+const ModeTranscribe = "transcribe"
+
+// ModeAlert is the Mode of an outbound alert call, whose callee is read the
+// Message and asked to acknowledge it or pass it on
+const ModeAlert = "alert"
+
+// ErrNotFound indicates that there is no record for the call
+var ErrNotFound = errors.New("metadata not found")
+
+// Metadata describes the call connected to an AudioSocket
+type Metadata struct {
+	// ID is the AudioSocket UUID of the call
+	ID string `json:"id"`
+
+	// CallerID is the number of the calling party
+	CallerID string `json:"callerid,omitempty"`
+
+	// CallerName is the name of the calling party
+	CallerName string `json:"callername,omitempty"`
+
+	// DNIS is the number which was dialed
+	DNIS string `json:"dnis,omitempty"`
+
+	// Channel is the ID of the caller's channel in Asterisk
+	Channel string `json:"channel,omitempty"`
+
+	// Node is the Asterisk node on which the call is handled
+	Node string `json:"node,omitempty"`
+
+	// Language is the language requested for the call, if any
+	Language string `json:"language,omitempty"`
+
+	// Message is spoken to the party before anything else, such as the
+	// reason for an outbound call
+	Message string `json:"message,omitempty"`
+
+	// SampleRate is the sample rate of the call's audio, if the front-end
+	// knows it
+	SampleRate int `json:"samplerate,omitempty"`
+
+	// Mode is the way in which the voice service should handle the call,
+	// such as ModeTranscribe or ModeAlert.  It is empty for ordinary calls.
+	Mode string `json:"mode,omitempty"`
+
+	// Transcript is the ID of the transcript to which a transcribed call
+	// contributes, shared by the legs which carry each of its parties
+	Transcript string `json:"transcript,omitempty"`
+
+	// Speaker is the party to a transcribed call whose speech the leg
+	// carries, such as "caller" or "callee"
+	Speaker string `json:"speaker,omitempty"`
+
+	// SpeakerID is the number of the speaker, if known
+	SpeakerID string `json:"speakerid,omitempty"`
+
+	// Vars are any further variables passed by the front-end
+	Vars map[string]string `json:"vars,omitempty"`
+
+	// Created is the time at which the record was published
+	Created time.Time `json:"created"`
+}
+
+// CallVars returns the metadata as call variables, for use by IVR
+// definitions
+func (m *Metadata) CallVars() map[string]string {
+	out := make(map[string]string, len(m.Vars)+6)
+	for k, v := range m.Vars {
+		out[k] = v
+	}
+	for k, v := range map[string]string{
+		"callerid":   m.CallerID,
+		"callername": m.CallerName,
+		"dnis":       m.DNIS,
+		"channel":    m.Channel,
+		"node":       m.Node,
+		"language":   m.Language,
+	} {
+		if v != "" {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Store stores call metadata
+type Store interface {
+	// Put publishes the metadata of a call
+	Put(ctx context.Context, m *Metadata) error
+
+	// Get returns the metadata of a call, or ErrNotFound
+	Get(ctx context.Context, id string) (*Metadata, error)
+
+	// Delete removes the metadata of a call
+	Delete(ctx context.Context, id string) error
+}
+
+// Lookup returns the metadata of a call, waiting for up to the given time
+// for it to be published
+func Lookup(ctx context.Context, s Store, id string, wait time.Duration) (*Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	for {
+		m, err := s.Get(ctx, id)
+		if err != ErrNotFound {
+			return m, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ErrNotFound
+		case <-time.After(LookupInterval):
+		}
+	}
+}
+
+// RedisStore stores call metadata in Redis
+type RedisStore struct {
+	c *redis.Client
+}
+
+// NewRedisStore returns a Store backed by the Redis server at the given
+// address
+func NewRedisStore(addr string) *RedisStore {
+	return &RedisStore{
+		c: redis.NewClient(&redis.Options{
+			Addr: addr,
+		}),
+	}
+}
+
+// NewRedisStoreFromEnv returns a Store backed by the Redis server named by
+// the REDIS_ADDR environment variable, or DefaultRedisAddr
+func NewRedisStoreFromEnv() *RedisStore {
+	return NewRedisStore(RedisAddr())
+}
+
+// RedisAddr returns the address of the Redis server given by the REDIS_ADDR
+// environment variable, or DefaultRedisAddr
+func RedisAddr() string {
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		return addr
+	}
+	return DefaultRedisAddr
+}
+
+// Put implements Store
+func (s *RedisStore) Put(ctx context.Context, m *Metadata) error {
+	if m.Created.IsZero() {
+		m.Created = time.Now()
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode metadata")
+	}
+	return errors.Wrap(s.c.WithContext(ctx).Set(KeyPrefix+m.ID, data, TTL).Err(), "failed to store metadata")
+}
+
+// Get implements Store
+func (s *RedisStore) Get(ctx context.Context, id string) (*Metadata, error) {
+	data, err := s.c.WithContext(ctx).Get(KeyPrefix + id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve metadata")
+	}
+
+	m := new(Metadata)
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrap(err, "failed to decode metadata")
+	}
+	return m, nil
+}
+
+// Delete implements Store
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	return errors.Wrap(s.c.WithContext(ctx).Del(KeyPrefix+id).Err(), "failed to delete metadata")
+}
+
+// Close closes the connection to Redis
+func (s *RedisStore) Close() error {
+	return s.c.Close()
+}
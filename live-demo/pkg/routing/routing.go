@@ -0,0 +1,334 @@
+// Package routing decides where the front-ends (the ARI app and the AGI
+// handler) send each call, from its dialed number (DNIS) and caller ID.
+//
+// The routing table is a YAML document, normally mounted from a ConfigMap,
+// which is reloaded whenever it changes:
+//
+//	routes:
+//	  - name: voice demo
+//	    dnis: "16786084209"
+//	    target:
+//	      audiosocket: voice-transscriber:8080
+//	  - name: scaler
+//	    dnisPattern: "^1888"
+//	    target:
+//	      ivr: demo
+//	  - name: blocked
+//	    calleridPattern: "^1900"
+//	    target:
+//	      context: inbound
+//	      extension: echo
+//	default:
+//	  audiosocket: audiosocket:8080
+//	fallback:
+//	  ivr: demo
+//
+// Routes are tried in order, and the first whose conditions all match is
+// used.  Calls whose AudioSocket leg cannot be connected are sent to the
+// fallback of their route, or else of the table, which must be an IVR or a
+// dialplan location.
+package routing
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultPath is the location of the routing table.  It may be overridden
+// by the ROUTES environment variable.
+const DefaultPath = "/etc/voice-routes/routes.yaml"
+
+// Kinds of target
+const (
+	// KindAudioSocket indicates a target which is an AudioSocket service
+	KindAudioSocket = "audiosocket"
+
+	// KindIVR indicates a target which is an ARI IVR application
+	KindIVR = "ivr"
+
+	// KindDialplan indicates a target which is a dialplan location
+	KindDialplan = "dialplan"
+)
+
+// Table is a routing table
+type Table struct {
+	// Routes are the routes, in the order in which they are tried
+	Routes []*Route `yaml:"routes"`
+
+	// Default is the target of calls which match no route.  If it is not
+	// set, each front-end uses its own default.
+	Default *Target `yaml:"default,omitempty"`
+
+	// Fallback is the target of calls whose AudioSocket leg fails, unless
+	// their route has its own.  If it is not set, such calls are hung up.
+	Fallback *Target `yaml:"fallback,omitempty"`
+}
+
+// Route sends the calls which match all of its conditions to its target
+type Route struct {
+	// Name identifies the route in logs
+	Name string `yaml:"name,omitempty"`
+
+	// DNIS is the dialed number which the call must have
+	DNIS string `yaml:"dnis,omitempty"`
+
+	// DNISPattern is a regular expression which the dialed number must
+	// match
+	DNISPattern string `yaml:"dnisPattern,omitempty"`
+
+	// CallerID is the caller ID which the call must have
+	CallerID string `yaml:"callerid,omitempty"`
+
+	// CallerIDPattern is a regular expression which the caller ID must
+	// match
+	CallerIDPattern string `yaml:"calleridPattern,omitempty"`
+
+	// Target is where matching calls are sent
+	Target *Target `yaml:"target"`
+
+	// Fallback is where matching calls are sent if their AudioSocket leg
+	// fails
+	Fallback *Target `yaml:"fallback,omitempty"`
+
+	dnisPattern     *regexp.Regexp
+	callerIDPattern *regexp.Regexp
+}
+
+// Target is a destination for calls.  Exactly one of AudioSocket, IVR or
+// Extension must be set.
+type Target struct {
+	// AudioSocket is the address (host:port) of an AudioSocket voice
+	// service
+	AudioSocket string `yaml:"audiosocket,omitempty"`
+
+	// IVR is the name of the ARI application of a DTMF IVR
+	IVR string `yaml:"ivr,omitempty"`
+
+	// Context, Extension and Priority are a dialplan location.  The context
+	// defaults to that of the call and the priority defaults to 1.
+	Context   string `yaml:"context,omitempty"`
+	Extension string `yaml:"extension,omitempty"`
+	Priority  int    `yaml:"priority,omitempty"`
+}
+
+// Kind returns the kind of the target
+func (t *Target) Kind() string {
+	switch {
+	case t.AudioSocket != "":
+		return KindAudioSocket
+	case t.IVR != "":
+		return KindIVR
+	default:
+		return KindDialplan
+	}
+}
+
+func (t *Target) validate() error {
+	if t == nil {
+		return errors.New("no target")
+	}
+	var n int
+	for _, s := range []string{t.AudioSocket, t.IVR, t.Extension} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("exactly one of audiosocket, ivr or extension must be set")
+	}
+	if t.Priority == 0 {
+		t.Priority = 1
+	}
+	return nil
+}
+
+// Parse parses a routing table
+func Parse(data []byte) (*Table, error) {
+	t := new(Table)
+	if err := yaml.UnmarshalStrict(data, t); err != nil {
+		return nil, errors.Wrap(err, "failed to parse routing table")
+	}
+
+	for i, r := range t.Routes {
+		if err := r.compile(); err != nil {
+			return nil, errors.Wrapf(err, "route %d (%s) is invalid", i, r.Name)
+		}
+	}
+	if t.Default != nil {
+		if err := t.Default.validate(); err != nil {
+			return nil, errors.Wrap(err, "default target is invalid")
+		}
+	}
+	if err := validateFallback(t.Fallback); err != nil {
+		return nil, errors.Wrap(err, "fallback target is invalid")
+	}
+	return t, nil
+}
+
+func (r *Route) compile() (err error) {
+	if r.DNIS == "" && r.DNISPattern == "" && r.CallerID == "" && r.CallerIDPattern == "" {
+		return errors.New("no conditions")
+	}
+	if r.DNISPattern != "" {
+		if r.dnisPattern, err = regexp.Compile(r.DNISPattern); err != nil {
+			return errors.Wrap(err, "invalid dnisPattern")
+		}
+	}
+	if r.CallerIDPattern != "" {
+		if r.callerIDPattern, err = regexp.Compile(r.CallerIDPattern); err != nil {
+			return errors.Wrap(err, "invalid calleridPattern")
+		}
+	}
+	if err := validateFallback(r.Fallback); err != nil {
+		return errors.Wrap(err, "invalid fallback")
+	}
+	return r.Target.validate()
+}
+
+func validateFallback(t *Target) error {
+	if t == nil {
+		return nil
+	}
+	if err := t.validate(); err != nil {
+		return err
+	}
+	if t.Kind() == KindAudioSocket {
+		return errors.New("fallback may not be an AudioSocket")
+	}
+	return nil
+}
+
+// Route returns the route of a call, or nil if the table has no route and no
+// default for it
+func (t *Table) Route(dnis, callerID string) *Route {
+	for _, r := range t.Routes {
+		if r.matches(dnis, callerID) {
+			return r
+		}
+	}
+	if t.Default != nil {
+		return &Route{
+			Name:   "default",
+			Target: t.Default,
+		}
+	}
+	return nil
+}
+
+// FallbackFor returns the fallback of the route, which may be nil, or else
+// the fallback of the table.  It returns nil if there is neither.
+func (t *Table) FallbackFor(r *Route) *Target {
+	if r != nil && r.Fallback != nil {
+		return r.Fallback
+	}
+	return t.Fallback
+}
+
+func (r *Route) matches(dnis, callerID string) bool {
+	switch {
+	case r.DNIS != "" && r.DNIS != dnis:
+		return false
+	case r.dnisPattern != nil && !r.dnisPattern.MatchString(dnis):
+		return false
+	case r.CallerID != "" && r.CallerID != callerID:
+		return false
+	case r.callerIDPattern != nil && !r.callerIDPattern.MatchString(callerID):
+		return false
+	}
+	return true
+}
+
+// Source loads a routing table from a file, reloading it whenever it
+// changes
+type Source struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	table   *Table
+}
+
+// NewSource returns a Source for the routing table at the given path
+func NewSource(path string) *Source {
+	return &Source{
+		path: path,
+	}
+}
+
+// FromEnv returns a Source for the routing table named by the ROUTES
+// environment variable, or DefaultPath
+func FromEnv() *Source {
+	path := os.Getenv("ROUTES")
+	if path == "" {
+		path = DefaultPath
+	}
+	return NewSource(path)
+}
+
+// Table returns the current routing table.  If the file does not exist, the
+// table is empty.  If the file has changed but no longer parses, the
+// previous table is retained and the error is returned along with it.
+func (s *Source) Table() (*Table, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.table, s.modTime = new(Table), time.Time{}
+		return s.table, nil
+	}
+	if err != nil {
+		return s.current(), errors.Wrapf(err, "failed to read routing table %s", s.path)
+	}
+
+	if s.table != nil && info.ModTime().Equal(s.modTime) {
+		return s.table, nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return s.current(), errors.Wrapf(err, "failed to read routing table %s", s.path)
+	}
+	t, err := Parse(data)
+	if err != nil {
+		return s.current(), errors.Wrapf(err, "failed to load routing table %s", s.path)
+	}
+	s.table, s.modTime = t, info.ModTime()
+	return s.table, nil
+}
+
+// Route returns the route of a call from the current routing table, or nil
+// if there is none
+func (s *Source) Route(dnis, callerID string) *Route {
+	t, err := s.Table()
+	if err != nil {
+		log.Println("using previous routing table:", err)
+	}
+	return t.Route(dnis, callerID)
+}
+
+// Fallback returns the fallback for calls on the route, which may be nil,
+// from the current routing table, or nil if there is none
+func (s *Source) Fallback(r *Route) *Target {
+	t, err := s.Table()
+	if err != nil {
+		log.Println("using previous routing table:", err)
+	}
+	return t.FallbackFor(r)
+}
+
+// current returns the current table, or an empty table if none has been
+// loaded.  It must be called with the lock held.
+func (s *Source) current() *Table {
+	if s.table == nil {
+		return new(Table)
+	}
+	return s.table
+}
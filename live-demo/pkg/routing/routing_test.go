@@ -0,0 +1,224 @@
+package routing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testTable = `
+routes:
+  - name: voice demo
+    dnis: "16786084209"
+    target:
+      audiosocket: voice-transscriber:8080
+    fallback:
+      context: inbound
+      extension: echo
+  - name: scaler
+    dnisPattern: "^1888"
+    target:
+      ivr: demo
+  - name: vip scaler
+    dnisPattern: "^1888"
+    callerid: "14045551234"
+    target:
+      ivr: vip
+  - name: local vip
+    dnis: "18005550100"
+    calleridPattern: "^1404"
+    target:
+      ivr: vip
+default:
+  audiosocket: audiosocket:8080
+fallback:
+  ivr: demo
+`
+
+func TestRoute(t *testing.T) {
+	table, err := Parse([]byte(testTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dnis     string
+		callerID string
+		want     string
+	}{
+		{"16786084209", "14045551234", "voice demo"},
+
+		// The first match wins, even though a later route is more specific
+		{"18882400309", "14045551234", "scaler"},
+		{"18882400309", "17705550000", "scaler"},
+
+		// A pattern combined with an exact field must match both
+		{"18005550100", "14045551234", "local vip"},
+		{"18005550100", "17705550000", "default"},
+		{"18005550199", "14045551234", "default"},
+
+		{"", "", "default"},
+	}
+	for _, tt := range tests {
+		r := table.Route(tt.dnis, tt.callerID)
+		if r == nil {
+			t.Errorf("%s from %s: no route", tt.dnis, tt.callerID)
+			continue
+		}
+		if r.Name != tt.want {
+			t.Errorf("%s from %s: routed by %q, want %q", tt.dnis, tt.callerID, r.Name, tt.want)
+		}
+	}
+
+	if r := table.Route("19995550000", ""); r.Target.AudioSocket != "audiosocket:8080" {
+		t.Errorf("default target is %+v", r.Target)
+	}
+}
+
+func TestRouteNoDefault(t *testing.T) {
+	table, err := Parse([]byte(`
+routes:
+  - dnis: "100"
+    target:
+      ivr: demo
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := table.Route("200", ""); r != nil {
+		t.Errorf("routed by %q, want no route", r.Name)
+	}
+}
+
+func TestFallbackFor(t *testing.T) {
+	table, err := Parse([]byte(testTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The route's own fallback overrides that of the table
+	f := table.FallbackFor(table.Route("16786084209", ""))
+	if f == nil || f.Kind() != KindDialplan || f.Extension != "echo" || f.Priority != 1 {
+		t.Errorf("voice demo fallback is %+v", f)
+	}
+
+	for _, r := range []*Route{table.Route("18882400309", ""), table.Route("100", ""), nil} {
+		f := table.FallbackFor(r)
+		if f == nil || f.IVR != "demo" {
+			t.Errorf("fallback of %+v is %+v", r, f)
+		}
+	}
+
+	if f := new(Table).FallbackFor(nil); f != nil {
+		t.Errorf("empty table has fallback %+v", f)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"audiosocket fallback": `
+fallback:
+  audiosocket: audiosocket:8080
+`,
+		"audiosocket route fallback": `
+routes:
+  - dnis: "100"
+    target:
+      ivr: demo
+    fallback:
+      audiosocket: audiosocket:8080
+`,
+		"no conditions": `
+routes:
+  - target:
+      ivr: demo
+`,
+		"no target": `
+routes:
+  - dnis: "100"
+`,
+		"two targets": `
+routes:
+  - dnis: "100"
+    target:
+      ivr: demo
+      extension: echo
+`,
+		"bad pattern": `
+routes:
+  - dnisPattern: "(1888"
+    target:
+      ivr: demo
+`,
+		"unknown field": `
+routes:
+  - dnis: "100"
+    target:
+      ivr: demo
+    priority: 1
+`,
+	}
+	for name, data := range tests {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	path := filepath.Join(dir, "routes.yaml")
+	s := NewSource(path)
+
+	// A missing table routes nothing
+	if r := s.Route("16786084209", ""); r != nil {
+		t.Errorf("missing table routed by %q", r.Name)
+	}
+
+	write := func(data string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+
+	write(testTable, start)
+	if r := s.Route("16786084209", ""); r == nil || r.Name != "voice demo" {
+		t.Fatalf("routed by %+v, want voice demo", r)
+	}
+
+	// A table which no longer parses leaves the previous one in place
+	write("routes: [", start.Add(time.Minute))
+	table, err := s.Table()
+	if err == nil {
+		t.Error("bad table loaded")
+	}
+	if r := table.Route("16786084209", ""); r == nil || r.Name != "voice demo" {
+		t.Errorf("after bad reload, routed by %+v, want voice demo", r)
+	}
+	if r := s.Route("18882400309", ""); r == nil || r.Name != "scaler" {
+		t.Errorf("after bad reload, routed by %+v, want scaler", r)
+	}
+
+	// A good table replaces it
+	write(`
+routes:
+  - dnis: "16786084209"
+    name: replaced
+    target:
+      ivr: demo
+`, start.Add(2*time.Minute))
+	if r := s.Route("16786084209", ""); r == nil || r.Name != "replaced" {
+		t.Errorf("after reload, routed by %+v, want replaced", r)
+	}
+}
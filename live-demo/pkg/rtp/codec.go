@@ -0,0 +1,201 @@
+package rtp
+
+import (
+	"encoding/binary"
+)
+
+// Payload types used by Asterisk for the formats it sends to ExternalMedia
+// channels
+const (
+	PayloadULaw   = 0
+	PayloadALaw   = 8
+	PayloadSlin16 = 118
+)
+
+// Format is an RTP payload format.  Formats convert between their payload
+// and the 16-bit little-endian signed linear audio used by the voice
+// services, at the clock rate of the format.
+type Format struct {
+	// Name is the Asterisk name of the format
+	Name string
+
+	// PayloadType is the RTP payload type of the format
+	PayloadType uint8
+
+	// ClockRate is the RTP clock rate of the format, which is also its
+	// sample rate
+	ClockRate int
+
+	// Decode converts a payload to signed linear audio
+	Decode func(payload []byte) []byte
+
+	// Encode converts signed linear audio to a payload
+	Encode func(slin []byte) []byte
+}
+
+// Formats are the supported payload formats
+var Formats = []*Format{
+	{Name: "ulaw", PayloadType: PayloadULaw, ClockRate: 8000, Decode: decodeULaw, Encode: encodeULaw},
+	{Name: "alaw", PayloadType: PayloadALaw, ClockRate: 8000, Decode: decodeALaw, Encode: encodeALaw},
+	{Name: "slin16", PayloadType: PayloadSlin16, ClockRate: 16000, Decode: decodeSlin16, Encode: encodeSlin16},
+}
+
+// FormatByName returns the format with the given Asterisk name, or nil
+func FormatByName(name string) *Format {
+	for _, f := range Formats {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// FormatByPayloadType returns the format with the given RTP payload type,
+// or nil
+func FormatByPayloadType(pt uint8) *Format {
+	for _, f := range Formats {
+		if f.PayloadType == pt {
+			return f
+		}
+	}
+	return nil
+}
+
+// samples returns the number of RTP clock ticks covered by the given signed
+// linear audio
+func (f *Format) samples(slin []byte) uint32 {
+	return uint32(len(slin) / 2)
+}
+
+func decodeULaw(payload []byte) []byte {
+	out := make([]byte, 2*len(payload))
+	for i, v := range payload {
+		binary.LittleEndian.PutUint16(out[2*i:], uint16(ulawToLinear(v)))
+	}
+	return out
+}
+
+func encodeULaw(slin []byte) []byte {
+	out := make([]byte, len(slin)/2)
+	for i := range out {
+		out[i] = linearToULaw(int16(binary.LittleEndian.Uint16(slin[2*i:])))
+	}
+	return out
+}
+
+func decodeALaw(payload []byte) []byte {
+	out := make([]byte, 2*len(payload))
+	for i, v := range payload {
+		binary.LittleEndian.PutUint16(out[2*i:], uint16(alawToLinear(v)))
+	}
+	return out
+}
+
+func encodeALaw(slin []byte) []byte {
+	out := make([]byte, len(slin)/2)
+	for i := range out {
+		out[i] = linearToALaw(int16(binary.LittleEndian.Uint16(slin[2*i:])))
+	}
+	return out
+}
+
+// decodeSlin16 converts big-endian signed linear audio, as carried by RTP,
+// to little-endian
+func decodeSlin16(payload []byte) []byte {
+	return swapBytes(payload)
+}
+
+// encodeSlin16 converts little-endian signed linear audio to big-endian
+func encodeSlin16(slin []byte) []byte {
+	return swapBytes(slin)
+}
+
+func swapBytes(in []byte) []byte {
+	out := make([]byte, len(in)&^1)
+	for i := 0; i+1 < len(in); i += 2 {
+		out[i], out[i+1] = in[i+1], in[i]
+	}
+	return out
+}
+
+// G.711 conversions, after the reference implementation by Sun
+// Microsystems
+
+const (
+	ulawBias = 0x84
+	ulawClip = 32635
+)
+
+func ulawToLinear(u byte) int16 {
+	u = ^u
+	t := (int32(u&0x0f) << 3) + ulawBias
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(ulawBias - t)
+	}
+	return int16(t - ulawBias)
+}
+
+func linearToULaw(s int16) byte {
+	v := int32(s)
+	var sign byte
+	if v < 0 {
+		v = -v
+		sign = 0x80
+	}
+	if v > ulawClip {
+		v = ulawClip
+	}
+	v += ulawBias
+
+	exp := byte(7)
+	for mask := int32(0x4000); v&mask == 0 && exp > 0; mask >>= 1 {
+		exp--
+	}
+	mantissa := byte(v>>(exp+3)) & 0x0f
+	return ^(sign | exp<<4 | mantissa)
+}
+
+func alawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int32(a&0x0f) << 4
+	seg := (a & 0x70) >> 4
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
+
+func linearToALaw(s int16) byte {
+	v := int32(s) >> 3
+	mask := byte(0xd5)
+	if v < 0 {
+		mask = 0x55
+		v = -v - 1
+	}
+
+	var seg byte
+	for end := int32(0x1f); seg < 8 && v > end; end = end<<1 | 1 {
+		seg++
+	}
+	if seg >= 8 {
+		return 0x7f ^ mask
+	}
+
+	a := seg << 4
+	if seg < 2 {
+		a |= byte(v>>1) & 0x0f
+	} else {
+		a |= byte(v>>seg) & 0x0f
+	}
+	return a ^ mask
+}
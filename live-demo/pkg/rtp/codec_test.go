@@ -0,0 +1,91 @@
+package rtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// slin encodes samples as little-endian signed linear audio
+func slin(samples ...int16) []byte {
+	out := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(out[2*i:], uint16(s))
+	}
+	return out
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	var samples []int16
+	for v := math.MinInt16; v <= math.MaxInt16; v += 7 {
+		samples = append(samples, int16(v))
+	}
+	samples = append(samples, 0, 1, -1, math.MaxInt16)
+	in := slin(samples...)
+
+	tests := []struct {
+		format string
+
+		// maxError is the largest error allowed, as a fraction of the
+		// magnitude of the sample, beyond the smallest step of the format
+		maxError float64
+		minStep  int
+	}{
+		{"ulaw", 1.0 / 16, 4},
+		{"alaw", 1.0 / 16, 16},
+		{"slin16", 0, 0},
+	}
+	for _, tt := range tests {
+		f := FormatByName(tt.format)
+		if f == nil {
+			t.Fatalf("no format %s", tt.format)
+		}
+		if FormatByPayloadType(f.PayloadType) != f {
+			t.Errorf("%s: payload type %d does not find the format", f.Name, f.PayloadType)
+		}
+
+		out := f.Decode(f.Encode(in))
+		if len(out) != len(in) {
+			t.Errorf("%s: %d bytes out for %d in", f.Name, len(out), len(in))
+			continue
+		}
+		for i, s := range samples {
+			got := int16(binary.LittleEndian.Uint16(out[2*i:]))
+			diff := math.Abs(float64(got) - float64(s))
+			if diff > float64(tt.minStep)+tt.maxError*math.Abs(float64(s)) {
+				t.Errorf("%s: %d became %d", f.Name, s, got)
+				break
+			}
+		}
+	}
+}
+
+func TestG711CodesRoundTrip(t *testing.T) {
+	for c := 0; c < 256; c++ {
+		u := byte(c)
+		// 0x7f is the negative zero of µ-law, which encodes as 0xff
+		if u != 0x7f {
+			if got := linearToULaw(ulawToLinear(u)); got != u {
+				t.Errorf("ulaw %#x became %#x", u, got)
+			}
+		}
+		if got := linearToALaw(alawToLinear(u)); got != u {
+			t.Errorf("alaw %#x became %#x", u, got)
+		}
+	}
+}
+
+func TestSlin16ByteOrder(t *testing.T) {
+	in := slin(0x0102, -2)
+	payload := encodeSlin16(in)
+	if want := []byte{0x01, 0x02, 0xff, 0xfe}; !bytes.Equal(payload, want) {
+		t.Errorf("encoded %v, want big-endian %v", payload, want)
+	}
+	if out := decodeSlin16(payload); !bytes.Equal(out, in) {
+		t.Errorf("decoded %v, want %v", out, in)
+	}
+	if out := decodeSlin16([]byte{1, 2, 3}); len(out) != 2 {
+		t.Errorf("odd payload decoded to %d bytes", len(out))
+	}
+}
@@ -0,0 +1,276 @@
+package rtp
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/audio"
+	"github.com/CyCoreSystems/audiosocket"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+// Conn is a single RTP stream, presented as an AudioSocket connection
+type Conn struct {
+	l      *Listener
+	remote net.Addr
+
+	packets chan *Packet
+	jitter  jitterBuffer
+
+	// ready are the AudioSocket messages waiting to be read, and rbuf the
+	// unread part of the current one
+	ready [][]byte
+	rbuf  []byte
+
+	// wbuf holds the incomplete AudioSocket message written so far
+	wbuf []byte
+
+	// id is the call ID of the stream, once it has been looked up, and
+	// format the format of the received audio, in which audio is also
+	// sent.  Both are set by the reader and used by the writer.
+	mu     sync.Mutex
+	id     string
+	format *Format
+
+	ssrc uint32
+	seq  uint16
+	ts   uint32
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConn(l *Listener, remote net.Addr) *Conn {
+	return &Conn{
+		l:       l,
+		remote:  remote,
+		packets: make(chan *Packet, packetQueueSize),
+		ssrc:    rand.Uint32(),
+		seq:     uint16(rand.Uint32()),
+		ts:      rand.Uint32(),
+		done:    make(chan struct{}),
+	}
+}
+
+// InitialRate returns the sample rate expected of the call on the
+// connection until its front-end or its audio says otherwise: the given
+// rate for RTP streams, and audio.DefaultRate for AudioSocket connections,
+// since Asterisk 16 only sends 8kHz audio over AudioSocket and rejects
+// messages of any other rate.
+func InitialRate(c net.Conn, rate int) int {
+	if _, ok := c.(*Conn); ok {
+		return rate
+	}
+	return audio.DefaultRate
+}
+
+// receive queues a packet for reading, dropping it if the reader is not
+// keeping up
+func (c *Conn) receive(p *Packet) {
+	select {
+	case c.packets <- p:
+	default:
+	}
+}
+
+// Read implements net.Conn, returning the stream as AudioSocket messages
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		m, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		c.rbuf = m
+	}
+
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// next returns the next AudioSocket message of the stream
+func (c *Conn) next() ([]byte, error) {
+	if c.callID() == "" {
+		return c.idMessage()
+	}
+
+	idle := time.NewTimer(IdleTimeout)
+	defer idle.Stop()
+
+	for len(c.ready) == 0 {
+		if p, lost := c.jitter.pop(false); p != nil {
+			c.release(p, lost)
+			continue
+		}
+
+		select {
+		case <-c.done:
+			return nil, io.EOF
+		case p := <-c.packets:
+			c.jitter.push(p)
+		case <-idle.C:
+			for p, lost := c.jitter.pop(true); p != nil; p, lost = c.jitter.pop(true) {
+				c.release(p, lost)
+			}
+			if len(c.ready) == 0 {
+				return nil, io.EOF
+			}
+		}
+	}
+
+	m := c.ready[0]
+	c.ready = c.ready[1:]
+	return m, nil
+}
+
+// idMessage looks up the call of the stream, returning its ID message
+func (c *Conn) idMessage() ([]byte, error) {
+	id, err := c.l.registry.Lookup(context.Background(), c.remote.String(), LookupWait)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find call of RTP stream from %s", c.remote)
+	}
+	u, err := uuid.FromString(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid call ID %q for RTP stream from %s", id, c.remote)
+	}
+	c.mu.Lock()
+	c.id = id
+	c.mu.Unlock()
+	return audiosocket.IDMessage(u), nil
+}
+
+// callID returns the call ID of the stream, if it has been looked up
+func (c *Conn) callID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.id
+}
+
+// release decodes a packet released by the jitter buffer, preceded by
+// silence in place of any lost packets
+func (c *Conn) release(p *Packet, lost int) {
+	f := FormatByPayloadType(p.PayloadType)
+	if f == nil {
+		return
+	}
+	c.mu.Lock()
+	c.format = f
+	c.mu.Unlock()
+
+	slin := f.Decode(p.Payload)
+	if len(slin) == 0 {
+		return
+	}
+	for i := 0; i < lost; i++ {
+		c.ready = append(c.ready, audio.Message(f.ClockRate, make([]byte, len(slin))))
+	}
+	c.ready = append(c.ready, audio.Message(f.ClockRate, slin))
+}
+
+// Write implements net.Conn, taking AudioSocket messages.  Audio is sent to
+// Asterisk as RTP, and a hangup message closes the connection.
+func (c *Conn) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	c.wbuf = append(c.wbuf, b...)
+	for len(c.wbuf) >= 3 {
+		size := 3 + int(binary.BigEndian.Uint16(c.wbuf[1:]))
+		if len(c.wbuf) < size {
+			break
+		}
+		m := audiosocket.MessageFromData(c.wbuf[:size])
+		c.wbuf = c.wbuf[size:]
+
+		if m.Kind() == audiosocket.KindHangup {
+			c.hangup()
+			return len(b), nil
+		}
+		if rate := audio.Rate(m.Kind()); rate > 0 {
+			if err := c.send(m.Payload(), rate); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(b), nil
+}
+
+// send sends signed linear audio at the given rate as an RTP packet,
+// resampled to the rate of the format
+func (c *Conn) send(slin []byte, rate int) error {
+	c.mu.Lock()
+	f := c.format
+	c.mu.Unlock()
+	if f == nil {
+		f = c.l.DefaultFormat
+	}
+	slin = audio.Resample(slin, rate, f.ClockRate)
+
+	p := &Packet{
+		PayloadType: f.PayloadType,
+		Sequence:    c.seq,
+		Timestamp:   c.ts,
+		SSRC:        c.ssrc,
+		Payload:     f.Encode(slin),
+	}
+	c.seq++
+	c.ts += f.samples(slin)
+
+	_, err := c.l.pc.WriteTo(p.Marshal(), c.remote)
+	return errors.Wrap(err, "failed to send RTP")
+}
+
+// hangup closes the connection at the request of the voice service
+func (c *Conn) hangup() {
+	if id := c.callID(); id != "" && c.l.OnHangup != nil {
+		go c.l.OnHangup(id)
+	}
+	c.Close() // nolint: errcheck
+}
+
+// Close implements net.Conn
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.l.remove(c)
+		if id := c.callID(); id != "" {
+			log.Printf("RTP stream of call %s from %s ended", id, c.remote)
+		}
+	})
+	return nil
+}
+
+// LocalAddr implements net.Conn
+func (c *Conn) LocalAddr() net.Addr {
+	return c.l.Addr()
+}
+
+// RemoteAddr implements net.Conn
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline implements net.Conn.  Deadlines are not supported.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline implements net.Conn.  Deadlines are not supported.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn.  Deadlines are not supported.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,69 @@
+package rtp
+
+// JitterDepth is the number of packets held by the jitter buffer in order
+// to put late packets back in order.  Each packet normally carries 20ms of
+// audio.
+var JitterDepth = 3
+
+// MaxConcealedPackets is the largest gap in the sequence of received packets
+// which is filled with silence.  Larger gaps, such as those left when the
+// sender restarts its sequence, are skipped.
+const MaxConcealedPackets = 10
+
+// jitterBuffer reorders received packets by their sequence numbers
+type jitterBuffer struct {
+	// packets are the held packets, in sequence order
+	packets []*Packet
+
+	// next is the sequence number of the next packet to be released
+	next    uint16
+	started bool
+}
+
+// seqBefore reports whether sequence number a comes before b, allowing for
+// wraparound
+func seqBefore(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+// push adds a packet to the buffer.  Duplicate packets, and those which
+// arrive after their place in the sequence has been released, are dropped.
+func (j *jitterBuffer) push(p *Packet) {
+	if j.started && seqBefore(p.Sequence, j.next) {
+		return
+	}
+
+	i := len(j.packets)
+	for i > 0 && seqBefore(p.Sequence, j.packets[i-1].Sequence) {
+		i--
+	}
+	if i > 0 && j.packets[i-1].Sequence == p.Sequence {
+		return
+	}
+
+	j.packets = append(j.packets, nil)
+	copy(j.packets[i+1:], j.packets[i:])
+	j.packets[i] = p
+}
+
+// pop releases the next packet once more than JitterDepth packets are held,
+// or any held packet if flush is set.  It also returns the number of
+// packets missing before it, which should be concealed.
+func (j *jitterBuffer) pop(flush bool) (p *Packet, lost int) {
+	if len(j.packets) == 0 || (!flush && len(j.packets) <= JitterDepth) {
+		return nil, 0
+	}
+
+	p = j.packets[0]
+	j.packets = j.packets[1:]
+
+	if j.started {
+		lost = int(p.Sequence - j.next)
+	}
+	if lost > MaxConcealedPackets {
+		lost = 0
+	}
+	j.next = p.Sequence + 1
+	j.started = true
+	return p, lost
+}
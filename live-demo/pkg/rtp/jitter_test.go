@@ -0,0 +1,123 @@
+package rtp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/CyCoreSystems/audiosocket"
+)
+
+func TestJitterBuffer(t *testing.T) {
+	type release struct {
+		seq  uint16
+		lost int
+	}
+
+	tests := []struct {
+		name string
+		in   []uint16
+		want []release
+	}{
+		{
+			"in order",
+			[]uint16{1, 2, 3, 4, 5},
+			[]release{{1, 0}, {2, 0}, {3, 0}, {4, 0}, {5, 0}},
+		},
+		{
+			"reordered within the depth",
+			[]uint16{1, 3, 2, 5, 4, 6},
+			[]release{{1, 0}, {2, 0}, {3, 0}, {4, 0}, {5, 0}, {6, 0}},
+		},
+		{
+			"lost packets",
+			[]uint16{1, 2, 5, 6, 7, 8},
+			[]release{{1, 0}, {2, 0}, {5, 2}, {6, 0}, {7, 0}, {8, 0}},
+		},
+		{
+			"duplicates",
+			[]uint16{1, 2, 2, 3, 1, 4},
+			[]release{{1, 0}, {2, 0}, {3, 0}, {4, 0}},
+		},
+		{
+			"late packet after its place is released",
+			[]uint16{1, 3, 4, 5, 6, 2, 7},
+			[]release{{1, 0}, {3, 1}, {4, 0}, {5, 0}, {6, 0}, {7, 0}},
+		},
+		{
+			"wraparound",
+			[]uint16{65534, 0, 65535, 1, 2},
+			[]release{{65534, 0}, {65535, 0}, {0, 0}, {1, 0}, {2, 0}},
+		},
+		{
+			"restarted sequence",
+			[]uint16{100, 101, 5000, 5001},
+			[]release{{100, 0}, {101, 0}, {5000, 0}, {5001, 0}},
+		},
+	}
+	for _, tt := range tests {
+		var (
+			j   jitterBuffer
+			got []release
+		)
+		for _, seq := range tt.in {
+			j.push(&Packet{Sequence: seq})
+			for p, lost := j.pop(false); p != nil; p, lost = j.pop(false) {
+				got = append(got, release{p.Sequence, lost})
+			}
+		}
+		for p, lost := j.pop(true); p != nil; p, lost = j.pop(true) {
+			got = append(got, release{p.Sequence, lost})
+		}
+
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: released %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: released %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestJitterBufferHolds(t *testing.T) {
+	var j jitterBuffer
+	for seq := uint16(1); seq <= uint16(JitterDepth); seq++ {
+		j.push(&Packet{Sequence: seq})
+		if p, _ := j.pop(false); p != nil {
+			t.Fatalf("released packet %d with %d held", p.Sequence, seq)
+		}
+	}
+}
+
+func TestReleaseConcealsLoss(t *testing.T) {
+	c := new(Conn)
+	payload := encodeULaw(bytes.Repeat([]byte{0x10, 0x20}, 160))
+
+	c.release(&Packet{PayloadType: PayloadULaw, Payload: payload}, 2)
+
+	if len(c.ready) != 3 {
+		t.Fatalf("released %d messages, want 3", len(c.ready))
+	}
+	for i, data := range c.ready {
+		m := audiosocket.MessageFromData(data)
+		if m.Kind() != audiosocket.KindSlin {
+			t.Errorf("message %d is of kind %v", i, m.Kind())
+		}
+		if len(m.Payload()) != 320 {
+			t.Errorf("message %d has %d bytes, want 320", i, len(m.Payload()))
+		}
+		silent := bytes.Equal(m.Payload(), make([]byte, 320))
+		if i < 2 && !silent {
+			t.Errorf("message %d in place of a lost packet is not silent", i)
+		}
+		if i == 2 && silent {
+			t.Errorf("received packet released as silence")
+		}
+	}
+	if c.format == nil || c.format.Name != "ulaw" {
+		t.Errorf("format is %v, want ulaw", c.format)
+	}
+}
@@ -0,0 +1,181 @@
+// Package rtp lets the voice services take calls as RTP from Asterisk
+// ExternalMedia (UnicastRTP) channels as well as from AudioSocket.  Each
+// stream of RTP received from a new address is presented as a connection
+// which speaks the AudioSocket protocol, so that the voice services handle
+// it exactly as they would an AudioSocket connection: it starts with the ID
+// message of the call and carries signed linear audio in both directions.
+package rtp
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IdleTimeout is the time after which a stream which has received no
+// packets is considered to have ended.  RTP has no hangup, so this is how
+// the voice service learns that the channel has gone.
+var IdleTimeout = 5 * time.Second
+
+// LookupWait is the maximum time to wait for the call of a new stream to be
+// registered
+var LookupWait = 2 * time.Second
+
+// maxPacketSize is the largest RTP packet which is received
+const maxPacketSize = 1500
+
+// packetQueueSize is the number of received packets which may wait to be
+// read from a connection before further packets are dropped
+const packetQueueSize = 50
+
+// ErrClosed indicates that the listener has been closed
+var ErrClosed = errors.New("listener closed")
+
+// Listener receives RTP streams on a UDP socket, returning a connection for
+// each
+type Listener struct {
+	pc       net.PacketConn
+	registry *Registry
+
+	// DefaultFormat is the format in which audio is sent on a connection
+	// before any has been received on it
+	DefaultFormat *Format
+
+	// OnHangup, if set, is called with the call ID of each connection which
+	// the voice service hangs up.  RTP cannot signal the hangup to
+	// Asterisk, so the front-end which created the channel must be told.
+	OnHangup func(id string)
+
+	mu    sync.Mutex
+	conns map[string]*Conn
+
+	// ended holds the addresses of connections which have been closed,
+	// with the time at which they last sent a packet, so that their
+	// remaining packets do not start new connections
+	ended map[string]time.Time
+
+	accept    chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen listens for RTP on the given UDP address
+func Listen(addr string, r *Registry) (*Listener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen for RTP on %s", addr)
+	}
+
+	l := &Listener{
+		pc:            pc,
+		registry:      r,
+		DefaultFormat: FormatByName("ulaw"),
+		conns:         make(map[string]*Conn),
+		ended:         make(map[string]time.Time),
+		accept:        make(chan *Conn, 10),
+		closed:        make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Accept implements net.Listener, returning the connection of the next new
+// RTP stream
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, ErrClosed
+	}
+}
+
+// Close implements net.Listener
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return l.pc.Close()
+}
+
+// Addr implements net.Listener
+func (l *Listener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// run receives packets until the socket is closed, passing each to the
+// connection of its sender
+func (l *Listener) run() {
+	defer l.Close() // nolint: errcheck
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-l.closed:
+			default:
+				log.Println("failed to receive RTP:", err)
+			}
+			return
+		}
+
+		p, err := Parse(append([]byte(nil), buf[:n]...))
+		if err != nil {
+			continue
+		}
+		if c := l.conn(from); c != nil {
+			c.receive(p)
+		}
+	}
+}
+
+// conn returns the connection of the given sender, starting a new one if
+// there is none.  It returns nil for the remaining packets of connections
+// which have been closed.
+func (l *Listener) conn(from net.Addr) *Conn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := from.String()
+	if c, ok := l.conns[key]; ok {
+		return c
+	}
+	if last, ok := l.ended[key]; ok {
+		if time.Since(last) < IdleTimeout {
+			l.ended[key] = time.Now()
+			return nil
+		}
+		delete(l.ended, key)
+	}
+
+	c := newConn(l, from)
+	select {
+	case l.accept <- c:
+	default:
+		log.Println("dropping RTP stream from", key)
+		return nil
+	}
+	l.conns[key] = c
+	return c
+}
+
+// remove forgets a closed connection
+func (l *Listener) remove(c *Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := c.remote.String()
+	delete(l.conns, key)
+	l.ended[key] = time.Now()
+
+	// Forget long-ended streams
+	for k, last := range l.ended {
+		if time.Since(last) > IdleTimeout {
+			delete(l.ended, k)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package rtp
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// headerSize is the size of the fixed RTP header
+const headerSize = 12
+
+// version is the RTP version, which is carried in the top bits of the first
+// byte of each packet
+const version = 2
+
+// Packet is an RTP packet
+type Packet struct {
+	Marker      bool
+	PayloadType uint8
+	Sequence    uint16
+	Timestamp   uint32
+	SSRC        uint32
+	Payload     []byte
+}
+
+// Parse parses an RTP packet, skipping any CSRCs, header extension and
+// padding
+func Parse(b []byte) (*Packet, error) {
+	if len(b) < headerSize {
+		return nil, errors.New("packet too short")
+	}
+	if b[0]>>6 != version {
+		return nil, errors.Errorf("unsupported RTP version %d", b[0]>>6)
+	}
+
+	p := &Packet{
+		Marker:      b[1]&0x80 != 0,
+		PayloadType: b[1] & 0x7f,
+		Sequence:    binary.BigEndian.Uint16(b[2:]),
+		Timestamp:   binary.BigEndian.Uint32(b[4:]),
+		SSRC:        binary.BigEndian.Uint32(b[8:]),
+	}
+
+	start := headerSize + 4*int(b[0]&0x0f)
+	if b[0]&0x10 != 0 {
+		if len(b) < start+4 {
+			return nil, errors.New("truncated header extension")
+		}
+		start += 4 + 4*int(binary.BigEndian.Uint16(b[start+2:]))
+	}
+	end := len(b)
+	if b[0]&0x20 != 0 && end > 0 {
+		end -= int(b[end-1])
+	}
+	if start > end {
+		return nil, errors.New("truncated packet")
+	}
+	p.Payload = b[start:end]
+	return p, nil
+}
+
+// Marshal encodes the packet
+func (p *Packet) Marshal() []byte {
+	b := make([]byte, headerSize, headerSize+len(p.Payload))
+	b[0] = version << 6
+	b[1] = p.PayloadType & 0x7f
+	if p.Marker {
+		b[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(b[2:], p.Sequence)
+	binary.BigEndian.PutUint32(b[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(b[8:], p.SSRC)
+	return append(b, p.Payload...)
+}
@@ -0,0 +1,73 @@
+package rtp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	tests := []*Packet{
+		{PayloadType: PayloadULaw, Sequence: 1, Timestamp: 160, SSRC: 0xdeadbeef, Payload: bytes.Repeat([]byte{0xff}, 160)},
+		{Marker: true, PayloadType: PayloadALaw, Sequence: 65535, Timestamp: 0xffffffff, SSRC: 1, Payload: []byte{0xd5}},
+		{PayloadType: PayloadSlin16, Sequence: 0, Timestamp: 0, SSRC: 0, Payload: []byte{}},
+	}
+	for _, want := range tests {
+		got, err := Parse(want.Marshal())
+		if err != nil {
+			t.Errorf("%+v: %v", want, err)
+			continue
+		}
+		if got.Marker != want.Marker || got.PayloadType != want.PayloadType || got.Sequence != want.Sequence ||
+			got.Timestamp != want.Timestamp || got.SSRC != want.SSRC || !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("parsed %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	header := []byte{0x80, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0xa0, 0x00, 0x00, 0x00, 0x01}
+	payload := []byte{1, 2, 3, 4}
+
+	with := func(first byte, parts ...[]byte) []byte {
+		b := append([]byte{first}, header[1:]...)
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+		ok   bool
+	}{
+		{"plain", with(0x80, payload), payload, true},
+		{"two CSRCs", with(0x82, make([]byte, 8), payload), payload, true},
+		{"header extension", with(0x90, []byte{0xbe, 0xde, 0x00, 0x01}, make([]byte, 4), payload), payload, true},
+		{"padding", with(0xa0, payload, []byte{0, 0, 3}), payload, true},
+		{"too short", header[:11], nil, false},
+		{"version 1", with(0x40, payload), nil, false},
+		{"truncated CSRCs", with(0x8f, payload), nil, false},
+		{"truncated extension", with(0x90, []byte{0xbe, 0xde}), nil, false},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.data)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%s: parsed", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if p.Sequence != 7 || p.Timestamp != 160 || p.SSRC != 1 {
+			t.Errorf("%s: parsed header %+v", tt.name, p)
+		}
+		if !bytes.Equal(p.Payload, tt.want) {
+			t.Errorf("%s: payload %v, want %v", tt.name, p.Payload, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package rtp
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// RegistryTTL is the time for which the call of a media address is kept
+const RegistryTTL = 15 * time.Minute
+
+// RegistryKeyPrefix is the prefix of the Redis keys of the calls of media
+// addresses.  The full key is the prefix followed by the address.
+const RegistryKeyPrefix = "audiosocket:rtp:"
+
+// LookupInterval is the interval at which Lookup retries a missing address
+var LookupInterval = 50 * time.Millisecond
+
+// ErrNotFound indicates that no call is registered for an address
+var ErrNotFound = errors.New("media address not registered")
+
+// Registry records the call to which each ExternalMedia channel belongs,
+// by the address from which Asterisk sends its RTP.  RTP carries no call ID,
+// so the ARI app registers the address of each channel it creates, and the
+// voice service looks it up when the first packet arrives.
+type Registry struct {
+	c *redis.Client
+}
+
+// NewRegistry returns a Registry backed by the Redis server at the given
+// address
+func NewRegistry(addr string) *Registry {
+	return &Registry{
+		c: redis.NewClient(&redis.Options{
+			Addr: addr,
+		}),
+	}
+}
+
+// Register records the call ID of the media sent from the given address
+func (r *Registry) Register(ctx context.Context, addr, id string) error {
+	return errors.Wrap(r.c.WithContext(ctx).Set(RegistryKeyPrefix+addr, id, RegistryTTL).Err(), "failed to register media address")
+}
+
+// Lookup returns the call ID of the media sent from the given address,
+// waiting for up to the given time for it to be registered
+func (r *Registry) Lookup(ctx context.Context, addr string, wait time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	for {
+		id, err := r.c.WithContext(ctx).Get(RegistryKeyPrefix + addr).Result()
+		if err == nil {
+			return id, nil
+		}
+		if err != redis.Nil {
+			return "", errors.Wrap(err, "failed to look up media address")
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ErrNotFound
+		case <-time.After(LookupInterval):
+		}
+	}
+}
+
+// Close closes the connection to Redis
+func (r *Registry) Close() error {
+	return r.c.Close()
+}
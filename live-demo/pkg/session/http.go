@@ -0,0 +1,52 @@
+package session
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Handler serves the sessions of a Store as JSON.  A request for the base
+// path (e.g. /calls/) lists all sessions, and a request for a call ID (e.g.
+// /calls/<uuid>) returns the session of that call.
+type Handler struct {
+	Store Store
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var (
+		out interface{}
+		err error
+	)
+	if id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]; id != "" {
+		out, err = h.Store.Load(r.Context(), id)
+	} else {
+		var list []*Session
+		list, err = h.Store.List(r.Context())
+		if list == nil {
+			list = []*Session{}
+		}
+		out = list
+	}
+	if err == ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("failed to retrieve sessions:", err)
+		http.Error(w, "failed to retrieve sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(out); err != nil {
+		log.Println("failed to encode sessions:", err)
+	}
+}
@@ -0,0 +1,141 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/metadata"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// TTL is the time for which a session is kept after it was last saved
+const TTL = 15 * time.Minute
+
+// KeyPrefix is the prefix of the Redis keys of the sessions.  The full key
+// is the prefix followed by the AudioSocket UUID.
+const KeyPrefix = "audiosocket:session:"
+
+// IndexKey is the Redis set of the IDs of stored sessions, by which they
+// are listed
+const IndexKey = "audiosocket:sessions"
+
+// RedisStore stores sessions in Redis, where they are shared by all replicas
+type RedisStore struct {
+	c *redis.Client
+}
+
+// NewRedisStore returns a Store backed by the Redis server at the given
+// address
+func NewRedisStore(addr string) *RedisStore {
+	return &RedisStore{
+		c: redis.NewClient(&redis.Options{
+			Addr: addr,
+		}),
+	}
+}
+
+// NewRedisStoreFromEnv returns a Store backed by the Redis server named by
+// the REDIS_ADDR environment variable, or metadata.DefaultRedisAddr
+func NewRedisStoreFromEnv() *RedisStore {
+	return NewRedisStore(metadata.RedisAddr())
+}
+
+// Save implements Store
+func (r *RedisStore) Save(ctx context.Context, s *Session) error {
+	s.Updated = time.Now()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode session")
+	}
+
+	_, err = r.c.WithContext(ctx).TxPipelined(func(p redis.Pipeliner) error {
+		p.Set(KeyPrefix+s.ID, data, TTL)
+		p.SAdd(IndexKey, s.ID)
+		return nil
+	})
+	return errors.Wrap(err, "failed to store session")
+}
+
+// Load implements Store
+func (r *RedisStore) Load(ctx context.Context, id string) (*Session, error) {
+	data, err := r.c.WithContext(ctx).Get(KeyPrefix + id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve session")
+	}
+
+	s := new(Session)
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrap(err, "failed to decode session")
+	}
+	return s, nil
+}
+
+// Delete implements Store
+func (r *RedisStore) Delete(ctx context.Context, id string) error {
+	_, err := r.c.WithContext(ctx).TxPipelined(func(p redis.Pipeliner) error {
+		p.Del(KeyPrefix + id)
+		p.SRem(IndexKey, id)
+		return nil
+	})
+	return errors.Wrap(err, "failed to delete session")
+}
+
+// List implements Store.  Sessions which have expired are removed from the
+// index.
+func (r *RedisStore) List(ctx context.Context) ([]*Session, error) {
+	c := r.c.WithContext(ctx)
+
+	ids, err := c.SMembers(IndexKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list sessions")
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = KeyPrefix + id
+	}
+	values, err := c.MGet(keys...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve sessions")
+	}
+
+	var (
+		out     []*Session
+		expired []interface{}
+	)
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+		s := new(Session)
+		if err = json.Unmarshal([]byte(data), s); err != nil {
+			log.Printf("ignoring invalid session %s: %v", ids[i], err)
+			continue
+		}
+		out = append(out, s)
+	}
+	if len(expired) > 0 {
+		if err = c.SRem(IndexKey, expired...).Err(); err != nil {
+			log.Println("failed to remove expired sessions from index:", err)
+		}
+	}
+
+	sortByStart(out)
+	return out, nil
+}
+
+// Close closes the connection to Redis
+func (r *RedisStore) Close() error {
+	return r.c.Close()
+}
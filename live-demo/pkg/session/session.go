@@ -0,0 +1,206 @@
+// Package session stores the state of calls in progress, so that any
+// replica of a voice service may inspect, resume or take over a call, and
+// so that the active calls of the whole cluster may be listed.
+package session
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound indicates that there is no session for the call
+var ErrNotFound = errors.New("session not found")
+
+const (
+	// StateActive indicates that the call is in progress
+	StateActive = "active"
+
+	// StateEnded indicates that the call has ended
+	StateEnded = "ended"
+)
+
+// Session is the state of a call
+type Session struct {
+	// ID is the AudioSocket UUID of the call
+	ID string `json:"id"`
+
+	// Service is the name of the voice service handling the call
+	Service string `json:"service"`
+
+	// Owner is the replica currently handling the call
+	Owner string `json:"owner"`
+
+	// State is the state of the call, such as StateActive
+	State string `json:"state"`
+
+	// Node is the name of the current state of the call, such as an IVR
+	// node, from which the call may be resumed
+	Node string `json:"node,omitempty"`
+
+	// Language is the language of the call
+	Language string `json:"language,omitempty"`
+
+	// Slots are the variables collected during the call
+	Slots map[string]string `json:"slots,omitempty"`
+
+	// Turns is the history of the caller's input
+	Turns []Turn `json:"turns,omitempty"`
+
+	// Started is the time at which the call started
+	Started time.Time `json:"started"`
+
+	// Updated is the time at which the session was last saved
+	Updated time.Time `json:"updated"`
+}
+
+// Turn is a single input of the caller
+type Turn struct {
+	// Node is the state in which the input was received
+	Node string `json:"node"`
+
+	// Speech is the recognized speech, if any
+	Speech string `json:"speech,omitempty"`
+
+	// DTMF is the digits entered, if any
+	DTMF string `json:"dtmf,omitempty"`
+
+	// Time is the time at which the input was received
+	Time time.Time `json:"time"`
+}
+
+// New returns a new active session for the call, owned by this replica
+func New(id, service string) *Session {
+	now := time.Now()
+	return &Session{
+		ID:      id,
+		Service: service,
+		Owner:   Hostname(),
+		State:   StateActive,
+		Slots:   make(map[string]string),
+		Started: now,
+		Updated: now,
+	}
+}
+
+// AddTurn records an input of the caller
+func (s *Session) AddTurn(node, speech, dtmf string) {
+	s.Turns = append(s.Turns, Turn{
+		Node:   node,
+		Speech: speech,
+		DTMF:   dtmf,
+		Time:   time.Now(),
+	})
+}
+
+// Store stores sessions
+type Store interface {
+	// Save stores the session, replacing any previous version
+	Save(ctx context.Context, s *Session) error
+
+	// Load returns the session of a call, or ErrNotFound
+	Load(ctx context.Context, id string) (*Session, error)
+
+	// Delete removes the session of a call
+	Delete(ctx context.Context, id string) error
+
+	// List returns all stored sessions, oldest first
+	List(ctx context.Context) ([]*Session, error)
+}
+
+// FromEnv returns the Store selected by the SESSION_STORE environment
+// variable: "memory" for a MemoryStore, or otherwise a RedisStore at the
+// address given by REDIS_ADDR.
+func FromEnv() Store {
+	if os.Getenv("SESSION_STORE") == "memory" {
+		return NewMemoryStore()
+	}
+	return NewRedisStoreFromEnv()
+}
+
+// Hostname returns the name of this replica
+func Hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// MemoryStore stores sessions in memory.  The sessions are only visible to
+// this replica.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Save implements Store
+func (m *MemoryStore) Save(ctx context.Context, s *Session) error {
+	s.Updated = time.Now()
+
+	m.mu.Lock()
+	m.sessions[s.ID] = s.copy()
+	m.mu.Unlock()
+	return nil
+}
+
+// Load implements Store
+func (m *MemoryStore) Load(ctx context.Context, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.copy(), nil
+}
+
+// Delete implements Store
+func (m *MemoryStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	return nil
+}
+
+// List implements Store
+func (m *MemoryStore) List(ctx context.Context) ([]*Session, error) {
+	m.mu.Lock()
+	out := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		out = append(out, s.copy())
+	}
+	m.mu.Unlock()
+
+	sortByStart(out)
+	return out, nil
+}
+
+// copy returns a copy of the session which shares no mutable state with it
+func (s *Session) copy() *Session {
+	out := *s
+	out.Slots = make(map[string]string, len(s.Slots))
+	for k, v := range s.Slots {
+		out.Slots[k] = v
+	}
+	out.Turns = append([]Turn(nil), s.Turns...)
+	return &out
+}
+
+func sortByStart(list []*Session) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Started.Before(list[j].Started)
+	})
+}
@@ -0,0 +1,149 @@
+package synth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Pronunciation describes how a term should be spoken.  Exactly one of
+// Alias or Phoneme should be set.
+type Pronunciation struct {
+	// Alias is text which is spoken in place of the term
+	Alias string `yaml:"alias,omitempty"`
+
+	// Phoneme is the pronunciation of the term in the IPA alphabet
+	Phoneme string `yaml:"phoneme,omitempty"`
+}
+
+// Lexicon maps terms to their pronunciations.  Terms are matched as whole
+// words, regardless of case.
+type Lexicon map[string]Pronunciation
+
+// DefaultLexicon is the lexicon used when none is configured
+var DefaultLexicon = Lexicon{
+	"asterisk":   {Phoneme: "ˈæstərɪsk"},
+	"kamailio":   {Phoneme: "kəˈmaɪlioʊ"},
+	"kubernetes": {Phoneme: "ˌkuːbərˈnɛtiːz"},
+	"k8s":        {Alias: "kubernetes"},
+	"rtpengine":  {Alias: "R T P engine"},
+}
+
+// LoadLexicon reads a lexicon from a YAML file, which maps each term to its
+// pronunciation:
+//
+//	kamailio:
+//	  phoneme: kəˈmaɪlioʊ
+//	rtpengine:
+//	  alias: R T P engine
+func LoadLexicon(path string) (Lexicon, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var in Lexicon
+	if err = yaml.UnmarshalStrict(data, &in); err != nil {
+		return nil, errors.Wrap(err, "failed to parse lexicon")
+	}
+
+	lex := make(Lexicon, len(in))
+	for term, p := range in {
+		if (p.Alias == "") == (p.Phoneme == "") {
+			return nil, errors.Errorf("term %q must have exactly one of alias or phoneme", term)
+		}
+		lex[strings.ToLower(term)] = p
+	}
+	return lex, nil
+}
+
+// ssmlToken matches an SSML tag
+var ssmlToken = regexp.MustCompile(`<[^>]*>`)
+
+// protectedElements are the SSML elements whose content already describes
+// its pronunciation
+var protectedElements = map[string]bool{
+	"phoneme": true,
+	"say-as":  true,
+	"sub":     true,
+}
+
+// Apply converts the text to SSML, if it is not already, and marks up each
+// term of the lexicon which it contains
+func (l Lexicon) Apply(text string) string {
+	if !IsSSML(text) {
+		text = SSML(Text(text))
+	}
+
+	pattern := l.pattern()
+	if pattern == nil {
+		return text
+	}
+
+	var (
+		out       strings.Builder
+		protected int
+		last      int
+	)
+	for _, loc := range ssmlToken.FindAllStringIndex(text, -1) {
+		out.WriteString(l.replace(pattern, text[last:loc[0]], protected > 0))
+
+		tag := text[loc[0]:loc[1]]
+		out.WriteString(tag)
+		last = loc[1]
+
+		switch {
+		case !protectedElements[elementName(tag)], strings.HasSuffix(tag, "/>"):
+		case strings.HasPrefix(tag, "</"):
+			protected--
+		default:
+			protected++
+		}
+	}
+	out.WriteString(l.replace(pattern, text[last:], protected > 0))
+
+	return out.String()
+}
+
+func (l Lexicon) replace(pattern *regexp.Regexp, text string, protected bool) string {
+	if protected || text == "" {
+		return text
+	}
+	return pattern.ReplaceAllStringFunc(text, func(term string) string {
+		p := l[strings.ToLower(term)]
+		if p.Phoneme != "" {
+			return fmt.Sprintf(`<phoneme alphabet="ipa" ph="%s">%s</phoneme>`, Text(p.Phoneme), term)
+		}
+		return fmt.Sprintf(`<sub alias="%s">%s</sub>`, Text(p.Alias), term)
+	})
+}
+
+func (l Lexicon) pattern() *regexp.Regexp {
+	if len(l) == 0 {
+		return nil
+	}
+
+	terms := make([]string, 0, len(l))
+	for term := range l {
+		terms = append(terms, regexp.QuoteMeta(term))
+	}
+	// Prefer the longest match
+	sort.Slice(terms, func(i, j int) bool {
+		return len(terms[i]) > len(terms[j])
+	})
+	return regexp.MustCompile(`(?i)\b(` + strings.Join(terms, "|") + `)\b`)
+}
+
+// elementName returns the name of the element of an SSML tag
+func elementName(tag string) string {
+	fields := strings.Fields(strings.Trim(tag, "<>/"))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
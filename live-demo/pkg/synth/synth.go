@@ -0,0 +1,210 @@
+// Package synth builds text-to-speech requests, applying the configured
+// voice and a pronunciation lexicon.  Text may be plain text or SSML; SSML
+// is recognized by its enclosing <speak> element.
+package synth
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	texttospeechv1 "google.golang.org/genproto/googleapis/cloud/texttospeech/v1"
+)
+
+// SampleRate is the sample rate of the synthesized audio, unless another is
+// requested
+const SampleRate = 8000
+
+// Voice describes the voice with which speech is synthesized
+type Voice struct {
+	// Names are the names of the voices to use (e.g. "en-US-Wavenet-D"),
+	// keyed by language code.  If there is no voice for a language, the
+	// service chooses one.
+	Names map[string]string
+
+	// Gender is the preferred gender of the voice: "male", "female" or
+	// "neutral"
+	Gender string
+
+	// SpeakingRate is the speed of speech, where 1.0 is normal
+	SpeakingRate float64
+
+	// Pitch is the change in pitch, in semitones
+	Pitch float64
+}
+
+// VoiceFromEnv returns the voice described by the environment:
+//
+//   - TTS_VOICES: voice names by language, as "en-US=en-US-Wavenet-D,de-DE=de-DE-Wavenet-B"
+//   - TTS_GENDER: male, female or neutral
+//   - TTS_SPEAKING_RATE: 0.25 to 4.0
+//   - TTS_PITCH: -20.0 to 20.0
+func VoiceFromEnv() (*Voice, error) {
+	v := &Voice{
+		Names:  make(map[string]string),
+		Gender: strings.ToLower(os.Getenv("TTS_GENDER")),
+	}
+
+	for _, entry := range strings.Split(os.Getenv("TTS_VOICES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pieces := strings.SplitN(entry, "=", 2)
+		if len(pieces) != 2 {
+			return nil, errors.Errorf("invalid voice entry %q", entry)
+		}
+		v.Names[strings.TrimSpace(pieces[0])] = strings.TrimSpace(pieces[1])
+	}
+
+	if _, ok := genders[v.Gender]; !ok {
+		return nil, errors.Errorf("invalid voice gender %q", v.Gender)
+	}
+
+	var err error
+	if s := os.Getenv("TTS_SPEAKING_RATE"); s != "" {
+		if v.SpeakingRate, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, errors.Wrap(err, "invalid speaking rate")
+		}
+	}
+	if s := os.Getenv("TTS_PITCH"); s != "" {
+		if v.Pitch, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, errors.Wrap(err, "invalid pitch")
+		}
+	}
+	return v, nil
+}
+
+// FromEnv returns a Synthesizer with the voice described by the environment
+// (see VoiceFromEnv) and the lexicon at the path given by the LEXICON
+// environment variable, or the default path.  If there is no lexicon at the
+// path, DefaultLexicon is used.
+func FromEnv(defaultLexiconPath string) (*Synthesizer, error) {
+	voice, err := VoiceFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	path := os.Getenv("LEXICON")
+	if path == "" {
+		path = defaultLexiconPath
+	}
+	lex, err := LoadLexicon(path)
+	if os.IsNotExist(err) {
+		lex, err = DefaultLexicon, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load lexicon %s", path)
+	}
+
+	return &Synthesizer{
+		Voice:   voice,
+		Lexicon: lex,
+	}, nil
+}
+
+var genders = map[string]texttospeechv1.SsmlVoiceGender{
+	"":        texttospeechv1.SsmlVoiceGender_SSML_VOICE_GENDER_UNSPECIFIED,
+	"male":    texttospeechv1.SsmlVoiceGender_MALE,
+	"female":  texttospeechv1.SsmlVoiceGender_FEMALE,
+	"neutral": texttospeechv1.SsmlVoiceGender_NEUTRAL,
+}
+
+// Synthesizer builds speech synthesis requests
+type Synthesizer struct {
+	Voice   *Voice
+	Lexicon Lexicon
+}
+
+// Request returns the request to synthesize the given text, which may be
+// SSML, in the given language
+func (s *Synthesizer) Request(languageCode, text string) *texttospeechv1.SynthesizeSpeechRequest {
+	return s.RequestAt(languageCode, text, SampleRate)
+}
+
+// RequestAt returns the request to synthesize the given text, which may be
+// SSML, in the given language at the given sample rate
+func (s *Synthesizer) RequestAt(languageCode, text string, rate int) *texttospeechv1.SynthesizeSpeechRequest {
+	voice := s.Voice
+	if voice == nil {
+		voice = new(Voice)
+	}
+
+	return &texttospeechv1.SynthesizeSpeechRequest{
+		Input: &texttospeechv1.SynthesisInput{
+			InputSource: &texttospeechv1.SynthesisInput_Ssml{
+				Ssml: s.Lexicon.Apply(text),
+			},
+		},
+		Voice: &texttospeechv1.VoiceSelectionParams{
+			LanguageCode: languageCode,
+			Name:         voice.Names[languageCode],
+			SsmlGender:   genders[voice.Gender],
+		},
+		AudioConfig: &texttospeechv1.AudioConfig{
+			AudioEncoding:   texttospeechv1.AudioEncoding_LINEAR16,
+			SampleRateHertz: int32(rate),
+			SpeakingRate:    voice.SpeakingRate,
+			Pitch:           voice.Pitch,
+		},
+	}
+}
+
+// IsSSML indicates whether the text is an SSML document: one which begins
+// with a speak element, which may have attributes
+func IsSSML(text string) bool {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "<speak") {
+		return false
+	}
+	rest := text[len("<speak"):]
+	if rest == "" {
+		return false
+	}
+	switch rest[0] {
+	case '>', '/', ' ', '\t', '\r', '\n':
+		return true
+	}
+	return false
+}
+
+// SSML wraps the given SSML fragments in a speak element
+func SSML(fragments ...string) string {
+	return "<speak>" + strings.Join(fragments, "") + "</speak>"
+}
+
+// Text escapes plain text for inclusion in SSML
+func Text(s string) string {
+	return html.EscapeString(s)
+}
+
+// SayAs returns an SSML say-as element
+func SayAs(interpretAs, format, text string) string {
+	if format != "" {
+		return fmt.Sprintf(`<say-as interpret-as="%s" format="%s">%s</say-as>`, interpretAs, format, Text(text))
+	}
+	return fmt.Sprintf(`<say-as interpret-as="%s">%s</say-as>`, interpretAs, Text(text))
+}
+
+// Time returns SSML which speaks the time of day, on either the 12-hour or
+// the 24-hour clock
+func Time(t time.Time, clock24 bool) string {
+	if clock24 {
+		return SayAs("time", "hms24", t.Format("15:04"))
+	}
+	return SayAs("time", "hms12", t.Format("3:04pm"))
+}
+
+// Number returns SSML which speaks a cardinal number
+func Number(n int) string {
+	return SayAs("cardinal", "", strconv.Itoa(n))
+}
+
+// Digits returns SSML which speaks each character of the text individually
+func Digits(s string) string {
+	return SayAs("characters", "", s)
+}
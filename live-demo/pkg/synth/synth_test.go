@@ -0,0 +1,36 @@
+package synth
+
+import "testing"
+
+func TestIsSSML(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"<speak>Hello</speak>", true},
+		{"  <speak>Hello</speak>\n", true},
+		{`<speak version="1.0" xml:lang="en-US">Hello</speak>`, true},
+		{"<speak\n  xmlns=\"http://www.w3.org/2001/10/synthesis\">Hello</speak>", true},
+		{"<speak\tversion=\"1.0\">Hello</speak>", true},
+		{"<speak/>", true},
+		{"Hello", false},
+		{"", false},
+		{"<speak", false},
+		{"<speaker>Hello</speaker>", false},
+		{"Say <speak>Hello</speak>", false},
+	}
+	for _, tt := range tests {
+		if got := IsSSML(tt.text); got != tt.want {
+			t.Errorf("IsSSML(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestApplyKeepsSpeakAttributes(t *testing.T) {
+	l := Lexicon{"k8s": {Alias: "kubernetes"}}
+	in := `<speak version="1.0">Run k8s</speak>`
+	want := `<speak version="1.0">Run <sub alias="kubernetes">k8s</sub></speak>`
+	if got := l.Apply(in); got != want {
+		t.Errorf("Apply(%q) = %q, want %q", in, got, want)
+	}
+}
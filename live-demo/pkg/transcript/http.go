@@ -0,0 +1,64 @@
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Handler serves the transcript identified by the last element of the
+// request path (e.g. /transcripts/<uuid>).  The transcript is returned as
+// JSON, or as plain text, one line per utterance, if the format=text query
+// parameter is given.
+type Handler struct {
+	Store *RedisStore
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if id == "" {
+		http.Error(w, "transcript ID required", http.StatusBadRequest)
+		return
+	}
+
+	list, err := h.Store.Load(r.Context(), id)
+	if err == ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("failed to retrieve transcript:", err)
+		http.Error(w, "failed to retrieve transcript", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, c := range list {
+			speaker := c.Speaker
+			if c.SpeakerID != "" {
+				speaker += " (" + c.SpeakerID + ")"
+			}
+			at := c.Start
+			if at.IsZero() {
+				at = c.End
+			}
+			fmt.Fprintf(w, "[%s] %s: %s\n", at.UTC().Format(time.RFC3339), speaker, c.Text) // nolint: errcheck
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(list); err != nil {
+		log.Println("failed to encode transcript:", err)
+	}
+}
@@ -0,0 +1,114 @@
+// Package transcript keeps the transcripts of existing calls which are
+// transcribed by the voice service.  Each party to such a call is carried to
+// the voice service on its own AudioSocket leg, and the final captions of
+// every leg are collected here under their shared transcript ID, ordered by
+// the time at which their speech began.
+package transcript
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/caption"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// TTL is the time for which a transcript is kept after its last caption
+const TTL = 24 * time.Hour
+
+// KeyPrefix is the prefix of the Redis keys of the transcripts.  The full key
+// is the prefix followed by the transcript ID.
+const KeyPrefix = "audiosocket:transcript:"
+
+// StoreTimeout is the maximum time to spend storing a caption
+const StoreTimeout = 5 * time.Second
+
+// ErrNotFound indicates that there is no such transcript
+var ErrNotFound = errors.New("transcript not found")
+
+// RedisStore stores transcripts in Redis, where the captions of the legs of
+// a call, which may be handled by different replicas, are combined.  Each
+// transcript is a sorted set of captions, scored by the start of their
+// speech.
+type RedisStore struct {
+	c *redis.Client
+}
+
+// NewRedisStore returns a RedisStore backed by the Redis server at the given
+// address
+func NewRedisStore(addr string) *RedisStore {
+	return &RedisStore{
+		c: redis.NewClient(&redis.Options{
+			Addr: addr,
+		}),
+	}
+}
+
+// Publish implements caption.Publisher, storing the final captions of
+// transcribed calls.  Other captions are ignored.
+func (s *RedisStore) Publish(c *caption.Caption) error {
+	if !c.Final || c.Transcript == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), StoreTimeout)
+	defer cancel()
+	return s.Append(ctx, c)
+}
+
+// Append adds a caption to its transcript
+func (s *RedisStore) Append(ctx context.Context, c *caption.Caption) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode caption")
+	}
+
+	key := KeyPrefix + c.Transcript
+	p := s.c.WithContext(ctx).TxPipeline()
+	p.ZAdd(key, redis.Z{Score: score(c), Member: data})
+	p.Expire(key, TTL)
+	_, err = p.Exec()
+	return errors.Wrap(err, "failed to store caption")
+}
+
+// score returns the score of a caption within its transcript: the time at
+// which its speech began, or else ended, or else the time of the caption
+func score(c *caption.Caption) float64 {
+	t := c.Start
+	if t.IsZero() {
+		t = c.End
+	}
+	if t.IsZero() {
+		t = c.Time
+	}
+	return float64(t.UnixNano())
+}
+
+// Load returns the captions of a transcript in the order in which they were
+// spoken, or ErrNotFound
+func (s *RedisStore) Load(ctx context.Context, id string) ([]*caption.Caption, error) {
+	list, err := s.c.WithContext(ctx).ZRange(KeyPrefix+id, 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve transcript")
+	}
+	if len(list) < 1 {
+		return nil, ErrNotFound
+	}
+
+	out := make([]*caption.Caption, 0, len(list))
+	for _, data := range list {
+		c := new(caption.Caption)
+		if err = json.Unmarshal([]byte(data), c); err != nil {
+			return nil, errors.Wrap(err, "failed to decode caption")
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// Close closes the connection to Redis
+func (s *RedisStore) Close() error {
+	return s.c.Close()
+}
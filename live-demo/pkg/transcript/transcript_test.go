@@ -0,0 +1,42 @@
+package transcript
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/asterisk-k8s-demo/live-demo/pkg/caption"
+)
+
+func TestScoreOrdersBySpeechStart(t *testing.T) {
+	t0 := time.Date(2019, 10, 29, 14, 0, 0, 0, time.UTC)
+	at := func(s float64) time.Time {
+		return t0.Add(time.Duration(s * float64(time.Second)))
+	}
+
+	// The caller talks at length while the callee interjects, so the
+	// callee's short utterance ends before the caller's long one, which
+	// began first.
+	captions := []*caption.Caption{
+		{Speaker: caption.SpeakerCallee, Text: "mm-hmm", Start: at(3), End: at(4)},
+		{Speaker: caption.SpeakerCaller, Text: "and then", Start: at(6), End: at(7)},
+		{Speaker: caption.SpeakerCaller, Text: "so what happened was", Start: at(1), End: at(5)},
+		{Speaker: caption.SpeakerCallee, Text: "right", Start: at(5.5), End: at(6)},
+
+		// Captions from before the start was recorded fall back to their
+		// end, and then to their time
+		{Speaker: caption.SpeakerCaller, Text: "hello", End: at(0.5)},
+		{Speaker: caption.SpeakerCallee, Text: "hi", Time: at(0.8)},
+	}
+	want := []string{"hello", "hi", "so what happened was", "mm-hmm", "right", "and then"}
+
+	sort.Slice(captions, func(i, j int) bool {
+		return score(captions[i]) < score(captions[j])
+	})
+
+	for i, c := range captions {
+		if c.Text != want[i] {
+			t.Errorf("caption %d is %q, want %q", i, c.Text, want[i])
+		}
+	}
+}
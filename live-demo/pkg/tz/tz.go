@@ -0,0 +1,297 @@
+// Package tz determines the time zone in which a caller is interested, from
+// the place they asked about, their caller ID or a configured default.
+package tz
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPrefixes maps international dialing prefixes (country code, plus
+// area code for the North American Numbering Plan) to time zones
+var DefaultPrefixes = map[string]string{
+	// NANP area codes
+	"1202": "America/New_York",
+	"1212": "America/New_York",
+	"1305": "America/New_York",
+	"1404": "America/New_York",
+	"1470": "America/New_York",
+	"1617": "America/New_York",
+	"1646": "America/New_York",
+	"1678": "America/New_York",
+	"1718": "America/New_York",
+	"1770": "America/New_York",
+	"1917": "America/New_York",
+	"1416": "America/Toronto",
+	"1214": "America/Chicago",
+	"1312": "America/Chicago",
+	"1512": "America/Chicago",
+	"1713": "America/Chicago",
+	"1303": "America/Denver",
+	"1801": "America/Denver",
+	"1602": "America/Phoenix",
+	"1206": "America/Los_Angeles",
+	"1213": "America/Los_Angeles",
+	"1310": "America/Los_Angeles",
+	"1415": "America/Los_Angeles",
+	"1503": "America/Los_Angeles",
+	"1604": "America/Vancouver",
+	"1907": "America/Anchorage",
+	"1808": "Pacific/Honolulu",
+
+	// Country codes
+	"7":  "Europe/Moscow",
+	"31": "Europe/Amsterdam",
+	"33": "Europe/Paris",
+	"34": "Europe/Madrid",
+	"39": "Europe/Rome",
+	"41": "Europe/Zurich",
+	"43": "Europe/Vienna",
+	"44": "Europe/London",
+	"49": "Europe/Berlin",
+	"52": "America/Mexico_City",
+	"54": "America/Argentina/Buenos_Aires",
+	"55": "America/Sao_Paulo",
+	"61": "Australia/Sydney",
+	"65": "Asia/Singapore",
+	"81": "Asia/Tokyo",
+	"82": "Asia/Seoul",
+	"86": "Asia/Shanghai",
+	"91": "Asia/Kolkata",
+}
+
+// DefaultPlaces maps the names of places which a caller may ask about,
+// in each supported language, to time zones
+var DefaultPlaces = map[string]string{
+	"amsterdam":        "Europe/Amsterdam",
+	"atlanta":          "America/New_York",
+	"barcelona":        "Europe/Madrid",
+	"berlin":           "Europe/Berlin",
+	"boston":           "America/New_York",
+	"buenos aires":     "America/Argentina/Buenos_Aires",
+	"chicago":          "America/Chicago",
+	"dallas":           "America/Chicago",
+	"denver":           "America/Denver",
+	"hawaii":           "Pacific/Honolulu",
+	"honolulu":         "Pacific/Honolulu",
+	"hong kong":        "Asia/Hong_Kong",
+	"london":           "Europe/London",
+	"londres":          "Europe/London",
+	"los angeles":      "America/Los_Angeles",
+	"madrid":           "Europe/Madrid",
+	"mexico city":      "America/Mexico_City",
+	"ciudad de méxico": "America/Mexico_City",
+	"moscow":           "Europe/Moscow",
+	"moscú":            "Europe/Moscow",
+	"moskau":           "Europe/Moscow",
+	"munich":           "Europe/Berlin",
+	"münchen":          "Europe/Berlin",
+	"new york":         "America/New_York",
+	"nueva york":       "America/New_York",
+	"paris":            "Europe/Paris",
+	"parís":            "Europe/Paris",
+	"rome":             "Europe/Rome",
+	"roma":             "Europe/Rome",
+	"rom":              "Europe/Rome",
+	"san francisco":    "America/Los_Angeles",
+	"seattle":          "America/Los_Angeles",
+	"seoul":            "Asia/Seoul",
+	"shanghai":         "Asia/Shanghai",
+	"singapore":        "Asia/Singapore",
+	"sydney":           "Australia/Sydney",
+	"tokyo":            "Asia/Tokyo",
+	"tokio":            "Asia/Tokyo",
+	"toronto":          "America/Toronto",
+	"utc":              "UTC",
+	"vienna":           "Europe/Vienna",
+	"viena":            "Europe/Vienna",
+	"wien":             "Europe/Vienna",
+	"zurich":           "Europe/Zurich",
+	"zürich":           "Europe/Zurich",
+}
+
+// Resolver determines the time zones of callers
+type Resolver struct {
+	// Default is the zone used when no other is known
+	Default *time.Location
+
+	// Prefixes maps dialing prefixes to zones.  The longest matching
+	// prefix is used.
+	Prefixes map[string]*time.Location
+
+	// Places maps lower-case place names to zones
+	Places map[string]*time.Location
+
+	// CountryCode is the country code of callers whose caller ID is in
+	// national form, such as "1" for the North American Numbering Plan
+	CountryCode string
+}
+
+// DefaultCountryCode is the country code of national caller IDs when none is
+// configured
+const DefaultCountryCode = "1"
+
+// minInternationalDigits is the least number of digits, including the
+// country code, of a caller ID in international form.  Shorter caller IDs,
+// such as extensions, have no zone.
+const minInternationalDigits = 8
+
+// FromEnv returns a Resolver with the default prefixes and places, the
+// default zone given by the DEFAULT_TIME_ZONE environment variable (or UTC),
+// any additional prefixes given by the TIME_ZONE_BY_PREFIX environment
+// variable, in the form "prefix=zone,prefix=zone", and the country code of
+// national caller IDs given by the TIME_ZONE_COUNTRY_CODE environment
+// variable (or DefaultCountryCode).
+func FromEnv() (*Resolver, error) {
+	r := &Resolver{
+		Default:     time.UTC,
+		Prefixes:    make(map[string]*time.Location),
+		Places:      make(map[string]*time.Location),
+		CountryCode: DefaultCountryCode,
+	}
+	if cc := strings.TrimPrefix(os.Getenv("TIME_ZONE_COUNTRY_CODE"), "+"); cc != "" {
+		r.CountryCode = cc
+	}
+
+	var err error
+	if name := os.Getenv("DEFAULT_TIME_ZONE"); name != "" {
+		if r.Default, err = time.LoadLocation(name); err != nil {
+			return nil, errors.Wrapf(err, "failed to load default time zone %s", name)
+		}
+	}
+
+	prefixes := make(map[string]string, len(DefaultPrefixes))
+	for p, name := range DefaultPrefixes {
+		prefixes[p] = name
+	}
+	for _, entry := range strings.Split(os.Getenv("TIME_ZONE_BY_PREFIX"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pieces := strings.SplitN(entry, "=", 2)
+		if len(pieces) != 2 {
+			return nil, errors.Errorf("invalid time zone prefix entry %q", entry)
+		}
+		prefixes[strings.TrimSpace(pieces[0])] = strings.TrimSpace(pieces[1])
+	}
+
+	if err = load(r.Prefixes, prefixes); err != nil {
+		return nil, err
+	}
+	if err = load(r.Places, DefaultPlaces); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func load(out map[string]*time.Location, in map[string]string) error {
+	for k, name := range in {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load time zone %s", name)
+		}
+		out[k] = loc
+	}
+	return nil
+}
+
+// ForCallerID returns the zone of the given caller ID, or nil if it is not
+// known.  See Normalize for the forms of caller ID which are understood.
+func (r *Resolver) ForCallerID(callerID string) *time.Location {
+	num := r.Normalize(callerID)
+	for i := len(num); i > 0; i-- {
+		if loc, ok := r.Prefixes[num[:i]]; ok {
+			return loc
+		}
+	}
+	return nil
+}
+
+// ForPhrase returns the first known place named in the phrase, along with
+// its zone.  It returns nil if the phrase names no known place.
+func (r *Resolver) ForPhrase(phrase string) (string, *time.Location) {
+	phrase = " " + strings.Join(strings.Fields(punctuation.Replace(strings.ToLower(phrase))), " ") + " "
+
+	var (
+		place string
+		found *time.Location
+		pos   = len(phrase)
+	)
+	for name, loc := range r.Places {
+		i := strings.Index(phrase, " "+name+" ")
+		if i < 0 {
+			continue
+		}
+		// Prefer the earliest, then the longest, name
+		if i < pos || (i == pos && len(name) > len(place)) {
+			place, found, pos = name, loc, i
+		}
+	}
+	return place, found
+}
+
+// Resolve returns the zone for a caller, preferring a place named in their
+// phrase, then their caller ID, then the default.  The place is returned if
+// the zone was taken from the phrase.
+func (r *Resolver) Resolve(callerID, phrase string) (string, *time.Location) {
+	if place, loc := r.ForPhrase(phrase); loc != nil {
+		return place, loc
+	}
+	if loc := r.ForCallerID(callerID); loc != nil {
+		return "", loc
+	}
+	return "", r.Default
+}
+
+var punctuation = strings.NewReplacer(".", " ", ",", " ", "?", " ", "!", " ", "¿", " ", "¡", " ")
+
+// Normalize returns the caller ID in international form, as digits without
+// a leading "+", or an empty string if its country cannot be determined.
+// Caller IDs are understood in these forms:
+//
+//   - international, with a leading "+" or international access code ("00",
+//     or "011" within the North American Numbering Plan)
+//   - national, within the country of CountryCode: ten-digit or 1-prefixed
+//     eleven-digit NANP numbers, or elsewhere numbers with a leading trunk
+//     prefix "0"
+//   - international without any prefix, as some carriers send them
+func (r *Resolver) Normalize(callerID string) string {
+	callerID = strings.TrimSpace(callerID)
+
+	var b strings.Builder
+	for _, c := range callerID {
+		if c >= '0' && c <= '9' {
+			b.WriteRune(c)
+		}
+	}
+	num := b.String()
+
+	nanp := r.CountryCode == "1"
+	switch {
+	case strings.HasPrefix(callerID, "+"):
+	case strings.HasPrefix(num, "00"):
+		num = num[2:]
+	case nanp && strings.HasPrefix(num, "011"):
+		num = num[3:]
+	case nanp && len(num) == 10 && isNANP(num):
+		num = "1" + num
+	case nanp && len(num) == 11 && num[0] == '1' && isNANP(num[1:]):
+	case !nanp && strings.HasPrefix(num, "0"):
+		num = r.CountryCode + num[1:]
+	}
+
+	if len(num) < minInternationalDigits {
+		return ""
+	}
+	return num
+}
+
+// isNANP indicates whether the ten digits are a valid number of the North
+// American Numbering Plan, whose area code and exchange each begin with 2-9
+func isNANP(num string) bool {
+	return num[0] >= '2' && num[3] >= '2'
+}
@@ -0,0 +1,94 @@
+package tz
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	nanp := &Resolver{CountryCode: "1"}
+	de := &Resolver{CountryCode: "49"}
+
+	tests := []struct {
+		r        *Resolver
+		callerID string
+		want     string
+	}{
+		// International forms
+		{nanp, "+14045551234", "14045551234"},
+		{nanp, "+44 20 7946 0000", "442079460000"},
+		{nanp, "0044 20 7946 0000", "442079460000"},
+		{nanp, "011 44 20 7946 0000", "442079460000"},
+		{de, "+1 (404) 555-1234", "14045551234"},
+		{de, "0033 1 23 45 67 89", "33123456789"},
+
+		// National forms within the NANP
+		{nanp, "4045551234", "14045551234"},
+		{nanp, "(404) 555-1234", "14045551234"},
+		{nanp, "14045551234", "14045551234"},
+		{nanp, "1-404-555-1234", "14045551234"},
+
+		// National forms elsewhere
+		{de, "030 1234567", "49301234567"},
+		{de, "089/12345678", "498912345678"},
+
+		// International without a prefix
+		{nanp, "442079460000", "442079460000"},
+		{nanp, "3120123456", "3120123456"},
+		{de, "442079460000", "442079460000"},
+
+		// Unknown
+		{nanp, "", ""},
+		{nanp, "anonymous", ""},
+		{nanp, "1000", ""},
+		{nanp, "7001", ""},
+		{nanp, "5551234", ""},
+		{de, "0301", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Normalize(tt.callerID); got != tt.want {
+			t.Errorf("%s: Normalize(%q) = %q, want %q", tt.r.CountryCode, tt.callerID, got, tt.want)
+		}
+	}
+}
+
+func TestForCallerID(t *testing.T) {
+	r := &Resolver{
+		Default:     time.UTC,
+		Prefixes:    make(map[string]*time.Location),
+		CountryCode: "1",
+	}
+	if err := load(r.Prefixes, DefaultPrefixes); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		callerID string
+		want     string
+	}{
+		{"4045551234", "America/New_York"},
+		{"+1 312 555 1234", "America/Chicago"},
+		{"+44 20 7946 0000", "Europe/London"},
+		{"0049 30 1234567", "Europe/Berlin"},
+		{"+81 3 1234 5678", "Asia/Tokyo"},
+
+		// Dutch, not Chicago (312)
+		{"3120123456", "Europe/Amsterdam"},
+
+		// An extension, not Moscow (7)
+		{"7001", ""},
+
+		// A NANP area code which is not listed
+		{"5055551234", ""},
+	}
+	for _, tt := range tests {
+		loc := r.ForCallerID(tt.callerID)
+		var got string
+		if loc != nil {
+			got = loc.String()
+		}
+		if got != tt.want {
+			t.Errorf("ForCallerID(%q) = %q, want %q", tt.callerID, got, tt.want)
+		}
+	}
+}